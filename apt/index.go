@@ -0,0 +1,136 @@
+package apt
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/thepwagner/debendabot/dpkg"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+// Source is a repository suite that provides Packages indexes.
+type Source struct {
+	// Name identifies the source in output, e.g. the distro suite or dpkg.json repository name.
+	Name string
+	// Repository is the name of the dpkg.json repository, empty for the distro.
+	Repository string
+	URI        string
+	Suite      string
+	Components []string
+}
+
+// Sources returns the repositories a manifest builds from: the distro's main component with its updates, then any
+// dpkg.json repositories. The live mirrors are used even if the lockfile is pinned to a snapshot.
+func Sources(mf manifest.Manifest) []Source {
+	mirror := mf.DpkgJSON.Mirror
+	if mirror == "" {
+		mirror = manifest.DefaultMirror
+	}
+	return MirrorSources(mf, mirror, manifest.DefaultSecurityMirror)
+}
+
+// MirrorSources returns the repositories a manifest builds from, using mirror and securityMirror for the distro.
+func MirrorSources(mf manifest.Manifest, mirror, securityMirror string) []Source {
+	var ret []Source
+	for _, suite := range mf.DpkgJSON.DistroSuites(mirror, securityMirror) {
+		ret = append(ret, Source{
+			Name:       suite.Suite,
+			URI:        suite.URI,
+			Suite:      suite.Suite,
+			Components: []string{"main"},
+		})
+	}
+	for _, repo := range mf.DpkgJSON.Repositories {
+		ret = append(ret, Source{
+			Name:       repo.Name,
+			Repository: repo.Name,
+			URI:        repo.URI,
+			Suite:      repo.Suite,
+			Components: repo.Components,
+		})
+	}
+	return ret
+}
+
+// Client fetches repository indexes over HTTP.
+// Release signatures are not verified, the indexes are only used for reporting.
+type Client struct {
+	http *http.Client
+}
+
+func NewClient(c *http.Client) *Client {
+	return &Client{http: c}
+}
+
+// Packages streams every package available to an architecture from a source.
+func (c *Client) Packages(ctx context.Context, src Source, arch string, fn func(dpkg.Paragraph) error) error {
+	for _, component := range src.Components {
+		url := fmt.Sprintf("%s/dists/%s/%s/binary-%s/Packages.gz", strings.TrimSuffix(src.URI, "/"), src.Suite, component, arch)
+		if err := c.readIndex(ctx, url, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) readIndex(ctx context.Context, url string, fn func(dpkg.Paragraph) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching %q: %w", url, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %q: %s", url, res.Status)
+	}
+
+	gz, err := gzip.NewReader(res.Body)
+	if err != nil {
+		return fmt.Errorf("decompressing %q: %w", url, err)
+	}
+	defer gz.Close()
+	if err := dpkg.ReadControl(gz, fn); err != nil {
+		return fmt.Errorf("parsing %q: %w", url, err)
+	}
+	return nil
+}
+
+// Candidate is the newest available version of a package.
+type Candidate struct {
+	Version dpkg.Version
+	// Source is the name of the Source that provides the version.
+	Source string
+	// Suite is the suite of the Source that provides the version.
+	Suite string
+}
+
+// Candidates returns the newest version of each named package available to an architecture.
+func (c *Client) Candidates(ctx context.Context, sources []Source, arch string, names map[string]bool) (map[string]Candidate, error) {
+	ret := make(map[string]Candidate, len(names))
+	for _, src := range sources {
+		err := c.Packages(ctx, src, arch, func(p dpkg.Paragraph) error {
+			name := p["Package"]
+			if !names[name] || (p["Architecture"] != arch && p["Architecture"] != "all") {
+				return nil
+			}
+			v, err := dpkg.ParseVersion(p["Version"])
+			if err != nil {
+				return fmt.Errorf("package %q: %w", name, err)
+			}
+			if cur, ok := ret[name]; !ok || v.Compare(cur.Version) > 0 {
+				ret[name] = Candidate{Version: v, Source: src.Name, Suite: src.Suite}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
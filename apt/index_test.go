@@ -0,0 +1,57 @@
+package apt_test
+
+import (
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/apt"
+)
+
+const packagesIndex = `Package: bash
+Version: 5.0-4
+Architecture: amd64
+
+Package: bash
+Version: 5.0-4+deb10u1
+Architecture: amd64
+
+Package: bash
+Version: 6.0-1
+Architecture: arm64
+
+Package: adduser
+Version: 3.118
+Architecture: all
+`
+
+func TestClient_Candidates(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/debian/dists/buster/main/binary-amd64/Packages.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		gz := gzip.NewWriter(w)
+		_, _ = gz.Write([]byte(packagesIndex))
+		_ = gz.Close()
+	}))
+	defer srv.Close()
+
+	c := apt.NewClient(srv.Client())
+	sources := []apt.Source{{Name: "buster", URI: srv.URL + "/debian/", Suite: "buster", Components: []string{"main"}}}
+	candidates, err := c.Candidates(context.Background(), sources, "amd64", map[string]bool{"bash": true, "adduser": true})
+	require.NoError(t, err)
+
+	assert.Len(t, candidates, 2)
+	assert.Equal(t, "5.0-4+deb10u1", candidates["bash"].Version.String())
+	assert.Equal(t, "buster", candidates["bash"].Source)
+	assert.Equal(t, "buster", candidates["bash"].Suite)
+	assert.Equal(t, "3.118", candidates["adduser"].Version.String())
+
+	_, err = c.Candidates(context.Background(), sources, "arm64", map[string]bool{"bash": true})
+	assert.Error(t, err)
+}
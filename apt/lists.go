@@ -0,0 +1,185 @@
+package apt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/thepwagner/debendabot/dpkg"
+)
+
+// ListsPath contains the indexes downloaded by apt-get update.
+const ListsPath = "/var/lib/apt/lists"
+
+// ListingKey identifies a package version within the indexes.
+type ListingKey struct {
+	Package      string
+	Version      string
+	Architecture string
+}
+
+// Listing locates a package version within the indexes.
+type Listing struct {
+	Suite     string
+	Component string
+	// Origin is the repository's Release origin, e.g. "Debian".
+	Origin string
+	// Codename is the Release codename of Suite, e.g. "bookworm" for "stable".
+	Codename string
+	// Filename is the pool path of the .deb, relative to the repository URI.
+	Filename string
+	Size     int64
+}
+
+// Lists collects package listings from the files of ListsPath.
+type Lists struct {
+	// releases are Release files by name, see ListIndex.
+	releases map[string]dpkg.Paragraph
+	listings map[ListingKey]listing
+}
+
+type listing struct {
+	Listing
+	release string
+}
+
+func NewLists() *Lists {
+	return &Lists{
+		releases: map[string]dpkg.Paragraph{},
+		listings: map[ListingKey]listing{},
+	}
+}
+
+// Add reads a file from ListsPath, ignoring files that aren't Packages indexes or Release files.
+func (l *Lists) Add(name string, r io.Reader) error {
+	name = path.Base(name)
+	if release, ok := releaseName(name); ok {
+		p, err := ParseRelease(r)
+		if err != nil {
+			return fmt.Errorf("parsing %q: %w", name, err)
+		}
+		l.releases[release] = p
+		return nil
+	}
+
+	index, ok := ParseListName(name)
+	if !ok {
+		return nil
+	}
+	err := dpkg.ReadControl(r, func(p dpkg.Paragraph) error {
+		key := ListingKey{Package: p["Package"], Version: p["Version"], Architecture: p["Architecture"]}
+		if _, ok := l.listings[key]; ok {
+			return nil
+		}
+		var size int64
+		if s := p["Size"]; s != "" {
+			var err error
+			if size, err = strconv.ParseInt(s, 10, 64); err != nil {
+				return fmt.Errorf("package %q: invalid size %q", key.Package, s)
+			}
+		}
+		l.listings[key] = listing{
+			Listing: Listing{
+				Suite:     index.Suite,
+				Component: index.Component,
+				Filename:  p["Filename"],
+				Size:      size,
+			},
+			release: index.Release,
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("parsing %q: %w", name, err)
+	}
+	return nil
+}
+
+// Listings returns every package version, with the origin and codename of its release. Versions listed by multiple indexes
+// are located in the first index added.
+func (l *Lists) Listings() map[ListingKey]Listing {
+	ret := make(map[ListingKey]Listing, len(l.listings))
+	for key, listed := range l.listings {
+		release := l.releases[listed.release]
+		listed.Origin, listed.Codename = release["Origin"], release["Codename"]
+		ret[key] = listed.Listing
+	}
+	return ret
+}
+
+// ListIndex locates a Packages index within ListsPath.
+type ListIndex struct {
+	// Release is the name of the suite's Release file, less the "_InRelease" or "_Release" suffix.
+	Release   string
+	Suite     string
+	Component string
+}
+
+// ParseListName parses the name of a Packages index, e.g. "deb.debian.org_debian_dists_buster_main_binary-amd64_Packages".
+// apt escapes underscores within the URI, so they only separate path elements.
+func ParseListName(name string) (ListIndex, bool) {
+	trimmed := strings.TrimSuffix(name, "_Packages")
+	if trimmed == name {
+		return ListIndex{}, false
+	}
+	i := strings.LastIndex(trimmed, "_dists_")
+	if i < 0 {
+		// Flat repositories have no suite or components:
+		return ListIndex{Release: trimmed}, true
+	}
+	parts := strings.Split(trimmed[i+len("_dists_"):], "_")
+	if len(parts) < 3 || !strings.HasPrefix(parts[len(parts)-1], "binary-") {
+		return ListIndex{}, false
+	}
+	suite := parts[:len(parts)-2]
+	return ListIndex{
+		Release:   trimmed[:i] + "_dists_" + strings.Join(suite, "_"),
+		Suite:     strings.Join(suite, "/"),
+		Component: parts[len(parts)-2],
+	}, true
+}
+
+func releaseName(name string) (string, bool) {
+	for _, suffix := range []string{"_InRelease", "_Release"} {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix), true
+		}
+	}
+	return "", false
+}
+
+var (
+	clearsignHeader    = []byte("-----BEGIN PGP SIGNED MESSAGE-----")
+	clearsignSignature = []byte("\n-----BEGIN PGP SIGNATURE-----")
+)
+
+// ParseRelease parses a Release or InRelease file. The signature of InRelease files is not verified.
+func ParseRelease(r io.Reader) (dpkg.Paragraph, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(b, clearsignHeader) {
+		// Skip the armor headers, which end with a blank line:
+		i := bytes.Index(b, []byte("\n\n"))
+		if i < 0 {
+			return nil, fmt.Errorf("invalid signed message")
+		}
+		b = b[i+2:]
+		if i := bytes.Index(b, clearsignSignature); i >= 0 {
+			b = b[:i]
+		}
+	}
+	paragraphs, err := dpkg.ParseControl(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	if len(paragraphs) == 0 {
+		return nil, fmt.Errorf("release is empty")
+	}
+	return paragraphs[0], nil
+}
@@ -0,0 +1,92 @@
+package apt_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/apt"
+)
+
+const inRelease = `-----BEGIN PGP SIGNED MESSAGE-----
+Hash: SHA256
+
+Origin: Debian
+Label: Debian-Security
+Suite: oldstable-security
+Codename: buster
+-----BEGIN PGP SIGNATURE-----
+
+iQIzBAEBCAAdFiEE
+-----END PGP SIGNATURE-----
+`
+
+func TestLists(t *testing.T) {
+	lists := apt.NewLists()
+	files := map[string]string{
+		"lists/deb.debian.org_debian_dists_buster_InRelease": "Origin: Debian\nSuite: oldstable\n",
+		"lists/deb.debian.org_debian_dists_buster_main_binary-amd64_Packages": `Package: bsdutils
+Source: util-linux (2.33.1-0.1)
+Version: 1:2.33.1-0.1
+Architecture: amd64
+Filename: pool/main/u/util-linux/bsdutils_2.33.1-0.1_amd64.deb
+Size: 97584
+`,
+		"lists/security.debian.org_debian-security_dists_buster_updates_InRelease": inRelease,
+		"lists/security.debian.org_debian-security_dists_buster_updates_main_binary-amd64_Packages": `Package: libc6
+Version: 2.28-10+deb10u1
+Architecture: amd64
+Filename: pool/updates/main/g/glibc/libc6_2.28-10+deb10u1_amd64.deb
+Size: 2868728
+`,
+		"lists/lock": "",
+	}
+	for name, content := range files {
+		require.NoError(t, lists.Add(name, strings.NewReader(content)))
+	}
+
+	assert.Equal(t, map[apt.ListingKey]apt.Listing{
+		{Package: "bsdutils", Version: "1:2.33.1-0.1", Architecture: "amd64"}: {
+			Suite:     "buster",
+			Component: "main",
+			Origin:    "Debian",
+			Filename:  "pool/main/u/util-linux/bsdutils_2.33.1-0.1_amd64.deb",
+			Size:      97584,
+		},
+		{Package: "libc6", Version: "2.28-10+deb10u1", Architecture: "amd64"}: {
+			Suite:     "buster/updates",
+			Component: "main",
+			Origin:    "Debian",
+			Codename:  "buster",
+			Filename:  "pool/updates/main/g/glibc/libc6_2.28-10+deb10u1_amd64.deb",
+			Size:      2868728,
+		},
+	}, lists.Listings())
+}
+
+func TestParseListName(t *testing.T) {
+	cases := map[string]apt.ListIndex{
+		"deb.debian.org_debian_dists_buster_main_binary-arm64_Packages": {
+			Release:   "deb.debian.org_debian_dists_buster",
+			Suite:     "buster",
+			Component: "main",
+		},
+		"example.com_repo_._Packages": {Release: "example.com_repo_."},
+	}
+	for name, expected := range cases {
+		index, ok := apt.ParseListName(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, expected, index, name)
+	}
+
+	_, ok := apt.ParseListName("deb.debian.org_debian_dists_buster_InRelease")
+	assert.False(t, ok)
+}
+
+func TestParseRelease(t *testing.T) {
+	release, err := apt.ParseRelease(strings.NewReader(inRelease))
+	require.NoError(t, err)
+	assert.Equal(t, "Debian", release["Origin"])
+	assert.Equal(t, "buster", release["Codename"])
+}
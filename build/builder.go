@@ -1,224 +1,182 @@
 package build
 
 import (
-	"archive/tar"
-	"bytes"
 	"context"
 	"fmt"
-	"io"
-	"io/ioutil"
-	"regexp"
-	"strings"
-
-	docker "github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/jsonmessage"
+	"time"
+
 	"github.com/sirupsen/logrus"
+	"github.com/thepwagner/debendabot/dpkg"
 	"github.com/thepwagner/debendabot/manifest"
 )
 
-type Builder struct {
-	docker *client.Client
+// Backend assembles the rootfs of a manifest for a single architecture.
+type Backend interface {
+	Build(ctx context.Context, mf manifest.Manifest, arch string) error
+	// ExportTarball writes a built rootfs as a tarball to path.
+	ExportTarball(ctx context.Context, mf manifest.Manifest, arch, path string) error
 }
 
-func NewBuilder(docker *client.Client) *Builder {
-	return &Builder{docker: docker}
+// Locker is implemented by backends that can resolve a manifest to locked packages.
+type Locker interface {
+	// BaseImage returns the pinned image the rootfs is bootstrapped from.
+	BaseImage(ctx context.Context, mf manifest.Manifest) (string, error)
+	LockArchitecture(ctx context.Context, mf manifest.Manifest, arch string) (map[manifest.PackageName]manifest.LockedPackage, error)
+	// Changelogs returns the Debian changelogs of packages from the most recent lock of an architecture.
+	Changelogs(ctx context.Context, mf manifest.Manifest, arch string) (map[manifest.PackageName][]dpkg.ChangelogEntry, error)
 }
 
-func (b *Builder) Build(ctx context.Context, mf manifest.Manifest) error {
-	buildImage := BuildImage(mf)
-	return b.build(ctx, mf, "", buildImage)
+// Rebuilder is implemented by backends that cache build steps, to build again from scratch.
+type Rebuilder interface {
+	Rebuild(ctx context.Context, mf manifest.Manifest, arch string) error
 }
 
-func BuildImage(mf manifest.Manifest) string {
-	return fmt.Sprintf("debendabot-build/%s", mf.DpkgJSON.Image)
+// OwnershipExporter is implemented by backends that may export files without their ownership, e.g. when unprivileged.
+// Their rootfs can't match digests recorded by other backends.
+type OwnershipExporter interface {
+	ExportsOwnership() bool
 }
 
-func (b *Builder) build(ctx context.Context, mf manifest.Manifest, target string, tag string) error {
-	logger := logrus.WithField("image", mf.DpkgJSON.Image)
+type Builder struct {
+	backend Backend
+}
 
-	// Generate Dockerfile and prepare context:
-	dockerfile, err := genDockerfile(mf)
-	if err != nil {
-		return fmt.Errorf("generating dockerfile: %w", err)
-	}
+func NewBuilder(backend Backend) *Builder {
+	return &Builder{backend: backend}
+}
 
-	if logrus.IsLevelEnabled(logrus.DebugLevel) {
-		out := logger.WriterLevel(logrus.DebugLevel)
-		_, _ = fmt.Fprintln(out, "-- Dockerfile")
-		_, _ = out.Write([]byte(dockerfile))
-		_, _ = fmt.Fprintln(out, "-- /Dockerfile")
-		_ = out.Close()
+// Build assembles a rootfs for every architecture in the manifest.
+func (b *Builder) Build(ctx context.Context, mf manifest.Manifest) error {
+	for _, arch := range mf.DpkgJSON.TargetArchitectures() {
+		if err := b.backend.Build(ctx, mf, arch); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	contextTar, err := buildContext(dockerfile)
-	if err != nil {
-		return fmt.Errorf("preparing build context: %w", err)
+// Rebuild assembles a rootfs for every architecture without reusing previous build steps, e.g. to check the build is
+// reproducible.
+func (b *Builder) Rebuild(ctx context.Context, mf manifest.Manifest) error {
+	rebuilder, ok := b.backend.(Rebuilder)
+	if !ok {
+		return b.Build(ctx, mf)
 	}
-
-	// Perform the build:
-	build, err := b.docker.ImageBuild(ctx, contextTar, docker.ImageBuildOptions{
-		Dockerfile: "/Dockerfile",
-		Tags:       []string{tag},
-		Target:     target,
-	})
-	if err != nil {
-		return fmt.Errorf("building image: %w", err)
+	for _, arch := range mf.DpkgJSON.TargetArchitectures() {
+		if err := rebuilder.Rebuild(ctx, mf, arch); err != nil {
+			return err
+		}
 	}
-	defer build.Body.Close()
+	return nil
+}
 
-	var out io.Writer
-	if logrus.IsLevelEnabled(logrus.DebugLevel) {
-		logOut := logger.WriterLevel(logrus.DebugLevel)
-		defer logOut.Close()
-		out = logOut
-	} else {
-		out = ioutil.Discard
-	}
+// ExportTarball writes the rootfs of a built architecture as a tarball to path.
+func (b *Builder) ExportTarball(ctx context.Context, mf manifest.Manifest, arch, path string) error {
+	return b.backend.ExportTarball(ctx, mf, arch, path)
+}
 
-	_, _ = fmt.Fprintln(out, "-- build log")
-	if err := jsonmessage.DisplayJSONMessagesStream(build.Body, out, 0, false, nil); err != nil {
-		return fmt.Errorf("reading build output: %w", err)
+// ExportsOwnership returns true if exported tarballs preserve the ownership of files.
+func (b *Builder) ExportsOwnership() bool {
+	if exporter, ok := b.backend.(OwnershipExporter); ok {
+		return exporter.ExportsOwnership()
 	}
-	_, _ = fmt.Fprintln(out, "-- /build log")
-
-	logger.Info("completed build")
-	return nil
+	return true
 }
 
-var packageLine = regexp.MustCompile("(?P<package>[^/]+)/(?P<release>[^ ]+) (?P<version>[^ ]+) (?P<arch>[^ ]+) (?P<meta>[^ ]+)")
-
-func (b *Builder) Lock(ctx context.Context, mf manifest.Manifest) (*manifest.DpkgLockJSON, error) {
-	manifestImage := fmt.Sprintf("debendabot-manifest/%s", mf.DpkgJSON.Image)
-	if err := b.build(ctx, mf, "manifest", manifestImage); err != nil {
-		return nil, fmt.Errorf("rebuilding manifest: %w", err)
+func (b *Builder) locker() (Locker, error) {
+	locker, ok := b.backend.(Locker)
+	if !ok {
+		return nil, fmt.Errorf("backend %T can't lock packages", b.backend)
 	}
+	return locker, nil
+}
 
-	// Pin the docker parent to a SHA:
-	image, _, err := b.docker.ImageInspectWithRaw(ctx, baseImage(mf))
+func (b *Builder) Lock(ctx context.Context, mf manifest.Manifest) (*manifest.DpkgLockJSON, error) {
+	locker, err := b.locker()
 	if err != nil {
-		return nil, fmt.Errorf("querying manifest image: %w", err)
-	}
-	dpkgLock := &manifest.DpkgLockJSON{
-		Image: image.RepoDigests[0],
+		return nil, err
 	}
 
-	// Extract manifest file:
-	ctr, err := b.docker.ContainerCreate(ctx, &container.Config{
-		Image: manifestImage,
-	}, nil, nil, "")
-	if err != nil {
-		return nil, fmt.Errorf("creating manifest image: %w", err)
+	// Lock against a fresh snapshot, so the build can reproduce it. Packages pinned by the previous lock may not be
+	// in the snapshot, so only the snapshot is kept:
+	previous := mf.DpkgLockJSON
+	var snapshot string
+	if mf.DpkgJSON.Snapshot {
+		snapshot = manifest.SnapshotTimestamp(time.Now())
+		mf.DpkgLockJSON = &manifest.DpkgLockJSON{Snapshot: snapshot}
+		logrus.WithField("snapshot", snapshot).Info("locking against snapshot")
 	}
-	defer func() {
-		err := b.docker.ContainerRemove(ctx, ctr.ID, docker.ContainerRemoveOptions{Force: true})
+
+	arches := mf.DpkgJSON.TargetArchitectures()
+	locked := make(map[string]map[manifest.PackageName]manifest.LockedPackage, len(arches))
+	for _, arch := range arches {
+		pkgs, err := locker.LockArchitecture(ctx, mf, arch)
 		if err != nil {
-			logrus.WithError(err).Warn("error removing manifest container")
+			return nil, fmt.Errorf("locking %s: %w", arch, err)
 		}
-	}()
-
-	aptInstalled, err := b.readFile(ctx, ctr.ID, "/apt-installed.txt")
+		for name := range mf.DpkgJSON.Packages {
+			if pkg, ok := pkgs[name]; ok {
+				pkg.Direct = true
+				pkgs[name] = pkg
+			}
+		}
+		locked[arch] = pkgs
+	}
+	manifestHash, err := mf.DpkgJSON.Hash()
 	if err != nil {
 		return nil, err
 	}
-	pkgList := strings.Split(string(aptInstalled), "\n")
 
-	debHashes, err := b.readFile(ctx, ctr.ID, "/deb-hashes.txt")
+	// Pin the docker parent to a SHA:
+	image, err := locker.BaseImage(ctx, mf)
 	if err != nil {
 		return nil, err
 	}
-	packageHashList := strings.Split(string(debHashes), "\n")
-	type hashedPackage struct {
-		filename string
-		hash     string
-	}
-	packageHashes := make(map[manifest.PackageName]hashedPackage, len(packageHashList))
-	for _, packageHashLine := range packageHashList {
-		if packageHashLine == "" {
-			continue
-		}
-		lineParts := strings.Split(packageHashLine, "  ")
-		packageName := manifest.PackageName(strings.Split(lineParts[1], "_")[0])
-		packageHashes[packageName] = hashedPackage{
-			filename: lineParts[1],
-			hash:     lineParts[0],
-		}
+	dpkgLock := &manifest.DpkgLockJSON{
+		LockfileVersion: manifest.CurrentLockfileVersion,
+		Image:           image,
+		Distro:          mf.DpkgJSON.Distro,
+		ManifestHash:    manifestHash,
+		Snapshot:        snapshot,
+	}
+	if len(mf.DpkgJSON.Architectures) == 0 {
+		dpkgLock.Packages = locked[manifest.DefaultArchitecture]
+	} else {
+		dpkgLock.Architectures = locked
 	}
-
-	dpkgLock.Packages = make(map[manifest.PackageName]manifest.LockedPackage, len(pkgList))
-	for _, installedPackage := range pkgList {
-		if installedPackage == "" {
-			continue
-		}
-		match := packageLine.FindStringSubmatch(installedPackage)
-		if len(match) == 0 {
-			logrus.WithField("line", installedPackage).Warn("unmatched package line")
-			continue
-		}
-
-		var pkg manifest.PackageName
-		var lock manifest.LockedPackage
-		for i, name := range packageLine.SubexpNames() {
-			switch name {
-			case "package":
-				pkg = manifest.PackageName(match[i])
-			case "version":
-				lock.Version = match[i]
-			case "arch":
-				lock.Architecture = match[i]
-			}
-		}
-
-		hash, ok := packageHashes[pkg]
-		if !ok {
-			logrus.WithField("pkg", pkg).Warn("unhashed package")
-		} else {
-			lock.DebFilename = hash.filename
-			lock.DebHash = hash.hash
-		}
-
-		dpkgLock.Packages[pkg] = lock
+	if dpkgLock.SourceDateEpoch, err = sourceDateEpoch(previous, dpkgLock); err != nil {
+		return nil, err
 	}
 	return dpkgLock, nil
 }
 
-func (b *Builder) readFile(ctx context.Context, containerID string, path string) ([]byte, error) {
-	copied, _, err := b.docker.CopyFromContainer(ctx, containerID, path)
-	if err != nil {
-		return nil, fmt.Errorf("copying container file: %w", err)
+// sourceDateEpoch timestamps a lock for reproducible exports: by its snapshot, else by the previous lock if nothing
+// changed, else now.
+func sourceDateEpoch(previous, lock *manifest.DpkgLockJSON) (int64, error) {
+	if lock.Snapshot != "" {
+		t, err := time.Parse(manifest.SnapshotTimestampFormat, lock.Snapshot)
+		if err != nil {
+			return 0, fmt.Errorf("parsing snapshot timestamp: %w", err)
+		}
+		return t.Unix(), nil
 	}
-	defer copied.Close()
-
-	tr := tar.NewReader(copied)
-	// Discard header:
-	if _, err := tr.Next(); err != nil {
-		return nil, fmt.Errorf("reading copied tar: %w", err)
+	if previous != nil && previous.SourceDateEpoch != 0 {
+		diff, err := manifest.DiffLockfiles(previous, lock)
+		if err != nil {
+			return 0, err
+		}
+		if diff.Empty() {
+			return previous.SourceDateEpoch, nil
+		}
 	}
-	return ioutil.ReadAll(tr)
+	return time.Now().Unix(), nil
 }
 
-func buildContext(dockerfile string) (io.Reader, error) {
-	var buf bytes.Buffer
-	tw := tar.NewWriter(&buf)
-
-	// /Dockerfile
-	th := &tar.Header{
-		Name: "Dockerfile",
-		Mode: 0400, // don't trust anybody
-		Size: int64(len(dockerfile)),
-	}
-	if err := tw.WriteHeader(th); err != nil {
-		return nil, fmt.Errorf("writing tar header: %w", err)
-	}
-	_, err := tw.Write([]byte(dockerfile))
+// Changelogs returns the Debian changelogs of packages from the most recent Lock of an architecture.
+func (b *Builder) Changelogs(ctx context.Context, mf manifest.Manifest, arch string) (map[manifest.PackageName][]dpkg.ChangelogEntry, error) {
+	locker, err := b.locker()
 	if err != nil {
-		return nil, fmt.Errorf("writing dockerfile: %w", err)
-	}
-
-	if err := tw.Close(); err != nil {
-		return nil, fmt.Errorf("closing tar: %w", err)
+		return nil, err
 	}
-
-	return &buf, nil
+	return locker.Changelogs(ctx, mf, arch)
 }
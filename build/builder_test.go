@@ -21,7 +21,7 @@ func TestBuilder_Build(t *testing.T) {
 	require.NoError(t, err)
 	defer cli.Close()
 
-	b := build.NewBuilder(cli)
+	b := build.NewBuilder(build.NewDockerBackend(cli, ""))
 
 	ctx := context.Background()
 	m := manifest.Manifest{
@@ -41,7 +41,7 @@ func TestBuilder_Lock(t *testing.T) {
 	require.NoError(t, err)
 	defer cli.Close()
 
-	b := build.NewBuilder(cli)
+	b := build.NewBuilder(build.NewDockerBackend(cli, ""))
 
 	ctx := context.Background()
 	m := manifest.Manifest{
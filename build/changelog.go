@@ -0,0 +1,61 @@
+package build
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/thepwagner/debendabot/dpkg"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+// Changelogs returns the Debian changelogs of packages from the most recent Lock of an architecture.
+// Packages that don't ship a changelog are omitted.
+func (d *DockerBackend) Changelogs(ctx context.Context, mf manifest.Manifest, arch string) (map[manifest.PackageName][]dpkg.ChangelogEntry, error) {
+	ctrID, err := d.createContainer(ctx, platformImage("debendabot-manifest", mf, arch))
+	if err != nil {
+		return nil, err
+	}
+	defer d.removeContainer(ctx, ctrID)
+
+	copied, _, err := d.docker.CopyFromContainer(ctx, ctrID, changelogsPath)
+	if err != nil {
+		return nil, fmt.Errorf("copying changelogs: %w", err)
+	}
+	defer copied.Close()
+
+	ret := map[manifest.PackageName][]dpkg.ChangelogEntry{}
+	tr := tar.NewReader(copied)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return ret, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("reading changelogs: %w", err)
+		}
+		if h.Typeflag != tar.TypeReg || !strings.HasSuffix(h.Name, ".gz") {
+			continue
+		}
+
+		pkg := manifest.PackageName(strings.TrimSuffix(path.Base(h.Name), ".gz"))
+		entries, err := parseGzipChangelog(tr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s changelog: %w", pkg, err)
+		}
+		ret[pkg] = entries
+	}
+}
+
+func parseGzipChangelog(r io.Reader) ([]dpkg.ChangelogEntry, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return dpkg.ParseChangelog(gz)
+}
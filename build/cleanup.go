@@ -0,0 +1,94 @@
+package build
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/thepwagner/debendabot/manifest"
+	"github.com/thepwagner/debendabot/rootfs"
+)
+
+// cleanupStep removes or truncates files installed by packages that aren't needed in images.
+type cleanupStep struct {
+	// Dir is relative to the rootfs. Its contents are removed, or its files truncated.
+	Dir      string
+	Truncate bool
+	Reason   string
+}
+
+// cleanupSteps are applied by the final stage of docker builds, and after host builds.
+var cleanupSteps = []cleanupStep{
+	{Dir: "var/cache/apt", Reason: "apt's package cache is removed"},
+	{Dir: "var/lib/apt/lists", Reason: "apt's package lists are removed"},
+	{Dir: "usr/share/man", Reason: "man pages are removed"},
+	{Dir: "var/log", Truncate: true, Reason: "logs are truncated"},
+}
+
+// Exceptions declares the deviations from dpkg metadata caused by configuring apt for the manifest, cleaning up the
+// rootfs and embedding Metadata.
+func Exceptions(mf manifest.Manifest) []rootfs.Exception {
+	exceptions := make([]rootfs.Exception, 0, len(cleanupSteps)+2*len(mf.DpkgJSON.Repositories)+3)
+	unowned := []rootfs.DeviationKind{rootfs.Unowned}
+	exceptions = append(exceptions, rootfs.Exception{Path: "/etc/apt/sources.list", Kinds: unowned, Reason: "distro sources are configured by the build"})
+	if snapshotted(mf) {
+		exceptions = append(exceptions, rootfs.Exception{Path: snapshotAptConf, Kinds: unowned, Reason: "snapshots are configured by the build"})
+	}
+	for _, repo := range mf.DpkgJSON.Repositories {
+		exceptions = append(exceptions,
+			rootfs.Exception{Path: repositorySourcesList(repo), Kinds: unowned, Reason: fmt.Sprintf("repository %s is configured by the build", repo.Name)},
+			rootfs.Exception{Path: repositoryKeyring(repo), Kinds: unowned, Reason: fmt.Sprintf("key of repository %s is installed by the build", repo.Name)},
+		)
+	}
+	for _, step := range cleanupSteps {
+		kinds := []rootfs.DeviationKind{rootfs.Missing}
+		if step.Truncate {
+			kinds = []rootfs.DeviationKind{rootfs.Modified, rootfs.ModifiedConffile}
+		}
+		exceptions = append(exceptions, rootfs.Exception{Path: "/" + step.Dir, Kinds: kinds, Reason: step.Reason})
+	}
+	return append(exceptions, rootfs.Exception{
+		Path:   MetadataDir,
+		Kinds:  unowned,
+		Reason: "build metadata is embedded by export",
+	})
+}
+
+// cleanRootfs applies cleanupSteps to a rootfs directory.
+func cleanRootfs(root string) error {
+	for _, step := range cleanupSteps {
+		dir := filepath.Join(root, step.Dir)
+		if step.Truncate {
+			if err := truncateFiles(dir); err != nil {
+				return err
+			}
+			continue
+		}
+
+		entries, err := ioutil.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func truncateFiles(dir string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		return os.Truncate(p, 0)
+	})
+}
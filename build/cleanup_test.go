@@ -0,0 +1,174 @@
+package build_test
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/build"
+	"github.com/thepwagner/debendabot/manifest"
+	"github.com/thepwagner/debendabot/rootfs"
+)
+
+// exportedPackages are installed in exportedRootfs, by the paths they list in /var/lib/dpkg/info.
+// Like bookworm, /bin, /sbin and /lib are merged into /usr, but packages still list paths through them.
+var exportedPackages = map[string][]string{
+	"base-files": {
+		"/.", "/etc", "/etc/debian_version", "/home", "/root", "/tmp", "/usr", "/usr/bin", "/usr/lib", "/usr/sbin",
+		"/usr/share", "/usr/share/man", "/var", "/var/cache", "/var/lib", "/var/lib/dpkg", "/var/log",
+	},
+	"bash":           {"/bin", "/bin/bash"},
+	"libc6":          {"/lib", "/lib/x86_64-linux-gnu", "/lib/x86_64-linux-gnu/libc.so.6", "/lib64", "/lib64/ld-linux-x86-64.so.2"},
+	"libc-bin":       {"/sbin", "/sbin/ldconfig"},
+	"mawk":           {"/usr/bin/mawk", "/usr/share/man/man1/mawk.1.gz"},
+	"tzdata":         {"/usr/share/zoneinfo", "/usr/share/zoneinfo/Etc", "/usr/share/zoneinfo/Etc/UTC"},
+	"libpam-runtime": {"/etc/pam.d", "/etc/pam.d/other", "/usr/sbin/pam-auth-update"},
+	"libpam-modules": {"/etc/security", "/etc/security/limits.conf"},
+	"dpkg":           {"/var/lib/dpkg/alternatives", "/var/lib/dpkg/info", "/var/lib/dpkg/triggers"},
+	"apt":            {"/etc/apt", "/etc/apt/sources.list.d", "/var/cache/apt", "/var/cache/apt/archives", "/var/lib/apt", "/var/lib/apt/lists"},
+}
+
+// exportedRootfs creates the unowned files and links a bookworm image has after export.
+func exportedRootfs(t *testing.T) string {
+	dir := t.TempDir()
+	files := map[string]string{
+		"/etc/debian_version":                      "12.5\n",
+		"/usr/bin/bash":                            "bash",
+		"/usr/lib/x86_64-linux-gnu/libc.so.6":      "libc",
+		"/usr/lib64/ld-linux-x86-64.so.2":          "ld",
+		"/usr/sbin/ldconfig":                       "ldconfig",
+		"/usr/bin/mawk":                            "mawk",
+		"/usr/share/zoneinfo/Etc/UTC":              "TZif",
+		"/etc/pam.d/other":                         "@include common-auth\n",
+		"/usr/sbin/pam-auth-update":                "#!/usr/bin/perl\n",
+		"/etc/passwd":                              "root:x:0:0:root:/root:/bin/bash\n",
+		"/etc/passwd-":                             "root:x:0:0:root:/root:/bin/bash\n",
+		"/etc/group":                               "root:x:0:\n",
+		"/etc/group-":                              "root:x:0:\n",
+		"/etc/shadow":                              "root:*:19000:0:99999:7:::\n",
+		"/etc/gshadow":                             "root:*::\n",
+		"/etc/subuid":                              "",
+		"/etc/subgid":                              "",
+		"/etc/.pwd.lock":                           "",
+		"/etc/hostname":                            "debendabot\n",
+		"/etc/hosts":                               "127.0.0.1 localhost\n",
+		"/etc/resolv.conf":                         "",
+		"/etc/timezone":                            "Etc/UTC\n",
+		"/etc/nsswitch.conf":                       "passwd: files\n",
+		"/etc/shells":                              "/bin/sh\n/bin/bash\n",
+		"/etc/environment":                         "",
+		"/etc/security/opasswd":                    "",
+		"/etc/security/limits.conf":                "# /etc/security/limits.conf\n",
+		"/etc/ld.so.cache":                         "cache",
+		"/etc/pam.d/common-account":                "account required pam_unix.so\n",
+		"/etc/pam.d/common-auth":                   "auth required pam_unix.so\n",
+		"/etc/pam.d/common-password":               "password required pam_unix.so\n",
+		"/etc/pam.d/common-session":                "session required pam_unix.so\n",
+		"/etc/pam.d/common-session-noninteractive": "session required pam_unix.so\n",
+		"/etc/apt/sources.list":                    "deb http://deb.debian.org/debian bookworm main\n",
+		"/root/.bashrc":                            "# ~/.bashrc\n",
+		"/root/.profile":                           "# ~/.profile\n",
+		"/var/cache/debconf/config.dat":            "Name: tzdata/Areas\n",
+		"/var/cache/ldconfig/aux-cache":            "aux",
+		"/var/lib/apt/extended_states":             "",
+		"/var/lib/pam/seen":                        "unix\n",
+		"/var/lib/dpkg/alternatives/awk":           "auto\n/usr/bin/awk\nnawk\n/usr/bin/nawk\nawk.1.gz\n/usr/share/man/man1/awk.1.gz\n\n/usr/bin/mawk\n5\n/usr/bin/mawk\n/usr/share/man/man1/mawk.1.gz\n\n",
+		"/var/lib/dpkg/info/format":                "1\n",
+		"/var/lib/dpkg/triggers/Lock":              "",
+		"/var/log/dpkg.log":                        "",
+		"/var/log/apt/history.log":                 "",
+		build.MetadataDir + "/" + "dpkg.json":      "{}",
+		build.MetadataDir + "/" + "dpkg-lock.json": "{}",
+	}
+	links := map[string]string{
+		"/bin":                   "usr/bin",
+		"/sbin":                  "usr/sbin",
+		"/lib":                   "usr/lib",
+		"/lib32":                 "usr/lib32",
+		"/lib64":                 "usr/lib64",
+		"/libx32":                "usr/libx32",
+		"/etc/localtime":         "/usr/share/zoneinfo/Etc/UTC",
+		"/etc/mtab":              "../proc/self/mounts",
+		"/etc/alternatives/awk":  "/usr/bin/mawk",
+		"/etc/alternatives/nawk": "/usr/bin/mawk",
+		"/usr/bin/awk":           "/etc/alternatives/awk",
+		"/usr/bin/nawk":          "/etc/alternatives/nawk",
+	}
+	dirs := []string{
+		"/dev", "/etc/apt/sources.list.d", "/home", "/proc", "/sys", "/run", "/tmp", "/usr/lib32", "/usr/libx32", "/usr/share/man",
+		"/var/cache/apt", "/var/lib/apt/lists", "/var/lib/dpkg/info",
+	}
+
+	var status strings.Builder
+	for name, pkgFiles := range exportedPackages {
+		_, _ = fmt.Fprintf(&status, "Package: %s\nStatus: install ok installed\n\n", name)
+		var md5sums strings.Builder
+		for _, f := range pkgFiles {
+			if content, ok := files[f]; ok {
+				_, _ = fmt.Fprintf(&md5sums, "%x  %s\n", md5.Sum([]byte(content)), strings.TrimPrefix(f, "/"))
+			}
+		}
+		files["/var/lib/dpkg/info/"+name+".list"] = strings.Join(pkgFiles, "\n") + "\n"
+		files["/var/lib/dpkg/info/"+name+".md5sums"] = md5sums.String()
+	}
+	files["/var/lib/dpkg/status"] = status.String()
+
+	for _, d := range dirs {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, d), 0755))
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	for name, target := range links {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.Symlink(target, path))
+	}
+	return dir
+}
+
+func TestExceptions_Clean(t *testing.T) {
+	fs, err := rootfs.Open(exportedRootfs(t))
+	require.NoError(t, err)
+
+	deviations, err := rootfs.Verify(fs, append(build.Exceptions(manifest.Manifest{}), rootfs.GeneratedFiles...))
+	require.NoError(t, err)
+	var unexpected []string
+	for _, d := range deviations {
+		if !d.Expected() {
+			unexpected = append(unexpected, fmt.Sprintf("%s %s", d.Kind, d.Path))
+		}
+	}
+	sort.Strings(unexpected)
+	assert.Empty(t, unexpected)
+
+	assert.Contains(t, deviations, rootfs.Deviation{Kind: rootfs.Unowned, Path: "/usr/bin/awk", Reason: "link is managed by update-alternatives for awk"})
+	assert.Contains(t, deviations, rootfs.Deviation{Kind: rootfs.Unowned, Path: "/usr/bin/nawk", Reason: "link is managed by update-alternatives for awk"})
+	assert.Contains(t, deviations, rootfs.Deviation{Kind: rootfs.Missing, Path: "/usr/share/man/man1/mawk.1.gz", Packages: []string{"mawk"}, Reason: "man pages are removed"})
+}
+
+func TestExceptions_Repositories(t *testing.T) {
+	dir := exportedRootfs(t)
+	for _, name := range []string{"/etc/apt/sources.list.d/docker.list", "/usr/share/keyrings/debendabot-docker.asc", "/etc/apt/sources.list.d/stray.list"} {
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("deb https://download.docker.com/linux/debian bookworm stable\n"), 0644))
+	}
+	fs, err := rootfs.Open(dir)
+	require.NoError(t, err)
+
+	mf := manifest.Manifest{DpkgJSON: manifest.DpkgJSON{Repositories: []manifest.Repository{{Name: "docker", Key: "-----BEGIN PGP PUBLIC KEY BLOCK-----"}}}}
+	deviations, err := rootfs.Verify(fs, append(build.Exceptions(mf), rootfs.GeneratedFiles...))
+	require.NoError(t, err)
+	assert.Contains(t, deviations, rootfs.Deviation{Kind: rootfs.Unowned, Path: "/etc/apt/sources.list.d/docker.list", Reason: "repository docker is configured by the build"})
+	assert.Contains(t, deviations, rootfs.Deviation{Kind: rootfs.Unowned, Path: "/usr/share/keyrings/debendabot-docker.asc", Reason: "key of repository docker is installed by the build"})
+	assert.Contains(t, deviations, rootfs.Deviation{Kind: rootfs.Unowned, Path: "/etc/apt/sources.list.d/stray.list"})
+}
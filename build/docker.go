@@ -0,0 +1,412 @@
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	docker "github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/sirupsen/logrus"
+	"github.com/thepwagner/debendabot/apt"
+	"github.com/thepwagner/debendabot/dpkg"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+// DockerBackend assembles rootfs in docker images, using debootstrap and apt.
+type DockerBackend struct {
+	docker *client.Client
+	// aptProxy is the HTTP proxy used by APT inside the build, empty for none.
+	aptProxy string
+	apt      *apt.Client
+}
+
+var _ Backend = (*DockerBackend)(nil)
+var _ Locker = (*DockerBackend)(nil)
+var _ Rebuilder = (*DockerBackend)(nil)
+var _ Provenancer = (*DockerBackend)(nil)
+
+func NewDockerBackend(docker *client.Client, aptProxy string) *DockerBackend {
+	return &DockerBackend{docker: docker, aptProxy: aptProxy, apt: apt.NewClient(http.DefaultClient)}
+}
+
+func (d *DockerBackend) Build(ctx context.Context, mf manifest.Manifest, arch string) error {
+	p, err := d.platform(ctx, arch)
+	if err != nil {
+		return err
+	}
+	return d.build(ctx, mf, p, "", BuildImage(mf, arch), false)
+}
+
+// Rebuild builds without the docker layer cache, so every step runs again.
+func (d *DockerBackend) Rebuild(ctx context.Context, mf manifest.Manifest, arch string) error {
+	p, err := d.platform(ctx, arch)
+	if err != nil {
+		return err
+	}
+	return d.build(ctx, mf, p, "", BuildImage(mf, arch), true)
+}
+
+// ExportTarball runs a container from the built image to tar its rootfs into path.
+func (d *DockerBackend) ExportTarball(ctx context.Context, mf manifest.Manifest, arch, path string) error {
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	ctr, err := d.docker.ContainerCreate(ctx, &container.Config{
+		Image: BuildImage(mf, arch),
+		Entrypoint: []string{
+			"sh", "-c", fmt.Sprintf("tar -C $ROOTFS_PATH -c . -f /out/%s", filepath.Base(path)),
+		},
+	}, &container.HostConfig{
+		AutoRemove: true,
+		Mounts: []mount.Mount{
+			{
+				Type:   mount.TypeBind,
+				Source: dir,
+				Target: "/out",
+			},
+		},
+	}, nil, "")
+	if err != nil {
+		return fmt.Errorf("creating export container: %w", err)
+	}
+	logrus.WithField("container_id", ctr.ID).Debug("created export container")
+	statusCh, errCh := d.docker.ContainerWait(ctx, ctr.ID, container.WaitConditionNextExit)
+	if err := d.docker.ContainerStart(ctx, ctr.ID, docker.ContainerStartOptions{}); err != nil {
+		return fmt.Errorf("starting export container: %w", err)
+	}
+	logrus.WithField("container_id", ctr.ID).Debug("started export container")
+	select {
+	case err := <-errCh:
+		if err != nil {
+			logrus.WithError(err).Warn("export container error")
+		}
+	case s := <-statusCh:
+		logrus.WithField("status", s.StatusCode).Debug("export container finished")
+	}
+	return nil
+}
+
+// BuildImage returns the docker image containing an architecture's rootfs.
+func BuildImage(mf manifest.Manifest, arch string) string {
+	return platformImage("debendabot-build", mf, arch)
+}
+
+func platformImage(prefix string, mf manifest.Manifest, arch string) string {
+	if !mf.DpkgJSON.MultiArch() {
+		return fmt.Sprintf("%s/%s", prefix, mf.DpkgJSON.Image)
+	}
+	return fmt.Sprintf("%s/%s/%s", prefix, arch, mf.DpkgJSON.Image)
+}
+
+func (d *DockerBackend) build(ctx context.Context, mf manifest.Manifest, p platform, target, tag string, noCache bool) error {
+	logger := logrus.WithFields(logrus.Fields{
+		"image": mf.DpkgJSON.Image,
+		"arch":  p.arch,
+	})
+
+	// Generate Dockerfile and prepare context:
+	dockerfile, err := genDockerfile(mf, d.aptProxy, p)
+	if err != nil {
+		return fmt.Errorf("generating dockerfile: %w", err)
+	}
+
+	if logrus.IsLevelEnabled(logrus.DebugLevel) {
+		out := logger.WriterLevel(logrus.DebugLevel)
+		_, _ = fmt.Fprintln(out, "-- Dockerfile")
+		_, _ = out.Write([]byte(dockerfile))
+		_, _ = fmt.Fprintln(out, "-- /Dockerfile")
+		_ = out.Close()
+	}
+
+	contextTar, err := buildContext(dockerfile, contextFiles(mf))
+	if err != nil {
+		return fmt.Errorf("preparing build context: %w", err)
+	}
+
+	// Perform the build:
+	build, err := d.docker.ImageBuild(ctx, contextTar, docker.ImageBuildOptions{
+		Dockerfile: "/Dockerfile",
+		Tags:       []string{tag},
+		Target:     target,
+		NoCache:    noCache,
+	})
+	if err != nil {
+		return fmt.Errorf("building image: %w", err)
+	}
+	defer build.Body.Close()
+
+	var out io.Writer
+	if logrus.IsLevelEnabled(logrus.DebugLevel) {
+		logOut := logger.WriterLevel(logrus.DebugLevel)
+		defer logOut.Close()
+		out = logOut
+	} else {
+		out = ioutil.Discard
+	}
+
+	_, _ = fmt.Fprintln(out, "-- build log")
+	if err := jsonmessage.DisplayJSONMessagesStream(build.Body, out, 0, false, nil); err != nil {
+		return fmt.Errorf("reading build output: %w", err)
+	}
+	_, _ = fmt.Fprintln(out, "-- /build log")
+
+	logger.Info("completed build")
+	return nil
+}
+
+// Provenance records the generated Dockerfile and the pinned base image of a build.
+func (d *DockerBackend) Provenance(ctx context.Context, mf manifest.Manifest, arch string) (*Provenance, error) {
+	p, err := d.platform(ctx, arch)
+	if err != nil {
+		return nil, err
+	}
+	dockerfile, err := genDockerfile(mf, d.aptProxy, p)
+	if err != nil {
+		return nil, fmt.Errorf("generating dockerfile: %w", err)
+	}
+	debs, err := DebURLs(ctx, d.apt, mf, arch, mf.DpkgLockJSON.PackagesFor(arch))
+	if err != nil {
+		return nil, err
+	}
+	return &Provenance{
+		BuildType: BuildTypeDocker,
+		BaseImage: baseImage(mf),
+		Config:    map[string]string{"dockerfile": dockerfile},
+		Debs:      debs,
+	}, nil
+}
+
+// BaseImage returns the digest of the docker image used to bootstrap, so it can be pinned.
+func (d *DockerBackend) BaseImage(ctx context.Context, mf manifest.Manifest) (string, error) {
+	image, _, err := d.docker.ImageInspectWithRaw(ctx, baseImage(mf))
+	if err != nil {
+		return "", fmt.Errorf("querying manifest image: %w", err)
+	}
+	return image.RepoDigests[0], nil
+}
+
+func (d *DockerBackend) LockArchitecture(ctx context.Context, mf manifest.Manifest, arch string) (map[manifest.PackageName]manifest.LockedPackage, error) {
+	p, err := d.platform(ctx, arch)
+	if err != nil {
+		return nil, err
+	}
+	mf, err = d.resolveConstraints(ctx, mf, p)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestImage := platformImage("debendabot-manifest", mf, p.arch)
+	if err := d.build(ctx, mf, p, "manifest", manifestImage, false); err != nil {
+		return nil, fmt.Errorf("rebuilding manifest: %w", err)
+	}
+
+	// Extract manifest file:
+	ctrID, err := d.createContainer(ctx, manifestImage)
+	if err != nil {
+		return nil, err
+	}
+	defer d.removeContainer(ctx, ctrID)
+
+	dpkgStatus, err := d.readFile(ctx, ctrID, path.Join(rootfsPath, dpkg.StatusPath))
+	if err != nil {
+		return nil, err
+	}
+	installed, err := dpkg.ParseStatus(bytes.NewReader(dpkgStatus))
+	if err != nil {
+		return nil, fmt.Errorf("parsing dpkg status: %w", err)
+	}
+
+	debHashes, err := d.readFile(ctx, ctrID, "/deb-hashes.txt")
+	if err != nil {
+		return nil, err
+	}
+	packageHashList := strings.Split(string(debHashes), "\n")
+	packageHashes := make(map[manifest.PackageName]hashedPackage, len(packageHashList))
+	for _, packageHashLine := range packageHashList {
+		if packageHashLine == "" {
+			continue
+		}
+		lineParts := strings.Split(packageHashLine, "  ")
+		packageName := manifest.PackageName(strings.Split(lineParts[1], "_")[0])
+		packageHashes[packageName] = hashedPackage{
+			filename: lineParts[1],
+			hash:     lineParts[0],
+		}
+	}
+
+	repositories, err := d.packageRepositories(ctx, ctrID, mf)
+	if err != nil {
+		return nil, err
+	}
+
+	listings, err := d.aptListings(ctx, ctrID)
+	if err != nil {
+		return nil, err
+	}
+	return lockInstalled(installed, packageHashes, repositories, listings), nil
+}
+
+type hashedPackage struct {
+	filename string
+	hash     string
+}
+
+// lockInstalled locks the installed packages of a manifest image, from the downloaded .deb hashes and apt lists.
+func lockInstalled(installed []dpkg.Package, packageHashes map[manifest.PackageName]hashedPackage, repositories map[repositoryVersion]string, listings map[apt.ListingKey]apt.Listing) map[manifest.PackageName]manifest.LockedPackage {
+	dependents := dpkg.Dependents(installed)
+	locked := make(map[manifest.PackageName]manifest.LockedPackage, len(installed))
+	for _, installedPackage := range installed {
+		if !installedPackage.Status.Installed() {
+			continue
+		}
+
+		pkg := manifest.PackageName(installedPackage.Package)
+		lock := manifest.LockedPackage{
+			Version:      installedPackage.Version,
+			Architecture: installedPackage.Architecture,
+		}
+
+		hash, ok := packageHashes[pkg]
+		if !ok {
+			logrus.WithField("pkg", pkg).Warn("unhashed package")
+		} else {
+			lock.DebFilename = hash.filename
+			lock.DebHash = hash.hash
+		}
+		lock.Repository = repositories[repositoryVersion{pkg: pkg, version: lock.Version}]
+		lock.Source, lock.SourceVersion = installedPackage.Source, installedPackage.SourceVersion
+		listing, ok := listings[apt.ListingKey{Package: installedPackage.Package, Version: lock.Version, Architecture: lock.Architecture}]
+		if !ok {
+			logrus.WithField("pkg", pkg).Warn("unlisted package")
+		} else {
+			lock.Suite = listing.Suite
+			lock.Component = listing.Component
+			lock.Origin = listing.Origin
+			lock.Codename = listing.Codename
+			lock.Path = listing.Filename
+			lock.Size = listing.Size
+		}
+		for _, dependent := range dependents[installedPackage.Package] {
+			lock.RequiredBy = append(lock.RequiredBy, manifest.PackageName(dependent))
+		}
+
+		locked[pkg] = lock
+	}
+	return locked
+}
+
+type repositoryVersion struct {
+	pkg     manifest.PackageName
+	version string
+}
+
+// packageRepositories maps package versions to the dpkg.json repository that provides them.
+func (d *DockerBackend) packageRepositories(ctx context.Context, containerID string, mf manifest.Manifest) (map[repositoryVersion]string, error) {
+	ret := map[repositoryVersion]string{}
+	if len(mf.DpkgJSON.Repositories) == 0 {
+		return ret, nil
+	}
+
+	aptRepositories, err := d.readFile(ctx, containerID, "/apt-repositories.txt")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(aptRepositories), "\n") {
+		lineParts := strings.Fields(line)
+		if len(lineParts) != 3 {
+			continue
+		}
+		key := repositoryVersion{pkg: manifest.PackageName(lineParts[1]), version: lineParts[2]}
+		if _, ok := ret[key]; !ok {
+			ret[key] = lineParts[0]
+		}
+	}
+	return ret, nil
+}
+
+func (d *DockerBackend) createContainer(ctx context.Context, image string) (string, error) {
+	ctr, err := d.docker.ContainerCreate(ctx, &container.Config{
+		Image: image,
+	}, nil, nil, "")
+	if err != nil {
+		return "", fmt.Errorf("creating %s container: %w", image, err)
+	}
+	return ctr.ID, nil
+}
+
+func (d *DockerBackend) removeContainer(ctx context.Context, containerID string) {
+	err := d.docker.ContainerRemove(ctx, containerID, docker.ContainerRemoveOptions{Force: true})
+	if err != nil {
+		logrus.WithError(err).WithField("container_id", containerID).Warn("error removing container")
+	}
+}
+
+func (d *DockerBackend) readFile(ctx context.Context, containerID string, path string) ([]byte, error) {
+	copied, _, err := d.docker.CopyFromContainer(ctx, containerID, path)
+	if err != nil {
+		return nil, fmt.Errorf("copying container file: %w", err)
+	}
+	defer copied.Close()
+
+	tr := tar.NewReader(copied)
+	// Discard header:
+	if _, err := tr.Next(); err != nil {
+		return nil, fmt.Errorf("reading copied tar: %w", err)
+	}
+	return ioutil.ReadAll(tr)
+}
+
+func buildContext(dockerfile string, files map[string][]byte) (io.Reader, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	// /Dockerfile
+	if err := writeContextFile(tw, "Dockerfile", []byte(dockerfile)); err != nil {
+		return nil, fmt.Errorf("writing dockerfile: %w", err)
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := writeContextFile(tw, name, files[name]); err != nil {
+			return nil, fmt.Errorf("writing %q: %w", name, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("closing tar: %w", err)
+	}
+
+	return &buf, nil
+}
+
+func writeContextFile(tw *tar.Writer, name string, data []byte) error {
+	th := &tar.Header{
+		Name: name,
+		Mode: 0400, // don't trust anybody
+		Size: int64(len(data)),
+	}
+	if err := tw.WriteHeader(th); err != nil {
+		return fmt.Errorf("writing tar header: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package build
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/apt"
+	"github.com/thepwagner/debendabot/dpkg"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+const lockStatus = `Package: bsdutils
+Status: install ok installed
+Architecture: amd64
+Source: util-linux (2.33.1-0.1)
+Version: 1:2.33.1-0.1
+Depends: libc6
+
+Package: libc6
+Status: install ok installed
+Architecture: amd64
+Source: glibc
+Version: 2.28-10+deb10u1
+`
+
+func TestLockInstalled(t *testing.T) {
+	installed, err := dpkg.ParseStatus(strings.NewReader(lockStatus))
+	require.NoError(t, err)
+
+	lists := apt.NewLists()
+	for name, content := range map[string]string{
+		"deb.debian.org_debian_dists_buster_InRelease": "Origin: Debian\nSuite: oldstable\nCodename: buster\n",
+		"deb.debian.org_debian_dists_buster_main_binary-amd64_Packages": `Package: bsdutils
+Source: util-linux (2.33.1-0.1)
+Version: 1:2.33.1-0.1
+Architecture: amd64
+Filename: pool/main/u/util-linux/bsdutils_2.33.1-0.1_amd64.deb
+Size: 97584
+`,
+	} {
+		require.NoError(t, lists.Add(name, strings.NewReader(content)))
+	}
+	hashes := map[manifest.PackageName]hashedPackage{
+		"bsdutils": {filename: "bsdutils_1%3a2.33.1-0.1_amd64.deb", hash: "abcd"},
+	}
+
+	locked := lockInstalled(installed, hashes, map[repositoryVersion]string{}, lists.Listings())
+	assert.Equal(t, map[manifest.PackageName]manifest.LockedPackage{
+		"bsdutils": {
+			Version:       "1:2.33.1-0.1",
+			Architecture:  "amd64",
+			DebFilename:   "bsdutils_1%3a2.33.1-0.1_amd64.deb",
+			DebHash:       "abcd",
+			Source:        "util-linux",
+			SourceVersion: "2.33.1-0.1",
+			Suite:         "buster",
+			Component:     "main",
+			Origin:        "Debian",
+			Codename:      "buster",
+			Path:          "pool/main/u/util-linux/bsdutils_2.33.1-0.1_amd64.deb",
+			Size:          97584,
+		},
+		// Unlisted packages are locked without a pool path:
+		"libc6": {
+			Version:       "2.28-10+deb10u1",
+			Architecture:  "amd64",
+			Source:        "glibc",
+			SourceVersion: "2.28-10+deb10u1",
+			RequiredBy:    []manifest.PackageName{"bsdutils"},
+		},
+	}, locked)
+
+	mf := manifest.Manifest{DpkgJSON: manifest.DpkgJSON{Distro: "buster"}}
+	url, ok := mf.DebURL(locked["bsdutils"])
+	assert.True(t, ok)
+	assert.Equal(t, manifest.DefaultMirror+"/pool/main/u/util-linux/bsdutils_2.33.1-0.1_amd64.deb", url)
+	_, ok = mf.DebURL(locked["libc6"])
+	assert.False(t, ok)
+}
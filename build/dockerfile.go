@@ -5,37 +5,76 @@ import (
 	"sort"
 	"strings"
 	"text/template"
+	"unicode"
 
 	"github.com/thepwagner/debendabot/manifest"
 )
 
-var dockerfileTemplate = template.Must(template.New("dockerfile").Parse(`
+var dockerfileTemplate = template.Must(template.New("dockerfile").Funcs(template.FuncMap{"quote": shellQuote}).Parse(`
 FROM {{.BaseImage}} AS base
 
 FROM base AS sources
 {{if .Proxy}}
 ENV http_proxy={{.Proxy}}
 {{end}}
-{{/* 
-	TODO: setup repos, install keys
-	install gnupg+apt-transport-https if missing
-*/}}
 RUN apt-get update
 
-FROM sources AS build
+FROM sources AS bootstrap
 ARG DEBIAN_FRONTEND=noninteractive
 
 RUN apt-get update && \
   apt-get install -y \
    --no-install-recommends \
    debootstrap
+{{ if .Qemu }}
+RUN apt-get install -y --no-install-recommends qemu-user-static
+{{ end }}
+{{ if .NeedsGnupg }}
+RUN apt-get install -y --no-install-recommends dirmngr gnupg
+{{ end }}
 
-ENV ROOTFS_PATH=/rootfs
+ENV ROOTFS_PATH={{.RootfsPath}}
 RUN debootstrap \
-  --arch amd64 \
+  --arch {{.Arch}} \
+{{ if .Qemu }}
+  --foreign \
+{{ end }}
   --variant=minbase \
+{{ if .DebootstrapInclude }}
+  --include={{.DebootstrapInclude}} \
+{{ end }}
   {{.Distro}} \
-  ${ROOTFS_PATH} http://cdn-fastly.deb.debian.org/debian 
+  ${ROOTFS_PATH} {{.Mirror}}
+{{ if .Snapshot }}
+{{/* snapshot.debian.org serves Release files that have long since expired: */}}
+RUN echo 'Acquire::Check-Valid-Until "false";' > $ROOTFS_PATH{{.SnapshotConf}}
+{{ end }}
+{{ if .Qemu }}
+{{/* Requires binfmt_misc handlers for qemu-user-static to be registered on the docker host. */}}
+RUN cp /usr/bin/{{.Qemu}} $ROOTFS_PATH/usr/bin/ && \
+  chroot $ROOTFS_PATH /debootstrap/debootstrap --second-stage
+{{ end }}
+RUN printf '%s\n'{{ range $line := .DistroSources }} {{quote $line}}{{ end }} > $ROOTFS_PATH/etc/apt/sources.list
+
+{{ if .Repositories }}
+RUN mkdir -p $ROOTFS_PATH/usr/share/keyrings
+{{ range $repo := .Repositories }}
+{{ if $repo.KeyPath }}
+COPY {{$repo.KeyPath}} $ROOTFS_PATH{{$repo.Keyring}}
+{{ if $repo.Fingerprint }}
+RUN gpg --show-keys --with-colons $ROOTFS_PATH{{quote $repo.Keyring}} | grep -q {{quote (printf "^fpr:.*:%s:$" $repo.Fingerprint)}}
+{{ end }}
+{{ else }}
+RUN gpg --batch --no-default-keyring --keyring {{quote $repo.TempKeyring}} --keyserver {{quote $.Keyserver}} --recv-keys {{quote $repo.Fingerprint}} && \
+  gpg --batch --no-default-keyring --keyring {{quote $repo.TempKeyring}} --export {{quote $repo.Fingerprint}} > $ROOTFS_PATH{{quote $repo.Keyring}}
+{{ end }}
+RUN printf '%s\n' {{quote $repo.SourcesLine}} > $ROOTFS_PATH{{quote $repo.SourcesList}}
+{{ end }}
+{{ end }}
+RUN chroot $ROOTFS_PATH apt-get update
+
+FROM bootstrap AS build
+ARG DEBIAN_FRONTEND=noninteractive
 
 {{ if .LockedPackages }}
 RUN chroot $ROOTFS_PATH sh -c "apt-get install -y --no-install-recommends \
@@ -70,54 +109,139 @@ RUN cd $ROOTFS_PATH/var/cache/apt/archives && \
 {{ end }}
 
 FROM build AS manifest
-RUN chroot $ROOTFS_PATH apt list --installed -qq | tee /apt-installed.txt
 RUN cd $ROOTFS_PATH/var/cache/apt/archives && sha512sum *.deb | tee /deb-hashes.txt
+RUN mkdir -p {{.ChangelogsPath}} /docs && cd $ROOTFS_PATH/var/cache/apt/archives && \
+  for deb in *.deb; do \
+    (dpkg-deb --fsys-tarfile $deb | tar -x -C /docs --wildcards "./usr/share/doc/*" 2>/dev/null || true); \
+  done && \
+  for deb in *.deb; do \
+    pkg=$(dpkg-deb --field $deb Package) && \
+    changelog=$(ls /docs/usr/share/doc/$pkg/changelog.Debian*.gz /docs/usr/share/doc/$pkg/changelog.gz 2>/dev/null | head -n 1) && \
+    if [ -n "$changelog" ]; then cp "$changelog" {{.ChangelogsPath}}/$pkg.gz; else echo "no changelog: $pkg"; fi; \
+  done && \
+  rm -rf /docs
+{{ if .Repositories }}
+RUN touch /apt-repositories.txt \
+{{ range $repo := .Repositories }}
+  && (cat $ROOTFS_PATH/var/lib/apt/lists/{{quote $repo.ListPrefix}}_*Packages || true) | awk -v repo={{quote $repo.Name}} '/^Package:/ {p=$2} /^Version:/ {print repo, p, $2}' >> /apt-repositories.txt \
+{{ end }}
+  && cat /apt-repositories.txt
+{{ end }}
 
 FROM build
-RUN rm -Rf $ROOTFS_PATH/var/cache/apt/* $ROOTFS_PATH/var/lib/apt/lists/*
-RUN rm -Rf $ROOTFS_PATH/usr/share/man/*
-RUN find $ROOTFS_PATH/var/log -type f -exec truncate -s0 {} \;
+{{ range .Cleanup }}{{ if .Truncate }}RUN find $ROOTFS_PATH/{{.Dir}} -type f -exec truncate -s0 {} \;
+{{ else }}RUN rm -Rf $ROOTFS_PATH/{{.Dir}}/*
+{{ end }}{{ end }}{{ if .Qemu }}
+RUN rm -f $ROOTFS_PATH/usr/bin/{{.Qemu}}
+{{ end }}
 CMD ["/usr/bin/bash"]
 {{if .Proxy}}
 ENV http_proxy=
 {{end}}
 `))
 
+// rootfsPath is where the rootfs is assembled within build images.
+const rootfsPath = "/rootfs"
+
+// changelogsPath holds gzipped changelogs extracted from each .deb within manifest images. Native packages only ship
+// changelog.gz, and packages built from the same source often link their doc directory to another package's.
+const changelogsPath = "/changelogs"
+
 type dockerfileTemplateParams struct {
+	RootfsPath         string
+	ChangelogsPath     string
 	Distro             string
+	Arch               string
+	Qemu               string
+	Mirror             string
+	DistroSources      []string
+	Snapshot           bool
+	SnapshotConf       string
 	BaseImage          string
 	LockedPackageSpecs []string
 	LockedPackages     []string
 	PackageSpecs       []string
 	Proxy              string
 	DebHashes          []string
+	Repositories       []repositoryParams
+	DebootstrapInclude string
+	NeedsGnupg         bool
+	Keyserver          string
+	Cleanup            []cleanupStep
+}
+
+type repositoryParams struct {
+	Name    string
+	KeyPath string
+	Keyring string
+	// TempKeyring holds keys fetched from the keyserver, before they're exported to Keyring.
+	TempKeyring string
+	Fingerprint string
+	SourcesLine string
+	SourcesList string
+	ListPrefix  string
 }
 
-func genDockerfile(mf manifest.Manifest) (string, error) {
+// shellQuote quotes a value as a single shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// keyserver provides signing keys for repositories that are declared with only a fingerprint.
+const keyserver = "hkps://keyserver.ubuntu.com"
+
+func genDockerfile(mf manifest.Manifest, proxy string, plat platform) (string, error) {
 	var buf strings.Builder
 
 	p := dockerfileTemplateParams{
-		Distro:    mf.DpkgJSON.Distro,
-		BaseImage: baseImage(mf),
-		// FIXME: don't hardcode my apt-cacher
-		Proxy: "http://172.17.0.1:3142",
+		RootfsPath:     rootfsPath,
+		ChangelogsPath: changelogsPath,
+		Distro:         mf.DpkgJSON.Distro,
+		Arch:           plat.arch,
+		Qemu:           plat.qemuBinary(),
+		Mirror:         mf.Mirror(),
+		DistroSources:  distroSources(mf),
+		Snapshot:       snapshotted(mf),
+		SnapshotConf:   snapshotAptConf,
+		BaseImage:      baseImage(mf),
+		Proxy:          proxy,
+		Keyserver:      keyserver,
+		Cleanup:        cleanupSteps,
+	}
+
+	// Setup additional repositories from dpkg.json:
+	for _, repo := range mf.DpkgJSON.Repositories {
+		rp, err := newRepositoryParams(repo)
+		if err != nil {
+			return "", err
+		}
+		p.Repositories = append(p.Repositories, rp)
+		if rp.Fingerprint != "" {
+			p.NeedsGnupg = true
+		}
+		if strings.HasPrefix(repo.URI, "https://") {
+			// debootstrap's minbase variant can't verify TLS certificates:
+			p.DebootstrapInclude = "ca-certificates"
+		}
 	}
 
 	// Build package specs from dpkg.json:
 	for name, version := range mf.DpkgJSON.Packages {
-		switch version {
-		case "stable", "unstable", "testing":
+		if version.IsSuite() {
 			p.PackageSpecs = append(p.PackageSpecs, fmt.Sprintf("%s/%s", name, version))
-		default:
-			// XXX: this requires an exact match - could we filter candidates against semver?
-			// not all packages _are_ semver; some library can handle that? :crossed_fingers:
-			p.PackageSpecs = append(p.PackageSpecs, fmt.Sprintf("%s=%s", name, version))
+			continue
+		}
+
+		exact, err := exactVersion(mf, plat.arch, name, version)
+		if err != nil {
+			return "", err
 		}
+		p.PackageSpecs = append(p.PackageSpecs, fmt.Sprintf("%s=%s", name, exact))
 	}
 	sort.Strings(p.PackageSpecs)
 
 	if mf.DpkgLockJSON != nil {
-		for name, lock := range mf.DpkgLockJSON.Packages {
+		for name, lock := range mf.DpkgLockJSON.PackagesFor(plat.arch) {
 			p.LockedPackageSpecs = append(p.LockedPackageSpecs, fmt.Sprintf("%s=%s", name, lock.Version))
 			p.LockedPackages = append(p.LockedPackages, string(name))
 			p.DebHashes = append(p.DebHashes, fmt.Sprintf("%s\t%s", lock.DebHash, lock.DebFilename))
@@ -143,9 +267,146 @@ func genDockerfile(mf manifest.Manifest) (string, error) {
 	return ret.String(), nil
 }
 
-func baseImage(mf manifest.Manifest) string {
+// distroSources returns the sources.list lines of the distro's suites.
+func distroSources(mf manifest.Manifest) []string {
+	suites := mf.DpkgJSON.DistroSuites(mf.Mirror(), mf.SecurityMirror())
+	ret := make([]string, 0, len(suites))
+	for _, suite := range suites {
+		ret = append(ret, fmt.Sprintf("deb %s %s main", suite.URI, suite.Suite))
+	}
+	return ret
+}
+
+// exactVersion returns the version to install for a constraint.
+// Ranges must be resolved to an exact version by Lock before they can be built.
+func exactVersion(mf manifest.Manifest, arch string, name manifest.PackageName, version manifest.PackageVersion) (string, error) {
+	constraint, err := version.Constraint()
+	if err != nil {
+		return "", fmt.Errorf("package %q: %w", name, err)
+	}
+	if exact, ok := constraint.Exact(); ok {
+		return exact.String(), nil
+	}
+
 	if mf.DpkgLockJSON != nil {
+		if locked, ok := mf.DpkgLockJSON.PackagesFor(arch)[name]; ok {
+			return locked.Version, nil
+		}
+	}
+	return "", fmt.Errorf("package %q constraint %q is not locked, update the lockfile", name, version)
+}
+
+func baseImage(mf manifest.Manifest) string {
+	if mf.DpkgLockJSON != nil && mf.DpkgLockJSON.Image != "" {
 		return mf.DpkgLockJSON.Image
 	}
 	return fmt.Sprintf("debian:%s-slim", mf.DpkgJSON.Distro)
 }
+
+// snapshotAptConf configures apt within the rootfs to accept the expired Release files of snapshots.
+const snapshotAptConf = "/etc/apt/apt.conf.d/99debendabot-snapshot"
+
+// snapshotted returns true if the lockfile pins a snapshot.
+func snapshotted(mf manifest.Manifest) bool {
+	return mf.DpkgLockJSON != nil && mf.DpkgLockJSON.Snapshot != ""
+}
+
+// repositorySourcesList is the path of a repository's sources.list within the rootfs.
+func repositorySourcesList(repo manifest.Repository) string {
+	return fmt.Sprintf("/etc/apt/sources.list.d/%s.list", repo.Name)
+}
+
+// repositoryKeyring is the path of a repository's signing key within the rootfs: armored if embedded in dpkg.json,
+// else exported from the keyserver.
+func repositoryKeyring(repo manifest.Repository) string {
+	if repo.Key != "" {
+		return fmt.Sprintf("/usr/share/keyrings/debendabot-%s.asc", repo.Name)
+	}
+	return fmt.Sprintf("/usr/share/keyrings/debendabot-%s.gpg", repo.Name)
+}
+
+func newRepositoryParams(repo manifest.Repository) (repositoryParams, error) {
+	if repo.Name == "" {
+		return repositoryParams{}, fmt.Errorf("repository %q has no name", repo.URI)
+	}
+	// Values are validated by manifest.DpkgJSON.Validate, but are checked again as they're interpolated into the build:
+	if !manifest.RepositoryNamePattern.MatchString(repo.Name) {
+		return repositoryParams{}, fmt.Errorf("invalid repository name %q, must match %s", repo.Name, manifest.RepositoryNamePattern)
+	}
+	if repo.Key == "" && repo.Fingerprint == "" {
+		return repositoryParams{}, fmt.Errorf("repository %q has neither key nor fingerprint", repo.Name)
+	}
+	fingerprint := repo.KeyFingerprint()
+	if fingerprint != "" && !manifest.FingerprintPattern.MatchString(fingerprint) {
+		return repositoryParams{}, fmt.Errorf("repository %q: invalid fingerprint %q", repo.Name, repo.Fingerprint)
+	}
+	fields := append([]string{repo.URI, repo.Suite}, repo.Components...)
+	for _, field := range append(fields, repo.Architectures...) {
+		if !manifest.ValidSourcesField(field) {
+			return repositoryParams{}, fmt.Errorf("repository %q: invalid sources.list value %q", repo.Name, field)
+		}
+	}
+
+	rp := repositoryParams{
+		Name:        repo.Name,
+		TempKeyring: fmt.Sprintf("/tmp/%s.gpg", repo.Name),
+		Fingerprint: fingerprint,
+		SourcesList: repositorySourcesList(repo),
+		Keyring:     repositoryKeyring(repo),
+		ListPrefix:  aptListPrefix(repo.URI, repo.Suite),
+	}
+	if repo.Key != "" {
+		rp.KeyPath = repositoryKeyPath(repo)
+	}
+
+	options := []string{fmt.Sprintf("signed-by=%s", rp.Keyring)}
+	if len(repo.Architectures) > 0 {
+		options = append(options, fmt.Sprintf("arch=%s", strings.Join(repo.Architectures, ",")))
+	}
+	rp.SourcesLine = fmt.Sprintf("deb [%s] %s %s %s",
+		strings.Join(options, " "), repo.URI, repo.Suite, strings.Join(repo.Components, " "))
+	return rp, nil
+}
+
+// repositoryKeyPath is the path of a repository's armored key within the build context.
+func repositoryKeyPath(repo manifest.Repository) string {
+	return fmt.Sprintf("keys/%s.asc", repo.Name)
+}
+
+// contextFiles returns files beyond the Dockerfile that must be present in the build context.
+func contextFiles(mf manifest.Manifest) map[string][]byte {
+	files := map[string][]byte{}
+	for _, repo := range mf.DpkgJSON.Repositories {
+		if repo.Key != "" {
+			files[repositoryKeyPath(repo)] = []byte(repo.Key)
+		}
+	}
+	return files
+}
+
+// aptListPrefix mimics apt's URItoFileName, returning the prefix of files in /var/lib/apt/lists for a suite.
+func aptListPrefix(uri, suite string) string {
+	if i := strings.Index(uri, "://"); i >= 0 {
+		uri = uri[i+3:]
+	}
+	if i := strings.Index(uri, "@"); i >= 0 && i < strings.Index(uri+"/", "/") {
+		uri = uri[i+1:]
+	}
+	uri = strings.TrimSuffix(uri, "/")
+	path := fmt.Sprintf("%s/dists/%s", uri, strings.TrimSuffix(suite, "/"))
+
+	var ret strings.Builder
+	for _, r := range path {
+		switch {
+		case r == '/':
+			ret.WriteRune('_')
+		case r > unicode.MaxASCII || r <= ' ' || strings.ContainsRune("\\|{}[]<>\"^~_=!@#$%^&*", r):
+			for _, b := range []byte(string(r)) {
+				_, _ = fmt.Fprintf(&ret, "%%%02x", b)
+			}
+		default:
+			ret.WriteRune(r)
+		}
+	}
+	return ret.String()
+}
@@ -0,0 +1,790 @@
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thepwagner/debendabot/apt"
+	"github.com/thepwagner/debendabot/dpkg"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+// Maintainer script modes of the HostBackend.
+const (
+	// ScriptsChroot configures packages with dpkg inside the rootfs, via fakechroot and fakeroot when unprivileged.
+	ScriptsChroot = "chroot"
+	// ScriptsNone registers unpacked packages in the dpkg database without running maintainer scripts.
+	ScriptsNone = "none"
+)
+
+// HostBackend assembles rootfs in a local directory from the .debs of a lockfile, without a docker daemon.
+// It can't resolve packages, so manifests must be locked by another backend first.
+type HostBackend struct {
+	http    *http.Client
+	apt     *apt.Client
+	workDir string
+	scripts string
+}
+
+var _ Backend = (*HostBackend)(nil)
+var _ Provenancer = (*HostBackend)(nil)
+
+func NewHostBackend(httpClient *http.Client, workDir, scripts string) (*HostBackend, error) {
+	switch scripts {
+	case ScriptsChroot, ScriptsNone:
+	default:
+		return nil, fmt.Errorf("unknown maintainer script mode %q", scripts)
+	}
+	return &HostBackend{
+		http:    httpClient,
+		apt:     apt.NewClient(httpClient),
+		workDir: workDir,
+		scripts: scripts,
+	}, nil
+}
+
+func (h *HostBackend) rootfs(arch string) string {
+	return filepath.Join(h.workDir, arch, "rootfs")
+}
+
+func (h *HostBackend) Build(ctx context.Context, mf manifest.Manifest, arch string) error {
+	logger := logrus.WithFields(logrus.Fields{
+		"image": mf.DpkgJSON.Image,
+		"arch":  arch,
+	})
+	locked, err := lockedPackages(mf, arch)
+	if err != nil {
+		return err
+	}
+
+	debs, err := h.downloadDebs(ctx, mf, arch, locked)
+	if err != nil {
+		return err
+	}
+	logger.WithField("debs", len(debs)).Info("downloaded packages")
+
+	rootfs := h.rootfs(arch)
+	if err := os.RemoveAll(rootfs); err != nil {
+		return fmt.Errorf("removing previous rootfs: %w", err)
+	}
+	if err := os.MkdirAll(rootfs, 0755); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(debs))
+	for name := range debs {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	unpacked := make([]*unpackedPackage, 0, len(names))
+	for _, name := range names {
+		pkg, err := unpackDeb(debs[manifest.PackageName(name)], rootfs)
+		if err != nil {
+			return fmt.Errorf("unpacking %s: %w", name, err)
+		}
+		unpacked = append(unpacked, pkg)
+	}
+	logger.WithField("rootfs", rootfs).Info("unpacked packages")
+
+	switch h.scripts {
+	case ScriptsChroot:
+		err = configureChroot(ctx, rootfs, arch, debs)
+	case ScriptsNone:
+		err = writeDpkgDatabase(rootfs, unpacked)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := writeAptSources(rootfs, mf); err != nil {
+		return err
+	}
+	if err := cleanRootfs(rootfs); err != nil {
+		return err
+	}
+	logger.WithField("rootfs", rootfs).Info("assembled rootfs")
+	return nil
+}
+
+// lockedPackages returns the packages to install for an architecture, which must satisfy dpkg.json.
+func lockedPackages(mf manifest.Manifest, arch string) (map[manifest.PackageName]manifest.LockedPackage, error) {
+	if mf.DpkgLockJSON == nil {
+		return nil, fmt.Errorf("host backend requires a lockfile")
+	}
+	locked := mf.DpkgLockJSON.PackagesFor(arch)
+	if len(locked) == 0 {
+		return nil, fmt.Errorf("no packages locked for %s, update the lockfile", arch)
+	}
+	for name, version := range mf.DpkgJSON.Packages {
+		lock, ok := locked[name]
+		if !ok {
+			return nil, fmt.Errorf("package %q is not locked, update the lockfile", name)
+		}
+		if version.IsSuite() {
+			continue
+		}
+		exact, err := exactVersion(mf, arch, name, version)
+		if err != nil {
+			return nil, err
+		}
+		if exact != lock.Version {
+			return nil, fmt.Errorf("package %q is locked to %q not %q, update the lockfile", name, lock.Version, exact)
+		}
+	}
+	return locked, nil
+}
+
+// downloadDebs fetches locked packages into the work directory, verifying them against the lockfile.
+func (h *HostBackend) downloadDebs(ctx context.Context, mf manifest.Manifest, arch string, locked map[manifest.PackageName]manifest.LockedPackage) (map[manifest.PackageName]string, error) {
+	urls, err := DebURLs(ctx, h.apt, mf, arch, locked)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(h.workDir, "debs")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	ret := make(map[manifest.PackageName]string, len(urls))
+	for name, url := range urls {
+		lock := locked[name]
+		if lock.DebHash == "" {
+			return nil, fmt.Errorf("package %q has no hash in the lockfile", name)
+		}
+		debPath := filepath.Join(dir, path.Base(url))
+		if err := h.download(ctx, url, debPath, lock.DebHash); err != nil {
+			return nil, fmt.Errorf("downloading %s: %w", name, err)
+		}
+		ret[name] = debPath
+	}
+	return ret, nil
+}
+
+// Provenance records the maintainer script mode of a build.
+func (h *HostBackend) Provenance(ctx context.Context, mf manifest.Manifest, arch string) (*Provenance, error) {
+	locked, err := lockedPackages(mf, arch)
+	if err != nil {
+		return nil, err
+	}
+	debs, err := DebURLs(ctx, h.apt, mf, arch, locked)
+	if err != nil {
+		return nil, err
+	}
+	return &Provenance{
+		BuildType: BuildTypeHost,
+		Config:    map[string]string{"scripts": h.scripts},
+		Debs:      debs,
+	}, nil
+}
+
+// download fetches url to dst, unless dst already matches the SHA-512 hash.
+func (h *HostBackend) download(ctx context.Context, url, dst, hash string) error {
+	if existing, err := fileHash(dst); err == nil && existing == hash {
+		logrus.WithField("path", dst).Debug("using cached package")
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := h.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetching %q: %w", url, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %q: %s", url, res.Status)
+	}
+
+	tmp := dst + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	hasher := sha512.New()
+	_, err = io.Copy(io.MultiWriter(f, hasher), res.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing %q: %w", tmp, err)
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != hash {
+		return fmt.Errorf("hash mismatch for %q: locked %s, downloaded %s", url, hash, actual)
+	}
+	return os.Rename(tmp, dst)
+}
+
+func fileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha512.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// unpackedPackage is a .deb whose data.tar has been extracted into a rootfs.
+type unpackedPackage struct {
+	control dpkg.Paragraph
+	// files extracted from data.tar, as listed in /var/lib/dpkg/info/<pkg>.list
+	files []string
+	// info files from control.tar other than control, e.g. "postinst" or "md5sums".
+	info map[string][]byte
+}
+
+func unpackDeb(debPath, rootfs string) (*unpackedPackage, error) {
+	f, err := os.Open(debPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	pkg := &unpackedPackage{info: map[string][]byte{}}
+	err = dpkg.ReadDeb(f, func(member string, tr *tar.Reader) error {
+		switch member {
+		case dpkg.DebControl:
+			return pkg.readControl(tr)
+		case dpkg.DebData:
+			return pkg.extract(tr, rootfs)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if pkg.control == nil {
+		return nil, fmt.Errorf("control file not found")
+	}
+	return pkg, nil
+}
+
+func (u *unpackedPackage) readControl(tr *tar.Reader) error {
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimPrefix(h.Name, "./")
+		if name != "control" {
+			u.info[name] = data
+			continue
+		}
+		paragraphs, err := dpkg.ParseControl(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		if len(paragraphs) != 1 {
+			return fmt.Errorf("expected one control paragraph, got %d", len(paragraphs))
+		}
+		u.control = paragraphs[0]
+	}
+}
+
+func (u *unpackedPackage) extract(tr *tar.Reader, rootfs string) error {
+	privileged := os.Geteuid() == 0
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := path.Clean("/" + h.Name)
+		if name == "/" {
+			u.files = append(u.files, "/.")
+		} else {
+			u.files = append(u.files, name)
+		}
+		target, err := resolveInRoot(rootfs, name)
+		if err != nil {
+			return err
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			// Keep symlinked directories, e.g. /bin -> usr/bin on merged-/usr systems:
+			if fi, err := os.Lstat(target); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				continue
+			}
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := replaceFile(target, tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := removeExisting(target); err != nil {
+				return err
+			}
+			if err := os.Symlink(h.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			link, err := resolveInRoot(rootfs, h.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := removeExisting(target); err != nil {
+				return err
+			}
+			if err := os.Link(link, target); err != nil {
+				return err
+			}
+		default:
+			logrus.WithFields(logrus.Fields{
+				"path": name,
+				"type": h.Typeflag,
+			}).Warn("skipping unsupported file type")
+			continue
+		}
+
+		if privileged {
+			if err := os.Lchown(target, h.Uid, h.Gid); err != nil {
+				return err
+			}
+		}
+		if h.Typeflag == tar.TypeSymlink {
+			continue
+		}
+		// chown clears setuid bits, so permissions are applied after:
+		if err := os.Chmod(target, h.FileInfo().Mode()); err != nil {
+			return err
+		}
+		if err := os.Chtimes(target, h.ModTime, h.ModTime); err != nil {
+			return err
+		}
+	}
+}
+
+func replaceFile(target string, r io.Reader) error {
+	if err := removeExisting(target); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func removeExisting(target string) error {
+	if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// resolveInRoot returns the host path of name within root, following symlinks in parent directories as if root were /.
+// Absolute and escaping symlinks are resolved against root, so extraction can't write outside of it.
+func resolveInRoot(root, name string) (string, error) {
+	dir, file := path.Split(path.Clean("/" + name))
+	resolved := "/"
+	parts := strings.Split(dir, "/")
+	for links := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, part)
+		fi, err := os.Lstat(filepath.Join(root, filepath.FromSlash(next)))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if links++; links > 40 {
+			return "", fmt.Errorf("too many levels of symbolic links in %q", name)
+		}
+		link, err := os.Readlink(filepath.Join(root, filepath.FromSlash(next)))
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(link) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(link, "/"), parts...)
+	}
+	return filepath.Join(root, filepath.FromSlash(path.Join(resolved, file))), nil
+}
+
+// configureChroot installs the downloaded packages with dpkg inside the rootfs, running maintainer scripts.
+func configureChroot(ctx context.Context, rootfs, arch string, debs map[manifest.PackageName]string) error {
+	kernel, err := exec.CommandContext(ctx, "uname", "-m").Output()
+	if err != nil {
+		return fmt.Errorf("querying host architecture: %w", err)
+	}
+	if machine := strings.TrimSpace(string(kernel)); !executes(machine, arch) {
+		return fmt.Errorf("maintainer scripts for %s can't run on %s, use maintainer script mode %q", arch, machine, ScriptsNone)
+	}
+	if err := initDpkgDatabase(rootfs); err != nil {
+		return err
+	}
+
+	// Stage packages within the rootfs, the cache is removed before export:
+	archives := filepath.Join(rootfs, "var", "cache", "apt", "archives")
+	if err := os.MkdirAll(archives, 0755); err != nil {
+		return err
+	}
+	// base-passwd and base-files create the users and directories other maintainer scripts expect:
+	var first, rest []string
+	for name, deb := range debs {
+		staged := path.Join("/var/cache/apt/archives", filepath.Base(deb))
+		if err := copyFile(deb, filepath.Join(archives, filepath.Base(deb))); err != nil {
+			return err
+		}
+		if name == "base-passwd" || name == "base-files" {
+			first = append(first, staged)
+		} else {
+			rest = append(rest, staged)
+		}
+	}
+	sort.Strings(first)
+	sort.Strings(rest)
+
+	for _, args := range [][]string{
+		append([]string{"dpkg", "--force-depends", "--install"}, first...),
+		append([]string{"dpkg", "--force-depends", "--install"}, rest...),
+		{"dpkg", "--configure", "--pending"},
+	} {
+		if err := runChroot(ctx, rootfs, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runChroot(ctx context.Context, rootfs string, args ...string) error {
+	command := append([]string{"chroot", rootfs}, args...)
+	if os.Geteuid() != 0 {
+		command = append([]string{"fakechroot", "fakeroot"}, command...)
+	}
+	c := exec.CommandContext(ctx, command[0], command[1:]...)
+	c.Env = []string{
+		"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+		"DEBIAN_FRONTEND=noninteractive",
+		"DEBCONF_NONINTERACTIVE_SEEN=true",
+		"LC_ALL=C",
+	}
+	out, err := c.CombinedOutput()
+	logrus.WithField("args", args).Debug(string(out))
+	if err != nil {
+		return fmt.Errorf("running %s: %w\n%s", strings.Join(args[:2], " "), err, out)
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func initDpkgDatabase(rootfs string) error {
+	dpkgDir := filepath.Join(rootfs, "var", "lib", "dpkg")
+	for _, dir := range []string{"info", "updates", "triggers"} {
+		if err := os.MkdirAll(filepath.Join(dpkgDir, dir), 0755); err != nil {
+			return err
+		}
+	}
+	for _, file := range []string{"status", "available"} {
+		f, err := os.OpenFile(filepath.Join(dpkgDir, file), os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// maintainerScripts are control.tar members that dpkg keeps as executables.
+var maintainerScripts = map[string]bool{
+	"preinst":  true,
+	"postinst": true,
+	"prerm":    true,
+	"postrm":   true,
+	"config":   true,
+}
+
+// writeDpkgDatabase registers unpacked packages as installed, as if their maintainer scripts had succeeded.
+func writeDpkgDatabase(rootfs string, pkgs []*unpackedPackage) error {
+	if err := initDpkgDatabase(rootfs); err != nil {
+		return err
+	}
+	infoDir := filepath.Join(rootfs, "var", "lib", "dpkg", "info")
+
+	var status bytes.Buffer
+	for _, pkg := range pkgs {
+		infoName := pkg.control["Package"]
+		if pkg.control["Multi-Arch"] == "same" {
+			infoName = fmt.Sprintf("%s:%s", infoName, pkg.control["Architecture"])
+		}
+
+		list := strings.Join(pkg.files, "\n") + "\n"
+		if err := ioutil.WriteFile(filepath.Join(infoDir, infoName+".list"), []byte(list), 0644); err != nil {
+			return err
+		}
+		for member, data := range pkg.info {
+			mode := os.FileMode(0644)
+			if maintainerScripts[member] {
+				mode = 0755
+			}
+			if err := ioutil.WriteFile(filepath.Join(infoDir, fmt.Sprintf("%s.%s", infoName, member)), data, mode); err != nil {
+				return err
+			}
+		}
+
+		entry := make(dpkg.Paragraph, len(pkg.control)+2)
+		for k, v := range pkg.control {
+			entry[k] = v
+		}
+		entry["Status"] = "install ok installed"
+		if conffiles, err := conffileHashes(rootfs, pkg.info["conffiles"]); err != nil {
+			return err
+		} else if conffiles != "" {
+			entry["Conffiles"] = conffiles
+		}
+		if err := dpkg.WriteParagraph(&status, entry, dpkg.StatusFields); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootfs, filepath.FromSlash(dpkg.StatusPath)), status.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	return seedPasswd(rootfs)
+}
+
+// conffileHashes formats the Conffiles status field, which records the MD5 of each configuration file as shipped.
+func conffileHashes(rootfs string, conffiles []byte) (string, error) {
+	var ret strings.Builder
+	for _, conffile := range strings.Fields(string(conffiles)) {
+		data, err := ioutil.ReadFile(filepath.Join(rootfs, filepath.FromSlash(conffile)))
+		if err != nil {
+			return "", fmt.Errorf("hashing conffile: %w", err)
+		}
+		_, _ = fmt.Fprintf(&ret, "\n%s %x", conffile, md5.Sum(data))
+	}
+	return ret.String(), nil
+}
+
+// seedPasswd copies the user and group databases from base-passwd, which its postinst would otherwise create.
+func seedPasswd(rootfs string) error {
+	for _, db := range []string{"passwd", "group"} {
+		dst := filepath.Join(rootfs, "etc", db)
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		src := filepath.Join(rootfs, "usr", "share", "base-passwd", db+".master")
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		}
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeAptSources configures apt within the rootfs to use the same repositories as the docker backend.
+func writeAptSources(rootfs string, mf manifest.Manifest) error {
+	aptDir := filepath.Join(rootfs, "etc", "apt")
+	for _, dir := range []string{"sources.list.d", "apt.conf.d"} {
+		if err := os.MkdirAll(filepath.Join(aptDir, dir), 0755); err != nil {
+			return err
+		}
+	}
+	sources := strings.Join(distroSources(mf), "\n") + "\n"
+	if err := ioutil.WriteFile(filepath.Join(aptDir, "sources.list"), []byte(sources), 0644); err != nil {
+		return err
+	}
+	if snapshotted(mf) {
+		conf := []byte("Acquire::Check-Valid-Until \"false\";\n")
+		if err := ioutil.WriteFile(filepath.Join(rootfs, filepath.FromSlash(snapshotAptConf)), conf, 0644); err != nil {
+			return err
+		}
+	}
+
+	for _, repo := range mf.DpkgJSON.Repositories {
+		rp, err := newRepositoryParams(repo)
+		if err != nil {
+			return err
+		}
+		if rp.KeyPath == "" {
+			return fmt.Errorf("repository %q: host backend requires an embedded key", repo.Name)
+		}
+		keyring := filepath.Join(rootfs, filepath.FromSlash(rp.Keyring))
+		if err := os.MkdirAll(filepath.Dir(keyring), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(keyring, []byte(repo.Key), 0644); err != nil {
+			return err
+		}
+		if rp.Fingerprint != "" {
+			if err := verifyKeyFingerprint(keyring, rp.Fingerprint); err != nil {
+				return fmt.Errorf("repository %q: %w", repo.Name, err)
+			}
+		}
+		list := filepath.Join(rootfs, filepath.FromSlash(rp.SourcesList))
+		if err := ioutil.WriteFile(list, []byte(rp.SourcesLine+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyKeyFingerprint(keyring, fingerprint string) error {
+	out, err := exec.Command("gpg", "--show-keys", "--with-colons", keyring).Output()
+	if err != nil {
+		return fmt.Errorf("reading key: %w", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "fpr:") && strings.HasSuffix(line, fmt.Sprintf(":%s:", fingerprint)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("key does not match fingerprint %s", fingerprint)
+}
+
+// ExportsOwnership returns true if privileged, as unprivileged builds extract every file as the current user.
+func (h *HostBackend) ExportsOwnership() bool {
+	return os.Geteuid() == 0
+}
+
+// ExportTarball writes the rootfs as a tarball, in lexical order. Hard links are exported as links to the first
+// path in that order. Unprivileged builds can't preserve ownership, so files are exported as owned by root, see
+// ExportsOwnership.
+func (h *HostBackend) ExportTarball(_ context.Context, _ manifest.Manifest, arch, dst string) error {
+	rootfs := h.rootfs(arch)
+	if _, err := os.Stat(rootfs); err != nil {
+		return fmt.Errorf("rootfs for %s is not built: %w", arch, err)
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(f)
+	privileged := os.Geteuid() == 0
+	linked := map[fileID]string{}
+	err = filepath.Walk(rootfs, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(rootfs, p)
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = "./" + filepath.ToSlash(rel)
+		if rel == "." {
+			hdr.Name = "./"
+		} else if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uname, hdr.Gname = "", ""
+		if !privileged {
+			hdr.Uid, hdr.Gid = 0, 0
+		}
+		id, isLink := hardLinkID(fi)
+		if first, ok := linked[id]; isLink && ok {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = first
+			hdr.Size = 0
+			return tw.WriteHeader(hdr)
+		} else if isLink {
+			linked[id] = hdr.Name
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		in, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(tw, in)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing %q: %w", dst, err)
+	}
+	logrus.WithField("path", dst).Info("exported rootfs tarball")
+	return nil
+}
@@ -0,0 +1,184 @@
+package build_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/build"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+const testDeb = "../dpkg/testdata/hello_1.0-1_all.deb"
+
+func hostTestServer(t *testing.T) (*httptest.Server, string) {
+	deb, err := ioutil.ReadFile(testDeb)
+	require.NoError(t, err)
+	hash := sha512.Sum512(deb)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/debian/dists/buster/main/binary-amd64/Packages.gz":
+			gz := gzip.NewWriter(w)
+			_, _ = gz.Write([]byte("Package: hello\nVersion: 1.0-1\nArchitecture: all\nFilename: pool/main/h/hello/hello_1.0-1_all.deb\n"))
+			_ = gz.Close()
+		case "/debian/pool/main/h/hello/hello_1.0-1_all.deb":
+			_, _ = w.Write(deb)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, hex.EncodeToString(hash[:])
+}
+
+func hostTestManifest(mirror, hash string) manifest.Manifest {
+	return manifest.Manifest{
+		DpkgJSON: manifest.DpkgJSON{
+			Image:  "test",
+			Distro: "buster",
+			Mirror: mirror,
+			Packages: map[manifest.PackageName]manifest.PackageVersion{
+				"hello": "stable",
+			},
+		},
+		DpkgLockJSON: &manifest.DpkgLockJSON{
+			Packages: map[manifest.PackageName]manifest.LockedPackage{
+				"hello": {
+					Version:      "1.0-1",
+					Architecture: "all",
+					DebFilename:  "hello_1.0-1_all.deb",
+					DebHash:      hash,
+				},
+			},
+		},
+	}
+}
+
+func TestHostBackend_Build(t *testing.T) {
+	srv, hash := hostTestServer(t)
+	workDir := t.TempDir()
+	host, err := build.NewHostBackend(srv.Client(), workDir, build.ScriptsNone)
+	require.NoError(t, err)
+	b := build.NewBuilder(host)
+
+	ctx := context.Background()
+	mf := hostTestManifest(srv.URL+"/debian", hash)
+	require.NoError(t, b.Build(ctx, mf))
+
+	rootfs := filepath.Join(workDir, "amd64", "rootfs")
+	readme, err := ioutil.ReadFile(filepath.Join(rootfs, "usr/share/doc/hello/README"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", string(readme))
+
+	status, err := ioutil.ReadFile(filepath.Join(rootfs, "var/lib/dpkg/status"))
+	require.NoError(t, err)
+	assert.Contains(t, string(status), "Package: hello\nStatus: install ok installed\n")
+	assert.Contains(t, string(status), "Conffiles:\n /etc/hello.conf ")
+	postinst, err := os.Stat(filepath.Join(rootfs, "var/lib/dpkg/info/hello.postinst"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), postinst.Mode().Perm())
+
+	sources, err := ioutil.ReadFile(filepath.Join(rootfs, "etc/apt/sources.list"))
+	require.NoError(t, err)
+	assert.Equal(t, "deb "+srv.URL+"/debian buster main\n"+
+		"deb "+srv.URL+"/debian buster-updates main\n"+
+		"deb "+manifest.DefaultSecurityMirror+" buster/updates main\n", string(sources))
+
+	tarball := filepath.Join(t.TempDir(), "image.tar")
+	require.NoError(t, b.ExportTarball(ctx, mf, "amd64", tarball))
+	f, err := os.Open(tarball)
+	require.NoError(t, err)
+	defer f.Close()
+	var names []string
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, h.Name)
+		assert.Equal(t, 0, h.Uid)
+	}
+	assert.Equal(t, "./", names[0])
+	assert.Contains(t, names, "./etc/hello.conf")
+	assert.Contains(t, names, "./var/lib/dpkg/info/hello.list")
+}
+
+func TestHostBackend_BuildHashMismatch(t *testing.T) {
+	srv, _ := hostTestServer(t)
+	host, err := build.NewHostBackend(srv.Client(), t.TempDir(), build.ScriptsNone)
+	require.NoError(t, err)
+
+	mf := hostTestManifest(srv.URL+"/debian", "deadbeef")
+	err = build.NewBuilder(host).Build(context.Background(), mf)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "hash mismatch")
+}
+
+func TestHostBackend_Provenance(t *testing.T) {
+	srv, hash := hostTestServer(t)
+	host, err := build.NewHostBackend(srv.Client(), t.TempDir(), build.ScriptsNone)
+	require.NoError(t, err)
+
+	mf := hostTestManifest(srv.URL+"/debian", hash)
+	p, err := build.NewBuilder(host).Provenance(context.Background(), mf, "amd64")
+	require.NoError(t, err)
+	assert.Equal(t, build.BuildTypeHost, p.BuildType)
+	assert.Empty(t, p.BaseImage)
+	assert.Equal(t, map[manifest.PackageName]string{
+		"hello": srv.URL + "/debian/pool/main/h/hello/hello_1.0-1_all.deb",
+	}, p.Debs)
+}
+
+func TestHostBackend_Lock(t *testing.T) {
+	host, err := build.NewHostBackend(http.DefaultClient, t.TempDir(), build.ScriptsNone)
+	require.NoError(t, err)
+
+	_, err = build.NewBuilder(host).Lock(context.Background(), hostTestManifest("", ""))
+	assert.Error(t, err)
+}
+
+func TestHostBackend_ExportTarballHardLinks(t *testing.T) {
+	workDir := t.TempDir()
+	rootfs := filepath.Join(workDir, "amd64", "rootfs")
+	require.NoError(t, os.MkdirAll(filepath.Join(rootfs, "usr", "bin"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(rootfs, "usr", "bin", "perl5.36.0"), []byte("perl"), 0755))
+	require.NoError(t, os.Link(filepath.Join(rootfs, "usr", "bin", "perl5.36.0"), filepath.Join(rootfs, "usr", "bin", "perl")))
+
+	host, err := build.NewHostBackend(http.DefaultClient, workDir, build.ScriptsNone)
+	require.NoError(t, err)
+	tarball := filepath.Join(t.TempDir(), "image.tar")
+	require.NoError(t, host.ExportTarball(context.Background(), manifest.Manifest{}, "amd64", tarball))
+
+	f, err := os.Open(tarball)
+	require.NoError(t, err)
+	defer f.Close()
+	headers := map[string]*tar.Header{}
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		require.NoError(t, err)
+		headers[h.Name] = h
+	}
+	assert.Equal(t, byte(tar.TypeReg), headers["./usr/bin/perl"].Typeflag)
+	assert.Equal(t, int64(4), headers["./usr/bin/perl"].Size)
+	assert.Equal(t, byte(tar.TypeLink), headers["./usr/bin/perl5.36.0"].Typeflag)
+	assert.Equal(t, "./usr/bin/perl", headers["./usr/bin/perl5.36.0"].Linkname)
+}
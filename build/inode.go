@@ -0,0 +1,22 @@
+//go:build !windows
+
+package build
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file by device and inode, which hard links share.
+type fileID struct {
+	dev, ino uint64
+}
+
+// hardLinkID returns the fileID of a regular file with multiple links.
+func hardLinkID(fi os.FileInfo) (fileID, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || !fi.Mode().IsRegular() || st.Nlink < 2 {
+		return fileID{}, false
+	}
+	return fileID{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
@@ -0,0 +1,139 @@
+package build
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+// MetadataDir holds the manifest, lockfile and SBOM an exported image was built from.
+const MetadataDir = "/var/lib/debendabot"
+
+// Labels recording how an image was built, applied as image labels and OCI annotations.
+const (
+	LabelManifestDigest = "io.github.thepwagner.debendabot.manifest.digest"
+	LabelLockfileDigest = "io.github.thepwagner.debendabot.lockfile.digest"
+	LabelVersion        = "io.github.thepwagner.debendabot.version"
+)
+
+// Metadata records how an image was built.
+type Metadata struct {
+	// Manifest and Lockfile are dpkg.json and dpkg-lock.json, as read.
+	Manifest []byte
+	Lockfile []byte
+	// SBOM is an SPDX document describing the image.
+	SBOM []byte
+	// Version of debendabot that built the image.
+	Version string
+}
+
+// Labels returns the labels of an image built from the metadata.
+func (m Metadata) Labels() map[string]string {
+	labels := map[string]string{
+		LabelManifestDigest: digest.FromBytes(m.Manifest).String(),
+		LabelVersion:        m.Version,
+	}
+	if m.Lockfile != nil {
+		labels[LabelLockfileDigest] = digest.FromBytes(m.Lockfile).String()
+	}
+	return labels
+}
+
+// files returns the contents of MetadataDir by name.
+func (m Metadata) files() map[string][]byte {
+	files := map[string][]byte{manifest.Filename: m.Manifest}
+	if m.Lockfile != nil {
+		files[manifest.LockFilename] = m.Lockfile
+	}
+	if m.SBOM != nil {
+		files["sbom.spdx.json"] = m.SBOM
+	}
+	return files
+}
+
+// EmbedMetadata adds metadata to a rootfs tarball, replacing any that is already embedded.
+// Entries are appended, so normalized tarballs must be normalized again.
+func EmbedMetadata(tarball string, m Metadata, modTime time.Time) error {
+	in, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := ioutil.TempFile(filepath.Dir(tarball), ".metadata")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	dir := strings.TrimPrefix(MetadataDir, "/")
+	tr := tar.NewReader(in)
+	tw := tar.NewWriter(out)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("reading %q: %w", tarball, err)
+		}
+		name := strings.TrimPrefix(path.Clean("/"+h.Name), "/")
+		if name == dir || strings.HasPrefix(name, dir+"/") {
+			continue
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     tarballName(dir, true),
+		Mode:     0755,
+		ModTime:  modTime,
+	}); err != nil {
+		return err
+	}
+	files := m.files()
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		content := files[name]
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     tarballName(path.Join(dir, name), false),
+			Mode:     0644,
+			Size:     int64(len(content)),
+			ModTime:  modTime,
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), tarball)
+}
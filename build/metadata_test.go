@@ -0,0 +1,48 @@
+package build_test
+
+import (
+	"archive/tar"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/build"
+)
+
+func TestEmbedMetadata(t *testing.T) {
+	dir := t.TempDir()
+	epoch := time.Unix(1594944000, 0).UTC()
+	tarball := filepath.Join(dir, "image.tar")
+	writeTestTarball(t, tarball, []testEntry{
+		{header: tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755}},
+		{header: tar.Header{Typeflag: tar.TypeReg, Name: "./var/lib/debendabot/dpkg.json", Mode: 0644}, content: "stale"},
+	})
+
+	m := build.Metadata{
+		Manifest: []byte(`{"image":"test"}`),
+		Lockfile: []byte(`{"image":"debian"}`),
+		SBOM:     []byte(`{"spdxVersion":"SPDX-2.3"}`),
+		Version:  "v1.0.0",
+	}
+	require.NoError(t, build.EmbedMetadata(tarball, m, epoch))
+	require.NoError(t, build.NormalizeTarball(tarball, epoch))
+
+	contents := map[string]string{}
+	for _, e := range readTestTarball(t, tarball) {
+		contents[e.header.Name] = e.content
+	}
+	assert.Equal(t, map[string]string{
+		"./":                                  "",
+		"./var/lib/debendabot/":               "",
+		"./var/lib/debendabot/dpkg-lock.json": `{"image":"debian"}`,
+		"./var/lib/debendabot/dpkg.json":      `{"image":"test"}`,
+		"./var/lib/debendabot/sbom.spdx.json": `{"spdxVersion":"SPDX-2.3"}`,
+	}, contents)
+
+	labels := m.Labels()
+	assert.Equal(t, "v1.0.0", labels[build.LabelVersion])
+	assert.Equal(t, "sha256:a5391d09a7e66483b23363ff0f29cb2db3164f9b2aedfdb35f66f1dff6382197", labels[build.LabelManifestDigest])
+	assert.Contains(t, labels, build.LabelLockfileDigest)
+}
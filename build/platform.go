@@ -0,0 +1,87 @@
+package build
+
+import (
+	"context"
+	"fmt"
+)
+
+// platform is an architecture a rootfs is built for, relative to the docker daemon.
+type platform struct {
+	arch string
+	// foreign platforms can't be executed natively, and are emulated by qemu-user-static.
+	foreign bool
+}
+
+// qemuBinaries maps Debian architectures to the qemu-user-static binary that emulates them.
+var qemuBinaries = map[string]string{
+	"amd64":    "qemu-x86_64-static",
+	"arm64":    "qemu-aarch64-static",
+	"armel":    "qemu-arm-static",
+	"armhf":    "qemu-arm-static",
+	"i386":     "qemu-i386-static",
+	"mips64el": "qemu-mips64el-static",
+	"mipsel":   "qemu-mipsel-static",
+	"ppc64el":  "qemu-ppc64le-static",
+	"s390x":    "qemu-s390x-static",
+}
+
+// nativeArchitectures maps kernel architectures (as reported by the docker daemon) to Debian architectures they execute.
+var nativeArchitectures = map[string][]string{
+	"x86_64":  {"amd64", "i386"},
+	"aarch64": {"arm64"},
+	"armv7l":  {"armhf", "armel"},
+	"i686":    {"i386"},
+	"ppc64le": {"ppc64el"},
+	"s390x":   {"s390x"},
+}
+
+// executes returns true if the kernel architecture executes the Debian architecture.
+func executes(kernel, arch string) bool {
+	for _, native := range nativeArchitectures[kernel] {
+		if native == arch {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *DockerBackend) platform(ctx context.Context, arch string) (platform, error) {
+	info, err := d.docker.Info(ctx)
+	if err != nil {
+		return platform{}, fmt.Errorf("querying docker architecture: %w", err)
+	}
+
+	p := platform{arch: arch, foreign: !executes(info.Architecture, arch)}
+	if _, ok := qemuBinaries[arch]; p.foreign && !ok {
+		return platform{}, fmt.Errorf("unsupported foreign architecture %q", arch)
+	}
+	return p, nil
+}
+
+func (p platform) qemuBinary() string {
+	if !p.foreign {
+		return ""
+	}
+	return qemuBinaries[p.arch]
+}
+
+// dockerPlatforms maps Debian architectures to docker's os/arch[/variant] platforms.
+var dockerPlatforms = map[string]string{
+	"amd64":    "linux/amd64",
+	"arm64":    "linux/arm64",
+	"armel":    "linux/arm/v5",
+	"armhf":    "linux/arm/v7",
+	"i386":     "linux/386",
+	"mips64el": "linux/mips64le",
+	"mipsel":   "linux/mipsle",
+	"ppc64el":  "linux/ppc64le",
+	"s390x":    "linux/s390x",
+}
+
+// DockerPlatform returns the docker platform (e.g. "linux/arm/v7") for a Debian architecture.
+func DockerPlatform(arch string) string {
+	if p, ok := dockerPlatforms[arch]; ok {
+		return p
+	}
+	return "linux/" + arch
+}
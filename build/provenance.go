@@ -0,0 +1,88 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/thepwagner/debendabot/apt"
+	"github.com/thepwagner/debendabot/dpkg"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+// Build types identify how a backend assembles a rootfs, in provenance attestations.
+const (
+	BuildTypeDocker = "https://github.com/thepwagner/debendabot/build/docker@v1"
+	BuildTypeHost   = "https://github.com/thepwagner/debendabot/build/host@v1"
+)
+
+// Provenance records the inputs of a build, beyond the manifest and lockfile.
+type Provenance struct {
+	BuildType string
+	// BaseImage is the pinned image the rootfs is bootstrapped from, empty if the backend doesn't use one.
+	BaseImage string
+	// Config is specific to the backend, e.g. the generated Dockerfile.
+	Config map[string]string
+	// Debs are the URLs of the locked packages.
+	Debs map[manifest.PackageName]string
+}
+
+// Provenancer is implemented by backends that can describe the inputs of a build.
+type Provenancer interface {
+	Provenance(ctx context.Context, mf manifest.Manifest, arch string) (*Provenance, error)
+}
+
+// Provenance describes the inputs of an architecture's build. The manifest must be locked.
+func (b *Builder) Provenance(ctx context.Context, mf manifest.Manifest, arch string) (*Provenance, error) {
+	provenancer, ok := b.backend.(Provenancer)
+	if !ok {
+		return nil, fmt.Errorf("backend %T can't describe builds", b.backend)
+	}
+	if mf.DpkgLockJSON == nil {
+		return nil, fmt.Errorf("lockfile not found, run update")
+	}
+	return provenancer.Provenance(ctx, mf, arch)
+}
+
+// DebURLs locates each locked package from its recorded pool path, or in the Packages indexes of the repository
+// that provided it for lockfiles that don't record pool paths.
+func DebURLs(ctx context.Context, client *apt.Client, mf manifest.Manifest, arch string, locked map[manifest.PackageName]manifest.LockedPackage) (map[manifest.PackageName]string, error) {
+	ret := make(map[manifest.PackageName]string, len(locked))
+	for name, lock := range locked {
+		if url, ok := mf.DebURL(lock); ok {
+			ret[name] = url
+		}
+	}
+	if len(ret) == len(locked) {
+		return ret, nil
+	}
+
+	for _, src := range apt.MirrorSources(mf, mf.Mirror(), mf.SecurityMirror()) {
+		if len(ret) == len(locked) {
+			break
+		}
+		uri := strings.TrimSuffix(src.URI, "/")
+		err := client.Packages(ctx, src, arch, func(p dpkg.Paragraph) error {
+			name := manifest.PackageName(p["Package"])
+			if _, ok := ret[name]; ok {
+				return nil
+			}
+			lock, ok := locked[name]
+			if !ok || lock.Repository != src.Repository || lock.Version != p["Version"] || lock.Architecture != p["Architecture"] {
+				return nil
+			}
+			ret[name] = fmt.Sprintf("%s/%s", uri, p["Filename"])
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for name, lock := range locked {
+		if _, ok := ret[name]; !ok {
+			return nil, fmt.Errorf("package %q version %q not found in repository indexes", name, lock.Version)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,291 @@
+package build
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+// unreproducibleFiles are removed from normalized tarballs, as their contents depend on when or how they were built.
+var unreproducibleFiles = map[string]bool{
+	// ldconfig records inodes and ctimes here. ld.so.cache itself is sorted, so it is kept.
+	"var/cache/ldconfig/aux-cache":        true,
+	"var/cache/debconf/config.dat-old":    true,
+	"var/cache/debconf/templates.dat-old": true,
+	"var/cache/debconf/passwords.dat-old": true,
+	"var/lib/dpkg/available-old":          true,
+	"var/lib/dpkg/diversions-old":         true,
+	"var/lib/dpkg/statoverride-old":       true,
+	"var/lib/dpkg/status-old":             true,
+	"var/lib/systemd/random-seed":         true,
+}
+
+// truncatedFile returns true for files that are kept empty in normalized tarballs.
+func truncatedFile(name string) bool {
+	return name == "etc/machine-id" || strings.HasPrefix(name, "var/log/")
+}
+
+// shadowFiles record the day each password was last changed.
+var shadowFiles = map[string]bool{
+	"etc/shadow":  true,
+	"etc/shadow-": true,
+}
+
+// spooledEntry is a tarball entry whose contents were copied to a spool file at offset.
+type spooledEntry struct {
+	header *tar.Header
+	offset int64
+}
+
+// NormalizeTarball rewrites a rootfs tarball so identical packages produce identical bytes.
+// Entries are sorted by name, timestamps are set to epoch, owner names are dropped and files that record the time of
+// the build are removed or rewritten.
+func NormalizeTarball(tarball string, epoch time.Time) error {
+	in, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	spool, err := ioutil.TempFile(filepath.Dir(tarball), ".spool")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	entries, err := spoolTarball(in, spool, epoch)
+	if err != nil {
+		return fmt.Errorf("reading %q: %w", tarball, err)
+	}
+
+	out, err := ioutil.TempFile(filepath.Dir(tarball), ".normalized")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	if err := writeNormalized(out, spool, entries, epoch); err != nil {
+		return fmt.Errorf("writing %q: %w", tarball, err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), tarball)
+}
+
+func spoolTarball(r io.Reader, spool *os.File, epoch time.Time) (map[string]*spooledEntry, error) {
+	entries := map[string]*spooledEntry{}
+	var offset int64
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+h.Name), "/")
+		if unreproducibleFiles[name] {
+			continue
+		}
+		h.Name = tarballName(name, h.Typeflag == tar.TypeDir)
+		if h.Typeflag == tar.TypeLink {
+			h.Linkname = tarballName(strings.TrimPrefix(path.Clean("/"+h.Linkname), "/"), false)
+		}
+		// Later entries replace earlier ones, as they would when extracted:
+		entry := &spooledEntry{header: h, offset: offset}
+		entries[h.Name] = entry
+
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		var content io.Reader = tr
+		switch {
+		case truncatedFile(name):
+			content = bytes.NewReader(nil)
+		case shadowFiles[name]:
+			b, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			content = bytes.NewReader(normalizeShadow(b, epoch))
+		}
+		n, err := io.Copy(spool, content)
+		if err != nil {
+			return nil, err
+		}
+		h.Size = n
+		offset += n
+	}
+}
+
+// tarballName formats a name relative to the rootfs the way `tar -C rootfs -c .` does.
+func tarballName(name string, dir bool) string {
+	if name == "" {
+		return "./"
+	}
+	if dir {
+		return "./" + name + "/"
+	}
+	return "./" + name
+}
+
+// normalizeShadow sets the date passwords were last changed to the epoch, for passwords that record one.
+func normalizeShadow(b []byte, epoch time.Time) []byte {
+	days := strconv.FormatInt(epoch.Unix()/int64(24*time.Hour/time.Second), 10)
+	lines := strings.Split(string(b), "\n")
+	for i, line := range lines {
+		fields := strings.Split(line, ":")
+		if len(fields) > 2 && fields[2] != "" {
+			fields[2] = days
+			lines[i] = strings.Join(fields, ":")
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func writeNormalized(w io.Writer, spool io.ReaderAt, entries map[string]*spooledEntry, epoch time.Time) error {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hardlinksFirst(names, entries)
+
+	tw := tar.NewWriter(w)
+	for _, name := range names {
+		entry := entries[name]
+		h := entry.header
+		normalized := &tar.Header{
+			Typeflag: h.Typeflag,
+			Name:     h.Name,
+			Linkname: h.Linkname,
+			Size:     h.Size,
+			Mode:     h.Mode,
+			Uid:      h.Uid,
+			Gid:      h.Gid,
+			ModTime:  epoch,
+			Devmajor: h.Devmajor,
+			Devminor: h.Devminor,
+		}
+		if h.Typeflag != tar.TypeReg {
+			normalized.Size = 0
+		}
+		// Only extended attributes (e.g. file capabilities) are kept from PAX records:
+		for k, v := range h.PAXRecords {
+			if strings.HasPrefix(k, "SCHILY.xattr.") {
+				if normalized.PAXRecords == nil {
+					normalized.PAXRecords = map[string]string{}
+				}
+				normalized.PAXRecords[k] = v
+			}
+		}
+
+		if err := tw.WriteHeader(normalized); err != nil {
+			return err
+		}
+		if normalized.Size == 0 {
+			continue
+		}
+		if _, err := io.Copy(tw, io.NewSectionReader(spool, entry.offset, normalized.Size)); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// hardlinksFirst makes the first of a set of hard links in sorted order hold the contents, as tar requires a hard
+// link's target to precede it.
+func hardlinksFirst(sorted []string, entries map[string]*spooledEntry) {
+	// Group links by the file they link to:
+	groups := map[string][]string{}
+	for _, name := range sorted {
+		h := entries[name].header
+		if h.Typeflag != tar.TypeLink {
+			continue
+		}
+		if target, ok := entries[h.Linkname]; ok && target.header.Typeflag == tar.TypeReg {
+			groups[h.Linkname] = append(groups[h.Linkname], name)
+		}
+	}
+
+	for target, links := range groups {
+		first := links[0]
+		if target < first {
+			first = target
+		}
+		content := entries[target]
+		for _, name := range append(links, target) {
+			if name == first {
+				continue
+			}
+			h := *content.header
+			h.Name = name
+			h.Typeflag = tar.TypeLink
+			h.Linkname = first
+			h.Size = 0
+			entries[name] = &spooledEntry{header: &h}
+		}
+		h := *content.header
+		h.Name = first
+		entries[first] = &spooledEntry{header: &h, offset: content.offset}
+	}
+}
+
+// RootfsDigest returns the digests of a normalized rootfs tarball, for the lockfile.
+func RootfsDigest(tarball string) (manifest.RootfsDigest, error) {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return manifest.RootfsDigest{}, err
+	}
+	defer f.Close()
+
+	tarballDigester := digest.SHA256.Digester()
+	filesDigester := digest.SHA256.Digester()
+	tr := tar.NewReader(io.TeeReader(f, tarballDigester.Hash()))
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return manifest.RootfsDigest{}, fmt.Errorf("reading %q: %w", tarball, err)
+		}
+
+		var target string
+		switch h.Typeflag {
+		case tar.TypeReg:
+			d, err := digest.SHA256.FromReader(tr)
+			if err != nil {
+				return manifest.RootfsDigest{}, err
+			}
+			target = d.String()
+		case tar.TypeSymlink, tar.TypeLink:
+			target = h.Linkname
+		}
+		_, _ = fmt.Fprintf(filesDigester.Hash(), "%s %c %o %d:%d %d %s\n", h.Name, h.Typeflag, h.Mode, h.Uid, h.Gid, h.Size, target)
+	}
+	// Include the end of archive padding, which the tar reader doesn't consume:
+	if _, err := io.Copy(tarballDigester.Hash(), f); err != nil {
+		return manifest.RootfsDigest{}, err
+	}
+
+	return manifest.RootfsDigest{
+		Tarball: tarballDigester.Digest().String(),
+		Files:   filesDigester.Digest().String(),
+	}, nil
+}
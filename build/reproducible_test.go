@@ -0,0 +1,126 @@
+package build_test
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/build"
+)
+
+type testEntry struct {
+	header  tar.Header
+	content string
+}
+
+func writeTestTarball(t *testing.T, path string, entries []testEntry) {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	for _, e := range entries {
+		h := e.header
+		h.Size = int64(len(e.content))
+		require.NoError(t, tw.WriteHeader(&h))
+		_, err := tw.Write([]byte(e.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+}
+
+func readTestTarball(t *testing.T, path string) []testEntry {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var ret []testEntry
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return ret
+		}
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		ret = append(ret, testEntry{header: *h, content: string(b)})
+	}
+}
+
+func rootfsEntries(mtime time.Time) []testEntry {
+	return []testEntry{
+		{header: tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755, ModTime: mtime}},
+		{header: tar.Header{Typeflag: tar.TypeDir, Name: "./usr/", Mode: 0755, ModTime: mtime, Uname: "root"}},
+		{header: tar.Header{Typeflag: tar.TypeReg, Name: "./usr/perl", Mode: 0755, ModTime: mtime}, content: "perl"},
+		{header: tar.Header{Typeflag: tar.TypeLink, Name: "./usr/perl5", Linkname: "./usr/perl", ModTime: mtime}},
+		{header: tar.Header{Typeflag: tar.TypeLink, Name: "./usr/aperl", Linkname: "./usr/perl", ModTime: mtime}},
+		{header: tar.Header{Typeflag: tar.TypeDir, Name: "./etc/", Mode: 0755, ModTime: mtime}},
+		{header: tar.Header{Typeflag: tar.TypeReg, Name: "./etc/shadow", Mode: 0640, Gid: 42, ModTime: mtime}, content: "root:*:" + mtime.Format("20060102") + ":0:99999:7:::\nnobody:*::0:99999:7:::\n"},
+		{header: tar.Header{Typeflag: tar.TypeReg, Name: "./var/log/dpkg.log", Mode: 0644, ModTime: mtime}, content: mtime.String()},
+		{header: tar.Header{Typeflag: tar.TypeReg, Name: "./var/cache/ldconfig/aux-cache", Mode: 0600, ModTime: mtime}, content: mtime.String()},
+	}
+}
+
+func TestNormalizeTarball(t *testing.T) {
+	dir := t.TempDir()
+	epoch := time.Unix(1594944000, 0).UTC()
+
+	first := filepath.Join(dir, "first.tar")
+	writeTestTarball(t, first, rootfsEntries(time.Now()))
+	require.NoError(t, build.NormalizeTarball(first, epoch))
+
+	entries := readTestTarball(t, first)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.header.Name)
+		assert.True(t, epoch.Equal(e.header.ModTime), e.header.Name)
+		assert.Equal(t, "", e.header.Uname)
+	}
+	assert.Equal(t, []string{
+		"./",
+		"./etc/",
+		"./etc/shadow",
+		"./usr/",
+		"./usr/aperl",
+		"./usr/perl",
+		"./usr/perl5",
+		"./var/log/dpkg.log",
+	}, names)
+
+	// The first hard link holds the content:
+	assert.Equal(t, byte(tar.TypeReg), entries[4].header.Typeflag)
+	assert.Equal(t, "perl", entries[4].content)
+	assert.Equal(t, "./usr/aperl", entries[5].header.Linkname)
+	assert.Equal(t, "./usr/aperl", entries[6].header.Linkname)
+
+	assert.Equal(t, "root:*:18460:0:99999:7:::\nnobody:*::0:99999:7:::\n", entries[2].content)
+	assert.Equal(t, 42, entries[2].header.Gid)
+	assert.Equal(t, "", entries[7].content)
+
+	// A later build, in a different order, is identical:
+	second := filepath.Join(dir, "second.tar")
+	reversed := rootfsEntries(time.Now().Add(time.Hour))
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+	writeTestTarball(t, second, reversed)
+	require.NoError(t, build.NormalizeTarball(second, epoch))
+
+	firstDigest, err := build.RootfsDigest(first)
+	require.NoError(t, err)
+	secondDigest, err := build.RootfsDigest(second)
+	require.NoError(t, err)
+	assert.Equal(t, firstDigest, secondDigest)
+
+	firstFile, err := ioutil.ReadFile(first)
+	require.NoError(t, err)
+	assert.Equal(t, digest.FromBytes(firstFile).String(), firstDigest.Tarball)
+}
@@ -0,0 +1,137 @@
+package build
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/thepwagner/debendabot/apt"
+	"github.com/thepwagner/debendabot/dpkg"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+// resolveConstraints pins version ranges from dpkg.json to the newest candidate available to a platform.
+func (d *DockerBackend) resolveConstraints(ctx context.Context, mf manifest.Manifest, p platform) (manifest.Manifest, error) {
+	ranges := map[string]dpkg.Constraint{}
+	for name, version := range mf.DpkgJSON.Packages {
+		if version.IsSuite() {
+			continue
+		}
+		constraint, err := version.Constraint()
+		if err != nil {
+			return mf, fmt.Errorf("package %q: %w", name, err)
+		}
+		if _, ok := constraint.Exact(); !ok {
+			ranges[string(name)] = constraint
+		}
+	}
+	if len(ranges) == 0 {
+		return mf, nil
+	}
+
+	// Packages aren't installed until after bootstrap, so it can be built before they are resolved:
+	bootstrapMf := mf
+	bootstrapMf.DpkgJSON.Packages = nil
+	bootstrapImage := platformImage("debendabot-bootstrap", mf, p.arch)
+	if err := d.build(ctx, bootstrapMf, p, "bootstrap", bootstrapImage, false); err != nil {
+		return mf, fmt.Errorf("building bootstrap: %w", err)
+	}
+	ctrID, err := d.createContainer(ctx, bootstrapImage)
+	if err != nil {
+		return mf, err
+	}
+	defer d.removeContainer(ctx, ctrID)
+
+	candidates := make(map[string]dpkg.Version, len(ranges))
+	err = d.readPackagesIndexes(ctx, ctrID, func(pkg dpkg.Paragraph) error {
+		name := pkg["Package"]
+		constraint, ok := ranges[name]
+		if !ok || (pkg["Architecture"] != p.arch && pkg["Architecture"] != "all") {
+			return nil
+		}
+		v, err := dpkg.ParseVersion(pkg["Version"])
+		if err != nil {
+			return fmt.Errorf("package %q: %w", name, err)
+		}
+		if best, ok := candidates[name]; constraint.Matches(v) && (!ok || v.Compare(best) > 0) {
+			candidates[name] = v
+		}
+		return nil
+	})
+	if err != nil {
+		return mf, err
+	}
+
+	resolved := make(map[manifest.PackageName]manifest.PackageVersion, len(mf.DpkgJSON.Packages))
+	for name, version := range mf.DpkgJSON.Packages {
+		resolved[name] = version
+		if _, ok := ranges[string(name)]; !ok {
+			continue
+		}
+		candidate, ok := candidates[string(name)]
+		if !ok {
+			return mf, fmt.Errorf("no candidate for package %q matches %q", name, version)
+		}
+		resolved[name] = manifest.PackageVersion(candidate.String())
+		logrus.WithFields(logrus.Fields{
+			"pkg":        name,
+			"constraint": version,
+			"version":    candidate,
+			"arch":       p.arch,
+		}).Info("resolved version constraint")
+	}
+	mf.DpkgJSON.Packages = resolved
+	return mf, nil
+}
+
+// readPackagesIndexes streams every package from the rootfs Packages indexes in a container.
+func (d *DockerBackend) readPackagesIndexes(ctx context.Context, containerID string, fn func(dpkg.Paragraph) error) error {
+	return d.readAptLists(ctx, containerID, func(name string, r io.Reader) error {
+		if !strings.HasSuffix(name, "_Packages") {
+			return nil
+		}
+		if err := dpkg.ReadControl(r, fn); err != nil {
+			return fmt.Errorf("parsing %q: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// aptListings locates every package version in the rootfs Packages indexes in a container.
+func (d *DockerBackend) aptListings(ctx context.Context, containerID string) (map[apt.ListingKey]apt.Listing, error) {
+	lists := apt.NewLists()
+	if err := d.readAptLists(ctx, containerID, lists.Add); err != nil {
+		return nil, err
+	}
+	return lists.Listings(), nil
+}
+
+// readAptLists streams every file of the rootfs apt lists in a container.
+func (d *DockerBackend) readAptLists(ctx context.Context, containerID string, fn func(name string, r io.Reader) error) error {
+	copied, _, err := d.docker.CopyFromContainer(ctx, containerID, path.Join(rootfsPath, apt.ListsPath))
+	if err != nil {
+		return fmt.Errorf("copying apt lists: %w", err)
+	}
+	defer copied.Close()
+
+	tr := tar.NewReader(copied)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading apt lists: %w", err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(h.Name, tr); err != nil {
+			return err
+		}
+	}
+}
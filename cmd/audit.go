@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/thepwagner/debendabot/dpkg"
+	"github.com/thepwagner/debendabot/manifest"
+	"github.com/thepwagner/debendabot/rootfs"
+	"github.com/thepwagner/debendabot/security"
+)
+
+var auditCmd = &cobra.Command{
+	Use:   "audit [rootfs]",
+	Short: "Audit locked packages for vulnerabilities",
+	Long: `Cross-reference the lockfile with a Debian Security Tracker dump (https://security-tracker.debian.org/tracker/data/json)
+Lockfiles before version 5 don't record source packages, which are read from the exported image tarballs by default, or a rootfs directory or tarball.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mf, err := parseManifest(cmd)
+		if err != nil {
+			return err
+		}
+		// Findings exceeding the threshold are not usage errors:
+		cmd.SilenceUsage = true
+		return AuditCommand(cmd, *mf, args)
+	},
+}
+
+const (
+	flagTracker = "tracker"
+	flagFailOn  = "fail-on"
+
+	failOnNone = "none"
+)
+
+type auditFinding struct {
+	security.Finding
+	Packages []manifest.PackageName `json:"packages"`
+}
+
+func AuditCommand(cmd *cobra.Command, mf manifest.Manifest, args []string) error {
+	if mf.DpkgLockJSON == nil {
+		return fmt.Errorf("lockfile not found, run update")
+	}
+	format, err := cmd.Flags().GetString(flagFormat)
+	if err != nil {
+		return err
+	}
+	failOn, err := cmd.Flags().GetString(flagFailOn)
+	if err != nil {
+		return err
+	}
+	failRank := -1
+	if failOn != failOnNone {
+		if failRank, err = security.UrgencyRank(failOn); err != nil {
+			return err
+		}
+	}
+
+	trackerPath, err := cmd.Flags().GetString(flagTracker)
+	if err != nil {
+		return err
+	}
+	tracker, err := loadTracker(trackerPath)
+	if err != nil {
+		return err
+	}
+
+	// The tracker lists releases by codename, so aliases like "stable" would match no issues:
+	release, err := mf.Codename()
+	if err != nil {
+		return err
+	}
+
+	targets, err := indexTargets(cmd, mf, args)
+	if err != nil {
+		return err
+	}
+
+	// Issues are tracked per source package, which may build several locked packages:
+	type sourceVersion struct{ source, version string }
+	binaries := map[sourceVersion][]manifest.PackageName{}
+	var guessed int
+	for arch, path := range targets {
+		locked := mf.DpkgLockJSON.PackagesFor(arch)
+		var installed map[string]dpkg.Package
+		if !lockedSources(locked) {
+			if installed, err = installedPackages(path); errors.Is(err, os.ErrNotExist) && len(args) == 0 {
+				logrus.WithField("path", path).Warn("image not exported, assuming source packages by name")
+			} else if err != nil {
+				return err
+			}
+		}
+		for name, lock := range locked {
+			source, version, ok := lockedSource(name, lock, installed)
+			if !ok {
+				guessed++
+			}
+			sv := sourceVersion{source: source, version: version}
+			binaries[sv] = appendPackageName(binaries[sv], name)
+		}
+	}
+	if guessed > 0 {
+		logrus.WithField("packages", guessed).Warn("source packages unknown, run update or export the image for accurate findings")
+	}
+
+	var findings []auditFinding
+	var failed int
+	for sv, pkgs := range binaries {
+		found, err := tracker.Audit(release, sv.source, sv.version)
+		if err != nil {
+			return err
+		}
+		for _, f := range found {
+			findings = append(findings, auditFinding{Finding: f, Packages: pkgs})
+			rank, err := security.UrgencyRank(f.Urgency)
+			if err != nil {
+				logrus.WithError(err).WithField("id", f.ID).Warn("unknown urgency")
+				continue
+			}
+			if failRank >= 0 && rank >= failRank {
+				failed++
+			}
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		if findings[i].Source != findings[j].Source {
+			return findings[i].Source < findings[j].Source
+		}
+		return findings[i].ID < findings[j].ID
+	})
+
+	if err := writeAudit(os.Stdout, format, findings); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d issues with urgency %s or higher", failed, failOn)
+	}
+	return nil
+}
+
+func loadTracker(path string) (security.Tracker, error) {
+	if path == "" {
+		return nil, fmt.Errorf("--%s is required", flagTracker)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening tracker: %w", err)
+	}
+	defer f.Close()
+	tracker, err := security.ParseTracker(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing tracker: %w", err)
+	}
+	return tracker, nil
+}
+
+// binNMU matches the suffix of binary-only rebuilds, which don't change the source version.
+var binNMU = regexp.MustCompile(`\+b[0-9]+$`)
+
+// lockedSources returns true if locked packages record their source package, which older lockfiles don't.
+func lockedSources(locked map[manifest.PackageName]manifest.LockedPackage) bool {
+	for _, pkg := range locked {
+		if pkg.Source != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// installedPackages reads the dpkg status of a rootfs, by package name.
+func installedPackages(path string) (map[string]dpkg.Package, error) {
+	fs, err := rootfs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	status, err := rootfs.ReadStatus(fs)
+	if err != nil {
+		return nil, fmt.Errorf("reading %q: %w", path, err)
+	}
+	installed := make(map[string]dpkg.Package, len(status))
+	for _, pkg := range status {
+		installed[pkg.Package] = pkg
+	}
+	return installed, nil
+}
+
+// lockedSource returns the source package and version of a locked package, as recorded by the lockfile or the
+// installed package. Otherwise binary packages are assumed to share their source's name, and false is returned.
+func lockedSource(name manifest.PackageName, lock manifest.LockedPackage, installed map[string]dpkg.Package) (string, string, bool) {
+	if lock.Source != "" {
+		version := lock.SourceVersion
+		if version == "" {
+			version = lock.Version
+		}
+		return lock.Source, version, true
+	}
+	if pkg, ok := installed[string(name)]; ok && pkg.Version == lock.Version && pkg.Source != "" {
+		return pkg.Source, pkg.SourceVersion, true
+	}
+	return string(name), binNMU.ReplaceAllString(lock.Version, ""), false
+}
+
+func appendPackageName(names []manifest.PackageName, name manifest.PackageName) []manifest.PackageName {
+	for _, n := range names {
+		if n == name {
+			return names
+		}
+	}
+	names = append(names, name)
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+func writeAudit(out io.Writer, format string, findings []auditFinding) error {
+	switch format {
+	case formatJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(findings)
+	case formatText:
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "SOURCE\tVERSION\tID\tURGENCY\tSTATUS\tFIXED\tPACKAGES")
+		for _, f := range findings {
+			pkgs := make([]string, 0, len(f.Packages))
+			for _, p := range f.Packages {
+				pkgs = append(pkgs, string(p))
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", f.Source, f.Version, f.ID, f.Urgency, f.Status, f.FixedVersion, strings.Join(pkgs, ","))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func init() {
+	auditCmd.Flags().String(flagTracker, "", "path to a Debian Security Tracker JSON dump")
+	auditCmd.Flags().String(flagFailOn, failOnNone, "exit non-zero for issues of this urgency or higher: unimportant, low, medium, high or none")
+	auditCmd.Flags().String(flagFormat, formatText, "output format: text or json")
+	auditCmd.Flags().String(flagArch, "", "architecture to audit, defaults to all")
+	rootCmd.AddCommand(auditCmd)
+}
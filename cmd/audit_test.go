@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/dpkg"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+func TestLockedSource(t *testing.T) {
+	installed := map[string]dpkg.Package{
+		"bsdutils": {Package: "bsdutils", Version: "1:2.33.1-0.1", Source: "util-linux", SourceVersion: "2.33.1-0.1"},
+	}
+	cases := []struct {
+		name            manifest.PackageName
+		lock            manifest.LockedPackage
+		source, version string
+		known           bool
+	}{
+		{
+			name:   "libgcc1",
+			lock:   manifest.LockedPackage{Version: "1:8.3.0-6", Source: "gcc-8", SourceVersion: "8.3.0-6"},
+			source: "gcc-8", version: "8.3.0-6", known: true,
+		},
+		{
+			name:   "bsdutils",
+			lock:   manifest.LockedPackage{Version: "1:2.33.1-0.1"},
+			source: "util-linux", version: "2.33.1-0.1", known: true,
+		},
+		{
+			// The installed version differs from the lockfile:
+			name:   "bsdutils",
+			lock:   manifest.LockedPackage{Version: "1:2.33.1-0.1+deb10u1"},
+			source: "bsdutils", version: "1:2.33.1-0.1+deb10u1",
+		},
+		{
+			name:   "libzstd1",
+			lock:   manifest.LockedPackage{Version: "1.3.8+dfsg-3+b1"},
+			source: "libzstd1", version: "1.3.8+dfsg-3",
+		},
+	}
+	for _, tc := range cases {
+		source, version, known := lockedSource(tc.name, tc.lock, installed)
+		assert.Equal(t, tc.source, source, tc.name)
+		assert.Equal(t, tc.version, version, tc.name)
+		assert.Equal(t, tc.known, known, tc.name)
+	}
+}
+
+func TestAuditCommand_DistroAlias(t *testing.T) {
+	cases := map[string]string{
+		// Locked codenames resolve the alias to the tracker's buster issues:
+		`{"lockfileVersion":6,"image":"debian","distro":"stable","packages":{"bash":{"version":"5.0-4","source":"bash","sourceVersion":"5.0-4","suite":"stable","codename":"buster"}}}`: "1 issues with urgency low or higher",
+		`{"lockfileVersion":5,"image":"debian","distro":"stable","packages":{"bash":{"version":"5.0-4","source":"bash","sourceVersion":"5.0-4","suite":"stable"}}}`:                     `codename of "stable" is not locked, run update`,
+	}
+	for lock, expected := range cases {
+		dir := t.TempDir()
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, manifest.Filename), []byte(`{"image":"debian","distro":"stable","packages":{"bash":"stable"}}`), 0644))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, manifest.LockFilename), []byte(lock), 0644))
+
+		rootCmd.SetArgs([]string{"audit", "--dir", dir, "--tracker", filepath.Join("..", "security", "testdata", "tracker.json"), "--fail-on", "low"})
+		t.Cleanup(func() { rootCmd.SetArgs(nil) })
+		assert.EqualError(t, rootCmd.Execute(), expected)
+	}
+}
@@ -3,11 +3,9 @@ package cmd
 import (
 	"context"
 	"fmt"
-	"time"
 
-	"github.com/docker/docker/client"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
-	"github.com/thepwagner/debendabot/build"
 	"github.com/thepwagner/debendabot/manifest"
 )
 
@@ -16,31 +14,95 @@ var buildCmd = &cobra.Command{
 	Short: "Build image",
 	Long:  `Assemble image from manifest`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkLockfileSignature(cmd); err != nil {
+			return err
+		}
 		mf, err := parseManifest(cmd)
 		if err != nil {
 			return err
 		}
+		if err := checkDrift(cmd, *mf); err != nil {
+			return err
+		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		check, err := cmd.Flags().GetBool(flagCheckRootfs)
+		if err != nil {
+			return err
+		}
+		// Checking the rootfs exports and normalizes each architecture after building:
+		builds := 1
+		if check && lockedRootfs(*mf) {
+			builds++
+		}
+		ctx, cancel := buildContext(*mf, builds)
 		defer cancel()
-		return BuildCommand(ctx, mf)
+		return BuildCommand(ctx, cmd, mf)
 	},
 }
 
-func BuildCommand(ctx context.Context, mf *manifest.Manifest) error {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+func BuildCommand(ctx context.Context, cmd *cobra.Command, mf *manifest.Manifest) error {
+	b, closeBuilder, err := newBuilder()
 	if err != nil {
-		return fmt.Errorf("opening docker client: %w", err)
+		return err
 	}
-	defer cli.Close()
-	b := build.NewBuilder(cli)
+	defer closeBuilder()
 
+	check, err := rootfsCheck(cmd, b, *mf)
+	if err != nil {
+		return err
+	}
 	if err := b.Build(ctx, *mf); err != nil {
 		return fmt.Errorf("building image: %w", err)
 	}
+	if !check {
+		return nil
+	}
+	epoch, err := sourceDateEpoch(*mf)
+	if err != nil {
+		return err
+	}
+	digests, err := rootfsDigests(ctx, b, *mf, epoch)
+	if err != nil {
+		return err
+	}
+	for arch, d := range digests {
+		if err := checkRootfs(*mf, arch, epoch, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDrift warns if the lockfile no longer satisfies dpkg.json, or fails if --frozen.
+func checkDrift(cmd *cobra.Command, mf manifest.Manifest) error {
+	frozen, err := cmd.Flags().GetBool(flagFrozen)
+	if err != nil {
+		return err
+	}
+	if frozen && mf.DpkgLockJSON == nil {
+		return fmt.Errorf("--%s requires a lockfile", flagFrozen)
+	}
+	drift, err := mf.Drift()
+	if err != nil {
+		return err
+	}
+	for _, d := range drift {
+		logrus.WithFields(logrus.Fields{
+			"kind":    d.Kind,
+			"arch":    d.Architecture,
+			"package": d.Package,
+		}).Warn(d.String())
+	}
+	if frozen && len(drift) > 0 {
+		cmd.SilenceUsage = true
+		return fmt.Errorf("lockfile is out of date with the manifest, run update")
+	}
 	return nil
 }
 
 func init() {
+	buildCmd.Flags().Bool(flagCheckRootfs, true, "check the rootfs against the digests in the lockfile, if present")
+	buildCmd.Flags().Bool(flagFrozen, false, "fail if the lockfile is missing or no longer satisfies the manifest, instead of drifting")
+	buildCmd.Flags().String(flagLockfileKey, "", "refuse to build unless the lockfile is signed by this public key")
 	rootCmd.AddCommand(buildCmd)
 }
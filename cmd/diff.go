@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [old-lockfile new-lockfile]",
+	Short: "Compare lockfiles",
+	Long:  `Compare two lockfiles, or the lockfile against a git revision`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 0 && len(args) != 2 {
+			return fmt.Errorf("accepts 0 or 2 args, received %d", len(args))
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return DiffCommand(cmd, args)
+	},
+}
+
+const (
+	flagRev = "rev"
+
+	formatMarkdown = "markdown"
+)
+
+// diffKinds orders change kinds in output.
+var diffKinds = []manifest.ChangeKind{
+	manifest.Added,
+	manifest.Removed,
+	manifest.Upgraded,
+	manifest.Downgraded,
+	manifest.Rehashed,
+}
+
+func DiffCommand(cmd *cobra.Command, args []string) error {
+	format, err := cmd.Flags().GetString(flagFormat)
+	if err != nil {
+		return err
+	}
+
+	var oldLock, newLock *manifest.DpkgLockJSON
+	if len(args) == 2 {
+		if oldLock, err = readLockfile(args[0]); err != nil {
+			return err
+		}
+		if newLock, err = readLockfile(args[1]); err != nil {
+			return err
+		}
+	} else {
+		dir, err := cmd.Flags().GetString(flagDir)
+		if err != nil {
+			return err
+		}
+		lfp, err := cmd.Flags().GetString(flagLockfilePath)
+		if err != nil {
+			return err
+		}
+		rev, err := cmd.Flags().GetString(flagRev)
+		if err != nil {
+			return err
+		}
+		if oldLock, err = readGitLockfile(dir, lfp, rev); err != nil {
+			return err
+		}
+		if newLock, err = readLockfile(filepath.Join(dir, lfp)); err != nil {
+			return err
+		}
+	}
+
+	diff, err := manifest.DiffLockfiles(oldLock, newLock)
+	if err != nil {
+		return err
+	}
+	return writeDiff(os.Stdout, format, diff)
+}
+
+func readLockfile(path string) (*manifest.DpkgLockJSON, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %q: %w", path, err)
+	}
+	defer f.Close()
+	lock, err := manifest.ParseDpkgLockJSON(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", path, err)
+	}
+	return lock, nil
+}
+
+func readGitLockfile(dir, lockfilePath, rev string) (*manifest.DpkgLockJSON, error) {
+	var stdout, stderr bytes.Buffer
+	c := exec.Command("git", "show", fmt.Sprintf("%s:./%s", rev, filepath.ToSlash(lockfilePath)))
+	c.Dir = dir
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("reading lockfile from git %s: %w: %s", rev, err, stderr.String())
+	}
+	lock, err := manifest.ParseDpkgLockJSON(&stdout)
+	if err != nil {
+		return nil, fmt.Errorf("parsing lockfile from git %s: %w", rev, err)
+	}
+	return lock, nil
+}
+
+func writeDiff(out io.Writer, format string, diff *manifest.LockDiff) error {
+	switch format {
+	case formatJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(diff)
+	case formatText:
+		return writeDiffText(out, diff)
+	case formatMarkdown:
+		return writeDiffMarkdown(out, diff)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func writeDiffText(out io.Writer, diff *manifest.LockDiff) error {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	if diff.ImageChanged() {
+		_, _ = fmt.Fprintf(tw, "image\t\t%s\t%s\n", diff.OldImage, diff.NewImage)
+	}
+	for _, c := range diff.Changes {
+		_, _ = fmt.Fprintf(tw, "%s\t%s:%s\t%s\t%s\n", c.Kind, c.Package, c.Architecture, c.OldVersion, c.NewVersion)
+	}
+	return tw.Flush()
+}
+
+func writeDiffMarkdown(out io.Writer, diff *manifest.LockDiff) error {
+	if diff.Empty() {
+		_, err := fmt.Fprintln(out, "No changes.")
+		return err
+	}
+
+	if diff.ImageChanged() {
+		_, _ = fmt.Fprintf(out, "### Base image\n\n`%s` → `%s`\n\n", diff.OldImage, diff.NewImage)
+	}
+
+	byKind := map[manifest.ChangeKind][]manifest.PackageChange{}
+	for _, c := range diff.Changes {
+		byKind[c.Kind] = append(byKind[c.Kind], c)
+	}
+	for _, kind := range diffKinds {
+		changes := byKind[kind]
+		if len(changes) == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(out, "### %s (%d)\n\n", markdownTitle(kind), len(changes))
+		_, _ = fmt.Fprintln(out, "| Package | Architecture | From | To |")
+		_, _ = fmt.Fprintln(out, "|---|---|---|---|")
+		for _, c := range changes {
+			from, to := c.OldVersion, c.NewVersion
+			if kind == manifest.Rehashed {
+				from, to = shortHash(c.OldHash), shortHash(c.NewHash)
+			}
+			_, _ = fmt.Fprintf(out, "| %s | %s | %s | %s |\n", c.Package, c.Architecture, markdownCode(from), markdownCode(to))
+		}
+		_, _ = fmt.Fprintln(out)
+	}
+	return nil
+}
+
+func markdownTitle(kind manifest.ChangeKind) string {
+	s := string(kind)
+	return string(s[0]-'a'+'A') + s[1:]
+}
+
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	return fmt.Sprintf("`%s`", s)
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+func init() {
+	diffCmd.Flags().String(flagFormat, formatText, "output format: text, markdown or json")
+	diffCmd.Flags().String(flagRev, "HEAD", "git revision to compare the lockfile against")
+	rootCmd.AddCommand(diffCmd)
+}
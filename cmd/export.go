@@ -1,10 +1,18 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,10 +20,16 @@ import (
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/jsonmessage"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"github.com/thepwagner/debendabot/build"
 	"github.com/thepwagner/debendabot/manifest"
+	"github.com/thepwagner/debendabot/oci"
+	"github.com/thepwagner/debendabot/provenance"
+	"github.com/thepwagner/debendabot/rootfs"
+	"github.com/thepwagner/debendabot/sbom"
 )
 
 var exportCmd = &cobra.Command{
@@ -23,32 +37,76 @@ var exportCmd = &cobra.Command{
 	Short: "Export image to container",
 	Long:  `Build docker container from manifest`,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkLockfileSignature(cmd); err != nil {
+			return err
+		}
 		mf, err := parseManifest(cmd)
 		if err != nil {
 			return err
 		}
+		if err := checkDrift(cmd, *mf); err != nil {
+			return err
+		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		builds, err := exportBuilds(cmd, *mf)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := buildContext(*mf, builds)
 		defer cancel()
 		return ExportCommand(ctx, cmd, *mf)
 	},
 }
 
 const (
-	flagDocker = "docker"
-	flagExt4   = "ext4"
+	flagDocker  = "docker"
+	flagExt4    = "ext4"
+	flagOCI     = "oci"
+	flagOCILoad = "oci-load"
+
+	flagReproducible       = "reproducible"
+	flagVerifyReproducible = "verify-reproducible"
+	flagCheckRootfs        = "check-rootfs"
+	flagEmbedMetadata      = "embed-metadata"
+	flagProvenance         = "provenance"
+	flagFrozen             = "frozen"
 
 	tarImageName = "image.tar"
 	extImageName = "image.ext4"
 )
 
+// exportBuilds returns how many times export builds each architecture, for buildContext. Normalizing a tarball takes
+// about as long as building it, and verifying reproducibility builds and normalizes again.
+func exportBuilds(cmd *cobra.Command, mf manifest.Manifest) (int, error) {
+	reproducible, err := cmd.Flags().GetBool(flagReproducible)
+	if err != nil {
+		return 0, err
+	}
+	verify, err := cmd.Flags().GetBool(flagVerifyReproducible)
+	if err != nil {
+		return 0, err
+	}
+	check, err := cmd.Flags().GetBool(flagCheckRootfs)
+	if err != nil {
+		return 0, err
+	}
+
+	builds := 1
+	if reproducible || verify || (check && lockedRootfs(mf)) {
+		builds++
+	}
+	if verify {
+		builds += 2
+	}
+	return builds, nil
+}
+
 func ExportCommand(ctx context.Context, cmd *cobra.Command, mf manifest.Manifest) error {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	b, closeBuilder, err := newBuilder()
 	if err != nil {
-		return fmt.Errorf("opening docker client: %w", err)
+		return err
 	}
-	defer cli.Close()
-	b := build.NewBuilder(cli)
+	defer closeBuilder()
 
 	dir, err := cmd.Flags().GetString(flagDir)
 	if err != nil {
@@ -59,26 +117,364 @@ func ExportCommand(ctx context.Context, cmd *cobra.Command, mf manifest.Manifest
 		return err
 	}
 
+	reproducible, err := cmd.Flags().GetBool(flagReproducible)
+	if err != nil {
+		return err
+	}
+	verify, err := cmd.Flags().GetBool(flagVerifyReproducible)
+	if err != nil {
+		return err
+	}
+	check, err := rootfsCheck(cmd, b, mf)
+	if err != nil {
+		return err
+	}
+	normalize := reproducible || verify || check
+	var epoch time.Time
+	if normalize {
+		if epoch, err = sourceDateEpoch(mf); err != nil {
+			return err
+		}
+	}
+
+	metadata, err := exportMetadata(cmd)
+	if err != nil {
+		return err
+	}
+
+	started := time.Now()
 	if err := b.Build(ctx, mf); err != nil {
 		return fmt.Errorf("building image: %w", err)
 	}
 
-	// Start container to export chroot to tarball:
-	if err := exportImageTarball(ctx, cli, mf, dir); err != nil {
+	digests := make(map[string]manifest.RootfsDigest, len(mf.DpkgJSON.TargetArchitectures()))
+	for _, arch := range mf.DpkgJSON.TargetArchitectures() {
+		tarball := filepath.Join(dir, imageFilename(mf, arch, tarImageName))
+		if err := b.ExportTarball(ctx, mf, arch, tarball); err != nil {
+			return err
+		}
+		if normalize {
+			if digests[arch], err = normalizeTarball(tarball, epoch); err != nil {
+				return err
+			}
+		}
+		if check {
+			if err := checkRootfs(mf, arch, epoch, digests[arch]); err != nil {
+				return err
+			}
+		}
+		// The lockfile can't pin a rootfs that contains it, so metadata is embedded after checking:
+		if metadata != nil {
+			if err := embedMetadata(mf, arch, tarball, *metadata, normalize); err != nil {
+				return err
+			}
+		}
+
+		if err := ext4Export(ctx, cmd, dir, mf, arch); err != nil {
+			return err
+		}
+	}
+
+	if err := writeProvenance(ctx, cmd, b, mf, dir, started, normalize); err != nil {
 		return err
 	}
 
-	if err := dockerExport(ctx, cmd, cli, dir, mf); err != nil {
+	if verify {
+		if err := verifyReproducible(ctx, b, mf, dir, epoch, digests); err != nil {
+			return err
+		}
+	}
+
+	var labels map[string]string
+	if metadata != nil {
+		labels = metadata.Labels()
+	}
+	if err := ociExport(ctx, cmd, dir, mf, labels); err != nil {
+		return err
+	}
+	if err := dockerExport(ctx, cmd, dir, mf, labels); err != nil {
 		return err
 	}
+	return nil
+}
+
+// exportMetadata reads the manifest and lockfile to embed in exported images, or returns nil if disabled.
+func exportMetadata(cmd *cobra.Command) (*build.Metadata, error) {
+	embed, err := cmd.Flags().GetBool(flagEmbedMetadata)
+	if err != nil || !embed {
+		return nil, err
+	}
+	m := &build.Metadata{Version: version()}
+	if m.Manifest, m.Lockfile, err = readManifestFiles(cmd); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// readManifestFiles returns the contents of the manifest and lockfile, which is nil if there isn't one.
+func readManifestFiles(cmd *cobra.Command) ([]byte, []byte, error) {
+	dir, mfp, lfp, err := manifestPaths(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	manifestFile, err := ioutil.ReadFile(filepath.Join(dir, mfp))
+	if err != nil {
+		return nil, nil, err
+	}
+	lockfile, err := ioutil.ReadFile(filepath.Join(dir, lfp))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+	return manifestFile, lockfile, nil
+}
 
-	if err := ext4Export(ctx, cmd, cli, dir, mf); err != nil {
+// writeProvenance attests to how each exported tarball was built, next to the tarball.
+func writeProvenance(ctx context.Context, cmd *cobra.Command, b *build.Builder, mf manifest.Manifest, dir string, started time.Time, reproducible bool) error {
+	enabled, err := cmd.Flags().GetBool(flagProvenance)
+	if err != nil || !enabled {
 		return err
 	}
+	if mf.DpkgLockJSON == nil {
+		logrus.Warn("manifest is not locked, skipping provenance")
+		return nil
+	}
+	_, mfp, lfp, err := manifestPaths(cmd)
+	if err != nil {
+		return err
+	}
+	manifestFile, lockfile, err := readManifestFiles(cmd)
+	if err != nil {
+		return err
+	}
+
+	finished := time.Now()
+	for _, arch := range mf.DpkgJSON.TargetArchitectures() {
+		p, err := b.Provenance(ctx, mf, arch)
+		if err != nil {
+			return fmt.Errorf("describing build: %w", err)
+		}
+		tarball := filepath.Join(dir, imageFilename(mf, arch, tarImageName))
+		statement, err := provenance.New(mf, provenance.Build{
+			Tarball:      tarball,
+			Architecture: arch,
+			Version:      version(),
+			Manifest:     manifestFile,
+			ManifestPath: mfp,
+			Lockfile:     lockfile,
+			LockfilePath: lfp,
+			Provenance:   p,
+			Started:      started,
+			Finished:     finished,
+			Reproducible: reproducible,
+		})
+		if err != nil {
+			return err
+		}
+
+		path := provenance.Filename(tarball)
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = statement.Write(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		logrus.WithFields(logrus.Fields{
+			"path":      path,
+			"materials": len(statement.Predicate.Materials),
+		}).Info("wrote provenance")
+	}
+	return nil
+}
+
+// embedMetadata adds the manifest, lockfile and an SBOM of the architecture to an exported tarball.
+func embedMetadata(mf manifest.Manifest, arch, tarball string, m build.Metadata, normalize bool) error {
+	created, err := sbomCreated(mf)
+	if err != nil {
+		return err
+	}
+	if mf.DpkgLockJSON != nil {
+		doc, err := sbom.New(mf, []string{arch}, created)
+		if err != nil {
+			return err
+		}
+		fs, err := rootfs.Open(tarball)
+		if err != nil {
+			return err
+		}
+		if err := doc.ReadRootfs(arch, fs); err != nil {
+			return fmt.Errorf("reading %q: %w", tarball, err)
+		}
+		var buf bytes.Buffer
+		if err := doc.WriteSPDX(&buf); err != nil {
+			return err
+		}
+		m.SBOM = buf.Bytes()
+	}
+
+	if err := build.EmbedMetadata(tarball, m, created); err != nil {
+		return fmt.Errorf("embedding metadata: %w", err)
+	}
+	if normalize {
+		if err := build.NormalizeTarball(tarball, created); err != nil {
+			return fmt.Errorf("normalizing tarball: %w", err)
+		}
+	}
+	logrus.WithFields(logrus.Fields{
+		"path": tarball,
+		"dir":  build.MetadataDir,
+	}).Info("embedded metadata")
+	return nil
+}
+
+// sourceDateEpoch returns the timestamp of reproducible tarballs: $SOURCE_DATE_EPOCH, else the lockfile's.
+func sourceDateEpoch(mf manifest.Manifest) (time.Time, error) {
+	if env := os.Getenv("SOURCE_DATE_EPOCH"); env != "" {
+		sec, err := strconv.ParseInt(env, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %w", env, err)
+		}
+		return time.Unix(sec, 0).UTC(), nil
+	}
+	if mf.DpkgLockJSON == nil || mf.DpkgLockJSON.SourceDateEpoch == 0 {
+		return time.Time{}, fmt.Errorf("lockfile has no sourceDateEpoch, update the lockfile or set SOURCE_DATE_EPOCH")
+	}
+	return time.Unix(mf.DpkgLockJSON.SourceDateEpoch, 0).UTC(), nil
+}
+
+func normalizeTarball(tarball string, epoch time.Time) (manifest.RootfsDigest, error) {
+	if err := build.NormalizeTarball(tarball, epoch); err != nil {
+		return manifest.RootfsDigest{}, fmt.Errorf("normalizing tarball: %w", err)
+	}
+	d, err := build.RootfsDigest(tarball)
+	if err != nil {
+		return manifest.RootfsDigest{}, err
+	}
+	logrus.WithFields(logrus.Fields{
+		"path":    tarball,
+		"tarball": d.Tarball,
+		"files":   d.Files,
+	}).Info("normalized tarball")
+	return d, nil
+}
+
+// lockedRootfs returns true if the lockfile pins the digest of the rootfs.
+func lockedRootfs(mf manifest.Manifest) bool {
+	return mf.DpkgLockJSON != nil && len(mf.DpkgLockJSON.Rootfs) > 0
+}
+
+// rootfsCheck returns true if --check-rootfs applies to builds of the manifest. Builds that can't export ownership
+// never match the lockfile, so the check is skipped, or refused if requested explicitly.
+func rootfsCheck(cmd *cobra.Command, b *build.Builder, mf manifest.Manifest) (bool, error) {
+	check, err := cmd.Flags().GetBool(flagCheckRootfs)
+	if err != nil {
+		return false, err
+	}
+	if !check || !lockedRootfs(mf) {
+		return false, nil
+	}
+	if !b.ExportsOwnership() {
+		if cmd.Flags().Changed(flagCheckRootfs) {
+			return false, fmt.Errorf("--%s can't check unprivileged host builds, which export files owned by root", flagCheckRootfs)
+		}
+		logrus.Warn("unprivileged host builds export files owned by root, skipping rootfs check")
+		return false, nil
+	}
+	return true, nil
+}
+
+// checkRootfs compares a normalized rootfs to the digest recorded in the lockfile.
+func checkRootfs(mf manifest.Manifest, arch string, epoch time.Time, actual manifest.RootfsDigest) error {
+	locked, ok := mf.DpkgLockJSON.Rootfs[arch]
+	if !ok {
+		return nil
+	}
+	if epoch.Unix() != mf.DpkgLockJSON.SourceDateEpoch {
+		logrus.WithField("arch", arch).Warn("SOURCE_DATE_EPOCH differs from the lockfile, skipping rootfs check")
+		return nil
+	}
+	if actual.Files != locked.Files {
+		return fmt.Errorf("%s rootfs files %s do not match the lockfile's %s", arch, actual.Files, locked.Files)
+	}
+	if actual.Tarball != locked.Tarball {
+		return fmt.Errorf("%s rootfs tarball %s does not match the lockfile's %s", arch, actual.Tarball, locked.Tarball)
+	}
+	logrus.WithField("arch", arch).Info("rootfs matches the lockfile")
+	return nil
+}
+
+// rootfsDigests exports each architecture's normalized rootfs from a build, returning their digests.
+func rootfsDigests(ctx context.Context, b *build.Builder, mf manifest.Manifest, epoch time.Time) (map[string]manifest.RootfsDigest, error) {
+	dir, err := ioutil.TempDir("", "debendabot-rootfs")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	ret := make(map[string]manifest.RootfsDigest, len(mf.DpkgJSON.TargetArchitectures()))
+	for _, arch := range mf.DpkgJSON.TargetArchitectures() {
+		tarball := filepath.Join(dir, imageFilename(mf, arch, tarImageName))
+		if err := b.ExportTarball(ctx, mf, arch, tarball); err != nil {
+			return nil, err
+		}
+		if ret[arch], err = normalizeTarball(tarball, epoch); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// verifyReproducible builds again from scratch, and checks the normalized tarballs match.
+func verifyReproducible(ctx context.Context, b *build.Builder, mf manifest.Manifest, dir string, epoch time.Time, digests map[string]manifest.RootfsDigest) error {
+	if err := b.Rebuild(ctx, mf); err != nil {
+		return fmt.Errorf("rebuilding image: %w", err)
+	}
+	for _, arch := range mf.DpkgJSON.TargetArchitectures() {
+		tarball := filepath.Join(dir, "verify-"+imageFilename(mf, arch, tarImageName))
+		if err := b.ExportTarball(ctx, mf, arch, tarball); err != nil {
+			return err
+		}
+		d, err := normalizeTarball(tarball, epoch)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(tarball); err != nil {
+			return err
+		}
+		if err := compareReproducible(arch, digests[arch], d); err != nil {
+			return err
+		}
+	}
+	logrus.Info("verified tarballs are reproducible")
 	return nil
 }
 
-func dockerExport(ctx context.Context, cmd *cobra.Command, cli *client.Client, dir string, mf manifest.Manifest) error {
+// compareReproducible compares the digests of two builds. Matching files in a differing tarball are a difference in
+// tar encoding, rather than in the filesystem.
+func compareReproducible(arch string, first, second manifest.RootfsDigest) error {
+	switch {
+	case first.Files != second.Files:
+		return fmt.Errorf("%s rootfs is not reproducible: built files %s, then %s (tarball %s, then %s)", arch, first.Files, second.Files, first.Tarball, second.Tarball)
+	case first.Tarball != second.Tarball:
+		return fmt.Errorf("%s rootfs files are reproducible, but the tarball encoding is not: built tarball %s, then %s (files %s)", arch, first.Tarball, second.Tarball, first.Files)
+	}
+	return nil
+}
+
+// imageFilename returns the exported filename for an architecture, e.g. "image.tar" or "image-arm64.tar".
+func imageFilename(mf manifest.Manifest, arch, name string) string {
+	if !mf.DpkgJSON.MultiArch() {
+		return name
+	}
+	ext := filepath.Ext(name)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(name, ext), arch, ext)
+}
+
+func dockerExport(ctx context.Context, cmd *cobra.Command, dir string, mf manifest.Manifest, labels map[string]string) error {
 	toDocker, err := cmd.Flags().GetBool(flagDocker)
 	if err != nil {
 		return err
@@ -86,23 +482,223 @@ func dockerExport(ctx context.Context, cmd *cobra.Command, cli *client.Client, d
 	if !toDocker {
 		return nil
 	}
+	if ociLoad, err := cmd.Flags().GetBool(flagOCILoad); err != nil {
+		return err
+	} else if ociLoad {
+		// The OCI layout was loaded instead, preserving its config:
+		return nil
+	}
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return fmt.Errorf("opening docker client: %w", err)
+	}
+	defer cli.Close()
+
+	config := imageConfig(mf, labels)
+	if !mf.DpkgJSON.MultiArch() {
+		arch := mf.DpkgJSON.TargetArchitectures()[0]
+		return dockerImport(ctx, cli, filepath.Join(dir, tarImageName), mf.DpkgJSON.Image, arch, config)
+	}
+
+	// A manifest list can only reference pushed images, so push each architecture then the list:
+	archImages := make([]string, 0, len(mf.DpkgJSON.Architectures))
+	for _, arch := range mf.DpkgJSON.Architectures {
+		archImage := archImageName(mf.DpkgJSON.Image, arch)
+		if err := dockerImport(ctx, cli, filepath.Join(dir, imageFilename(mf, arch, tarImageName)), archImage, arch, config); err != nil {
+			return err
+		}
+		if err := dockerCLI(ctx, "push", archImage); err != nil {
+			return err
+		}
+		archImages = append(archImages, archImage)
+	}
+
+	createArgs := append([]string{"manifest", "create", "--amend", mf.DpkgJSON.Image}, archImages...)
+	if err := dockerCLI(ctx, createArgs...); err != nil {
+		return err
+	}
+	if err := dockerCLI(ctx, "manifest", "push", "--purge", mf.DpkgJSON.Image); err != nil {
+		return err
+	}
+	logrus.WithField("image", mf.DpkgJSON.Image).Info("docker manifest list pushed")
+	return nil
+}
 
-	imageFile, err := os.Open(filepath.Join(dir, tarImageName))
+// ociExport writes the exported tarballs as an OCI image layout, optionally loading it into docker.
+func ociExport(ctx context.Context, cmd *cobra.Command, dir string, mf manifest.Manifest, labels map[string]string) error {
+	layout, err := cmd.Flags().GetString(flagOCI)
+	if err != nil {
+		return err
+	}
+	load, err := cmd.Flags().GetBool(flagOCILoad)
 	if err != nil {
 		return err
 	}
+	if layout == "" {
+		if load {
+			return fmt.Errorf("--%s requires --%s", flagOCILoad, flagOCI)
+		}
+		return nil
+	}
+
+	config := imageConfig(mf, labels)
+	archs := mf.DpkgJSON.TargetArchitectures()
+	images := make([]oci.Image, 0, len(archs))
+	for _, arch := range archs {
+		tag := mf.DpkgJSON.Image
+		if mf.DpkgJSON.MultiArch() {
+			tag = archImageName(tag, arch)
+		}
+		images = append(images, oci.Image{
+			Platform:    build.DockerPlatform(arch),
+			Layer:       filepath.Join(dir, imageFilename(mf, arch, tarImageName)),
+			Config:      config,
+			Tag:         tag,
+			Annotations: labels,
+		})
+	}
+	d, err := oci.WriteLayout(layout, mf.DpkgJSON.Image, images)
+	if err != nil {
+		return fmt.Errorf("writing OCI layout: %w", err)
+	}
+	logrus.WithFields(logrus.Fields{
+		"layout": layout,
+		"digest": d,
+	}).Info("wrote OCI layout")
+
+	if !load {
+		return nil
+	}
+	return ociLoad(ctx, layout)
+}
+
+func ociLoad(ctx context.Context, layout string) error {
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return fmt.Errorf("opening docker client: %w", err)
+	}
+	defer cli.Close()
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(oci.Archive(layout, pw))
+	}()
+	res, err := cli.ImageLoad(ctx, pr, true)
+	if err != nil {
+		return fmt.Errorf("loading OCI layout: %w", err)
+	}
+	defer res.Body.Close()
+	if err := jsonmessage.DisplayJSONMessagesStream(res.Body, ioutil.Discard, 0, false, nil); err != nil {
+		return fmt.Errorf("loading OCI layout: %w", err)
+	}
+	logrus.WithField("layout", layout).Info("docker load complete")
+	return nil
+}
+
+// imageConfig returns the configuration of exported images, labelled with the embedded metadata.
+func imageConfig(mf manifest.Manifest, labels map[string]string) manifest.ImageConfig {
+	config := mf.DpkgJSON.ImageConfig()
+	if len(labels) > 0 {
+		merged := make(map[string]string, len(config.Labels)+len(labels))
+		for k, v := range config.Labels {
+			merged[k] = v
+		}
+		for k, v := range labels {
+			merged[k] = v
+		}
+		config.Labels = merged
+	}
+	return config
+}
+
+// importChanges are the Dockerfile instructions that apply an image configuration to an imported tarball.
+func importChanges(config manifest.ImageConfig) ([]string, error) {
+	var changes []string
+	for _, c := range []struct {
+		instruction string
+		args        []string
+	}{{"ENTRYPOINT", config.Entrypoint}, {"CMD", config.Cmd}} {
+		if len(c.args) == 0 {
+			continue
+		}
+		// The exec form, which the OCI config also uses:
+		b, err := json.Marshal(c.args)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, fmt.Sprintf("%s %s", c.instruction, b))
+	}
+	for _, env := range config.Env {
+		k, v, _ := strings.Cut(env, "=")
+		changes = append(changes, fmt.Sprintf("ENV %s=%q", k, v))
+	}
+	if config.User != "" {
+		changes = append(changes, fmt.Sprintf("USER %s", config.User))
+	}
+	if config.WorkingDir != "" {
+		changes = append(changes, fmt.Sprintf("WORKDIR %s", config.WorkingDir))
+	}
+
+	keys := make([]string, 0, len(config.Labels))
+	for k := range config.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		changes = append(changes, fmt.Sprintf("LABEL %s=%q", k, config.Labels[k]))
+	}
+	return changes, nil
+}
+
+func dockerImport(ctx context.Context, cli *client.Client, path, image, arch string, config manifest.ImageConfig) error {
+	changes, err := importChanges(config)
+	if err != nil {
+		return err
+	}
+	imageFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer imageFile.Close()
+
 	_, err = cli.ImageImport(ctx, types.ImageImportSource{
 		Source:     imageFile,
 		SourceName: "-",
-	}, mf.DpkgJSON.Image, types.ImageImportOptions{})
+	}, image, types.ImageImportOptions{
+		Platform: build.DockerPlatform(arch),
+		Changes:  changes,
+	})
 	if err != nil {
 		return fmt.Errorf("importing image: %w", err)
 	}
-	logrus.WithField("image", mf.DpkgJSON.Image).Info("docker import complete")
+	logrus.WithFields(logrus.Fields{
+		"image": image,
+		"arch":  arch,
+	}).Info("docker import complete")
 	return nil
 }
 
-func ext4Export(ctx context.Context, cmd *cobra.Command, cli *client.Client, dir string, mf manifest.Manifest) error {
+// archImageName tags an image for a single architecture, e.g. "foo/bar:1.0" becomes "foo/bar:1.0-arm64".
+func archImageName(image, arch string) string {
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return fmt.Sprintf("%s-%s", image, arch)
+	}
+	return fmt.Sprintf("%s:%s", image, arch)
+}
+
+// dockerCLI runs the docker CLI, for operations (like manifest lists) the API client doesn't support.
+func dockerCLI(ctx context.Context, args ...string) error {
+	c := exec.CommandContext(ctx, "docker", args...)
+	c.Env = append(os.Environ(), "DOCKER_CLI_EXPERIMENTAL=enabled")
+	out, err := c.CombinedOutput()
+	logrus.WithField("args", args).Debug(string(out))
+	if err != nil {
+		return fmt.Errorf("running docker %s: %w", args[0], err)
+	}
+	return nil
+}
+
+func ext4Export(ctx context.Context, cmd *cobra.Command, dir string, mf manifest.Manifest, arch string) error {
 	toExt4, err := cmd.Flags().GetBool(flagExt4)
 	if err != nil {
 		return err
@@ -110,17 +706,27 @@ func ext4Export(ctx context.Context, cmd *cobra.Command, cli *client.Client, dir
 	if !toExt4 {
 		return nil
 	}
+	// The conversion mounts a loop device within the build image:
+	if viper.GetString(flagBackend) != backendDocker {
+		return fmt.Errorf("ext4 export requires the %q backend", backendDocker)
+	}
+	cli, err := client.NewClientWithOpts(client.FromEnv)
+	if err != nil {
+		return fmt.Errorf("opening docker client: %w", err)
+	}
+	defer cli.Close()
 
+	extImage := imageFilename(mf, arch, extImageName)
 	ctr, err := cli.ContainerCreate(ctx, &container.Config{
-		Image: build.BuildImage(mf),
+		Image: build.BuildImage(mf, arch),
 		Entrypoint: []string{
 			"sh", "-c",
 			strings.Join([]string{
-				fmt.Sprintf("truncate -s 0M /out/%s", extImageName),
-				fmt.Sprintf("truncate -s 512M /out/%s", extImageName),
-				fmt.Sprintf("mkfs.ext4 -m0 /out/%s", extImageName),
-				fmt.Sprintf("mount /out/%s /mnt", extImageName),
-				"tar -C /mnt -xvvf /out/image.tar",
+				fmt.Sprintf("truncate -s 0M /out/%s", extImage),
+				fmt.Sprintf("truncate -s 512M /out/%s", extImage),
+				fmt.Sprintf("mkfs.ext4 -m0 /out/%s", extImage),
+				fmt.Sprintf("mount /out/%s /mnt", extImage),
+				fmt.Sprintf("tar -C /mnt -xvvf /out/%s", imageFilename(mf, arch, tarImageName)),
 			}, " && ") +
 				"; umount -f /mnt",
 		},
@@ -153,48 +759,21 @@ func ext4Export(ctx context.Context, cmd *cobra.Command, cli *client.Client, dir
 		logrus.WithField("status", s.StatusCode).Debug("ext4 conversion container finished")
 	}
 
-	logrus.WithField("image", extImageName).Info("ext4 conversion complete")
-	return nil
-}
-
-func exportImageTarball(ctx context.Context, cli *client.Client, mf manifest.Manifest, dir string) error {
-	ctr, err := cli.ContainerCreate(ctx, &container.Config{
-		Image: build.BuildImage(mf),
-		Entrypoint: []string{
-			"sh", "-c", fmt.Sprintf("tar -C $ROOTFS_PATH -c . -f /out/%s", tarImageName),
-		},
-	}, &container.HostConfig{
-		AutoRemove: true,
-		Mounts: []mount.Mount{
-			{
-				Type:   mount.TypeBind,
-				Source: dir,
-				Target: "/out",
-			},
-		},
-	}, nil, "")
-	if err != nil {
-		return fmt.Errorf("creating export container: %w", err)
-	}
-	logrus.WithField("container_id", ctr.ID).Debug("created export container")
-	statusCh, errCh := cli.ContainerWait(ctx, ctr.ID, container.WaitConditionNextExit)
-	if err := cli.ContainerStart(ctx, ctr.ID, types.ContainerStartOptions{}); err != nil {
-		return fmt.Errorf("starting epxort container: %w", err)
-	}
-	logrus.WithField("container_id", ctr.ID).Debug("started export container")
-	select {
-	case err := <-errCh:
-		if err != nil {
-			logrus.WithError(err).Warn("export container error")
-		}
-	case s := <-statusCh:
-		logrus.WithField("status", s.StatusCode).Debug("export container finished")
-	}
+	logrus.WithField("image", extImage).Info("ext4 conversion complete")
 	return nil
 }
 
 func init() {
-	exportCmd.Flags().Bool(flagDocker, true, "export to docker")
+	exportCmd.Flags().Bool(flagDocker, true, "export to docker, which requires a docker daemon")
 	exportCmd.Flags().Bool(flagExt4, false, "export as ext4 filesystem")
+	exportCmd.Flags().Bool(flagReproducible, false, "normalize tarballs for reproducibility, dated by $SOURCE_DATE_EPOCH or the lockfile")
+	exportCmd.Flags().Bool(flagVerifyReproducible, false, "build again from scratch, and fail unless the normalized tarballs match")
+	exportCmd.Flags().Bool(flagCheckRootfs, true, "check tarballs against the rootfs digests in the lockfile, if present")
+	exportCmd.Flags().Bool(flagEmbedMetadata, true, fmt.Sprintf("embed the manifest, lockfile and an SBOM in %s, and label the image with their digests", build.MetadataDir))
+	exportCmd.Flags().Bool(flagProvenance, true, "write SLSA provenance of each tarball, next to it")
+	exportCmd.Flags().Bool(flagFrozen, false, "fail if the lockfile is missing or no longer satisfies the manifest, instead of drifting")
+	exportCmd.Flags().String(flagLockfileKey, "", "refuse to export unless the lockfile is signed by this public key")
+	exportCmd.Flags().String(flagOCI, "", "export as an OCI image layout to this directory")
+	exportCmd.Flags().Bool(flagOCILoad, false, "load the OCI layout into docker, instead of importing the tarball")
 	rootCmd.AddCommand(exportCmd)
 }
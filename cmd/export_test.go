@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/build"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+func TestCompareReproducible(t *testing.T) {
+	tar1, tar2 := digest.FromString("tar1").String(), digest.FromString("tar2").String()
+	files1, files2 := digest.FromString("files1").String(), digest.FromString("files2").String()
+	built := manifest.RootfsDigest{Tarball: tar1, Files: files1}
+	assert.NoError(t, compareReproducible("amd64", built, built))
+
+	err := compareReproducible("amd64", built, manifest.RootfsDigest{Tarball: tar2, Files: files1})
+	assert.EqualError(t, err, fmt.Sprintf("amd64 rootfs files are reproducible, but the tarball encoding is not: built tarball %s, then %s (files %s)", tar1, tar2, files1))
+
+	err = compareReproducible("amd64", built, manifest.RootfsDigest{Tarball: tar2, Files: files2})
+	assert.EqualError(t, err, fmt.Sprintf("amd64 rootfs is not reproducible: built files %s, then %s (tarball %s, then %s)", files1, files2, tar1, tar2))
+}
+
+func TestExportBuilds(t *testing.T) {
+	mf := manifest.Manifest{DpkgJSON: manifest.DpkgJSON{Image: "test", Distro: "buster"}}
+	cases := map[string]int{
+		"":                                1,
+		"--reproducible":                  2,
+		"--check-rootfs":                  1,
+		"--verify-reproducible":           4,
+		"--reproducible --check-rootfs=0": 2,
+	}
+	for args, expected := range cases {
+		cmd := &cobra.Command{}
+		cmd.Flags().Bool(flagReproducible, false, "")
+		cmd.Flags().Bool(flagVerifyReproducible, false, "")
+		cmd.Flags().Bool(flagCheckRootfs, false, "")
+		require.NoError(t, cmd.Flags().Parse(strings.Fields(args)))
+
+		builds, err := exportBuilds(cmd, mf)
+		require.NoError(t, err)
+		assert.Equal(t, expected, builds, args)
+	}
+
+	// Checking a rootfs locked by the lockfile exports and normalizes it:
+	mf.DpkgLockJSON = &manifest.DpkgLockJSON{Rootfs: map[string]manifest.RootfsDigest{"amd64": {Files: digest.FromString("files").String()}}}
+	cmd := &cobra.Command{}
+	cmd.Flags().Bool(flagReproducible, false, "")
+	cmd.Flags().Bool(flagVerifyReproducible, false, "")
+	cmd.Flags().Bool(flagCheckRootfs, true, "")
+	builds, err := exportBuilds(cmd, mf)
+	require.NoError(t, err)
+	assert.Equal(t, 2, builds)
+}
+
+// testBackend exports files with their ownership unless ownerless, like unprivileged host builds.
+type testBackend struct{ ownerless bool }
+
+func (testBackend) Build(context.Context, manifest.Manifest, string) error { return nil }
+func (testBackend) ExportTarball(context.Context, manifest.Manifest, string, string) error {
+	return nil
+}
+func (b testBackend) ExportsOwnership() bool { return !b.ownerless }
+
+func TestRootfsCheck(t *testing.T) {
+	mf := manifest.Manifest{
+		DpkgJSON:     manifest.DpkgJSON{Image: "test", Distro: "buster"},
+		DpkgLockJSON: &manifest.DpkgLockJSON{Rootfs: map[string]manifest.RootfsDigest{"amd64": {Files: digest.FromString("files").String()}}},
+	}
+	cases := []struct {
+		args      string
+		ownerless bool
+		check     bool
+		err       string
+	}{
+		{args: "", check: true},
+		{args: "--check-rootfs=0"},
+		// The default is skipped, but an explicit check is refused:
+		{args: "", ownerless: true},
+		{args: "--check-rootfs", ownerless: true, err: "--check-rootfs can't check unprivileged host builds, which export files owned by root"},
+	}
+	for _, tc := range cases {
+		cmd := &cobra.Command{}
+		cmd.Flags().Bool(flagCheckRootfs, true, "")
+		require.NoError(t, cmd.Flags().Parse(strings.Fields(tc.args)))
+
+		check, err := rootfsCheck(cmd, build.NewBuilder(testBackend{ownerless: tc.ownerless}), mf)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err, tc.args)
+			continue
+		}
+		require.NoError(t, err, tc.args)
+		assert.Equal(t, tc.check, check, tc.args)
+	}
+}
+
+func TestImportChanges(t *testing.T) {
+	mf := manifest.Manifest{DpkgJSON: manifest.DpkgJSON{
+		Image:  "test",
+		Distro: "buster",
+		Config: &manifest.ImageConfig{
+			Entrypoint: []string{"/usr/bin/tini", "--"},
+			Cmd:        []string{"zsh", "-l"},
+			Env:        []string{"LANG=C.UTF-8"},
+			User:       "nobody",
+			WorkingDir: "/srv",
+			Labels:     map[string]string{"org.opencontainers.image.title": "test"},
+		},
+	}}
+	changes, err := importChanges(imageConfig(mf, map[string]string{build.LabelLockfileDigest: digest.FromString("lockfile").String()}))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`ENTRYPOINT ["/usr/bin/tini","--"]`,
+		`CMD ["zsh","-l"]`,
+		`ENV PATH="` + manifest.DefaultPath + `"`,
+		`ENV LANG="C.UTF-8"`,
+		`USER nobody`,
+		`WORKDIR /srv`,
+		fmt.Sprintf("LABEL %s=%q", build.LabelLockfileDigest, digest.FromString("lockfile")),
+		`LABEL org.opencontainers.image.title="test"`,
+	}, changes)
+
+	// The default command is kept, like the docker build's:
+	mf.DpkgJSON.Config = nil
+	changes, err = importChanges(imageConfig(mf, nil))
+	require.NoError(t, err)
+	assert.Equal(t, []string{`CMD ["/usr/bin/bash"]`, `ENV PATH="` + manifest.DefaultPath + `"`}, changes)
+}
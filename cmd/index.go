@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/thepwagner/debendabot/manifest"
+	"github.com/thepwagner/debendabot/rootfs"
+)
+
+var indexCmd = &cobra.Command{
+	Use:   "index [rootfs]",
+	Short: "Index files in the image",
+	Long: `Record every file in the exported image with its owning package and digest.
+Indexes the exported image tarballs by default, or a rootfs directory or tarball.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mf, err := parseManifest(cmd)
+		if err != nil {
+			return err
+		}
+		return IndexCommand(cmd, *mf, args)
+	},
+}
+
+const (
+	flagOutput = "output"
+	flagArch   = "arch"
+
+	formatJSONLines = "jsonl"
+	formatSQLite    = "sqlite"
+)
+
+// indexRecord is a file shipped by an image, as written to JSON Lines.
+type indexRecord struct {
+	Image        string `json:"image"`
+	Architecture string `json:"architecture"`
+	rootfs.File
+}
+
+func IndexCommand(cmd *cobra.Command, mf manifest.Manifest, args []string) error {
+	format, err := cmd.Flags().GetString(flagFormat)
+	if err != nil {
+		return err
+	}
+	output, err := cmd.Flags().GetString(flagOutput)
+	if err != nil {
+		return err
+	}
+	targets, err := indexTargets(cmd, mf, args)
+	if err != nil {
+		return err
+	}
+	archs := make([]string, 0, len(targets))
+	for arch := range targets {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+
+	switch format {
+	case formatJSONLines:
+		out := io.Writer(os.Stdout)
+		if output != "-" {
+			f, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+		encoder := json.NewEncoder(out)
+		for _, arch := range archs {
+			path := targets[arch]
+			fs, err := rootfs.Open(path)
+			if err != nil {
+				return err
+			}
+			err = rootfs.Index(fs, func(f rootfs.File) error {
+				return encoder.Encode(indexRecord{Image: mf.DpkgJSON.Image, Architecture: arch, File: f})
+			})
+			if err != nil {
+				return fmt.Errorf("indexing %q: %w", path, err)
+			}
+		}
+		return nil
+
+	case formatSQLite:
+		if output == "-" {
+			return fmt.Errorf("--%s must be a database path for %s output", flagOutput, formatSQLite)
+		}
+		for _, arch := range archs {
+			path := targets[arch]
+			fs, err := rootfs.Open(path)
+			if err != nil {
+				return err
+			}
+			if err := rootfs.WriteSQLite(output, mf.DpkgJSON.Image, arch, fs); err != nil {
+				return fmt.Errorf("indexing %q: %w", path, err)
+			}
+			logrus.WithFields(logrus.Fields{
+				"path":     path,
+				"database": output,
+			}).Info("indexed rootfs")
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// indexTargets maps architectures to the rootfs to index: an argument, or the exported image tarballs.
+func indexTargets(cmd *cobra.Command, mf manifest.Manifest, args []string) (map[string]string, error) {
+	arch, err := cmd.Flags().GetString(flagArch)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 1 {
+		if arch == "" {
+			if mf.DpkgJSON.MultiArch() {
+				return nil, fmt.Errorf("--%s is required to index a rootfs of a multi-architecture image", flagArch)
+			}
+			arch = mf.DpkgJSON.TargetArchitectures()[0]
+		}
+		return map[string]string{arch: args[0]}, nil
+	}
+
+	dir, err := cmd.Flags().GetString(flagDir)
+	if err != nil {
+		return nil, err
+	}
+	targets := map[string]string{}
+	for _, a := range mf.DpkgJSON.TargetArchitectures() {
+		if arch == "" || arch == a {
+			targets[a] = filepath.Join(dir, imageFilename(mf, a, tarImageName))
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("architecture %q is not built by the manifest", arch)
+	}
+	return targets, nil
+}
+
+func init() {
+	indexCmd.Flags().String(flagFormat, formatJSONLines, "output format: jsonl, or sqlite if built with -tags sqlite")
+	indexCmd.Flags().StringP(flagOutput, "o", "-", "output path, or - for stdout")
+	indexCmd.Flags().String(flagArch, "", "architecture to index, defaults to all")
+	rootCmd.AddCommand(indexCmd)
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade the lockfile format",
+	Long: `Rewrite the lockfile in the current format.
+Other commands upgrade older lockfiles in memory, so migrating is only needed to persist the upgrade.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return MigrateCommand(cmd)
+	},
+}
+
+func MigrateCommand(cmd *cobra.Command) error {
+	dir, _, lfp, err := manifestPaths(cmd)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, lfp)
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening %q: %w", path, err)
+	}
+	defer f.Close()
+	lock, version, err := manifest.MigrateDpkgLockJSON(f)
+	if err != nil {
+		return fmt.Errorf("parsing %q: %w", path, err)
+	}
+	_ = f.Close()
+
+	log := logrus.WithFields(logrus.Fields{
+		"path":    path,
+		"version": manifest.CurrentLockfileVersion,
+	})
+	if version == manifest.CurrentLockfileVersion {
+		log.Info("lockfile is current")
+		return nil
+	}
+	if err := writeLockfile(lock, path); err != nil {
+		return err
+	}
+	log.WithField("previous_version", version).Info("migrated lockfile")
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thepwagner/debendabot/apt"
+	"github.com/thepwagner/debendabot/dpkg"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+var outdatedCmd = &cobra.Command{
+	Use:   "outdated",
+	Short: "List outdated packages",
+	Long:  `Compare the lockfile against the current repository indexes`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mf, err := parseManifest(cmd)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		return OutdatedCommand(ctx, cmd, *mf)
+	},
+}
+
+const (
+	flagFormat = "format"
+	flagAll    = "all"
+
+	formatText = "text"
+	formatJSON = "json"
+)
+
+type outdatedPackage struct {
+	Package      manifest.PackageName `json:"package"`
+	Architecture string               `json:"architecture"`
+	Current      string               `json:"current"`
+	Candidate    string               `json:"candidate,omitempty"`
+	Suite        string               `json:"suite,omitempty"`
+	Direct       bool                 `json:"direct"`
+	Outdated     bool                 `json:"outdated"`
+}
+
+func OutdatedCommand(ctx context.Context, cmd *cobra.Command, mf manifest.Manifest) error {
+	if mf.DpkgLockJSON == nil {
+		return fmt.Errorf("lockfile not found, run update")
+	}
+	format, err := cmd.Flags().GetString(flagFormat)
+	if err != nil {
+		return err
+	}
+	all, err := cmd.Flags().GetBool(flagAll)
+	if err != nil {
+		return err
+	}
+
+	client := apt.NewClient(http.DefaultClient)
+	sources := apt.Sources(mf)
+
+	var report []outdatedPackage
+	for arch, locked := range mf.DpkgLockJSON.AllPackages() {
+		names := make(map[string]bool, len(locked))
+		for name := range locked {
+			names[string(name)] = true
+		}
+		candidates, err := client.Candidates(ctx, sources, arch, names)
+		if err != nil {
+			return err
+		}
+
+		for name, lock := range locked {
+			_, direct := mf.DpkgJSON.Packages[name]
+			pkg := outdatedPackage{
+				Package:      name,
+				Architecture: arch,
+				Current:      lock.Version,
+				Direct:       direct,
+			}
+			if candidate, ok := candidates[string(name)]; ok {
+				pkg.Candidate = candidate.Version.String()
+				pkg.Suite = candidate.Suite
+				current, err := dpkg.ParseVersion(lock.Version)
+				if err != nil {
+					return fmt.Errorf("package %q: %w", name, err)
+				}
+				pkg.Outdated = candidate.Version.Compare(current) > 0
+			}
+			if all || pkg.Outdated {
+				report = append(report, pkg)
+			}
+		}
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Package != report[j].Package {
+			return report[i].Package < report[j].Package
+		}
+		return report[i].Architecture < report[j].Architecture
+	})
+
+	return writeOutdated(os.Stdout, format, report)
+}
+
+func writeOutdated(out io.Writer, format string, report []outdatedPackage) error {
+	switch format {
+	case formatJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case formatText:
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "PACKAGE\tARCH\tCURRENT\tCANDIDATE\tSUITE\tDEPENDENCY")
+		for _, pkg := range report {
+			dependency := "transitive"
+			if pkg.Direct {
+				dependency = "direct"
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", pkg.Package, pkg.Architecture, pkg.Current, pkg.Candidate, pkg.Suite, dependency)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func init() {
+	outdatedCmd.Flags().String(flagFormat, formatText, "output format: text or json")
+	outdatedCmd.Flags().Bool(flagAll, false, "include up to date packages")
+	rootCmd.AddCommand(outdatedCmd)
+}
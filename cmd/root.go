@@ -1,23 +1,46 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
 
+	"github.com/docker/docker/client"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/thepwagner/debendabot/build"
 	"github.com/thepwagner/debendabot/manifest"
 )
 
 var cfgFile string
 
+// Version is set at build time, e.g. `go build -ldflags "-X github.com/thepwagner/debendabot/cmd.Version=v1.0.0"`.
+var Version string
+
 const (
 	flagDir          = "dir"
 	flagManifestPath = "manifest"
 	flagLockfilePath = "lockfile"
 	flagLogLevel     = "loglevel"
+	flagAptProxy     = "apt-proxy"
+	flagMirror       = "mirror"
+	flagBackend      = "backend"
+	flagWorkDir      = "work-dir"
+	flagScripts      = "scripts"
+	flagBuildTimeout = "build-timeout"
+
+	backendDocker = "docker"
+	backendHost   = "host"
+
+	// aptProxyNone explicitly disables the APT proxy, even if http_proxy is set.
+	aptProxyNone = "none"
 )
 
 var rootCmd = &cobra.Command{
@@ -51,15 +74,7 @@ func Execute() {
 }
 
 func parseManifest(cmd *cobra.Command) (*manifest.Manifest, error) {
-	dir, err := cmd.Flags().GetString(flagDir)
-	if err != nil {
-		return nil, err
-	}
-	mfp, err := cmd.Flags().GetString(flagManifestPath)
-	if err != nil {
-		return nil, err
-	}
-	lfp, err := cmd.Flags().GetString(flagLockfilePath)
+	dir, mfp, lfp, err := manifestPaths(cmd)
 	if err != nil {
 		return nil, err
 	}
@@ -68,17 +83,116 @@ func parseManifest(cmd *cobra.Command) (*manifest.Manifest, error) {
 	if err != nil {
 		return nil, err
 	}
+	if problems := m.Validate(); len(problems) > 0 {
+		for _, p := range problems {
+			logrus.Error(p.Error())
+		}
+		return nil, fmt.Errorf("invalid manifest, found %d problems", len(problems))
+	}
+	if mirror := viper.GetString(flagMirror); mirror != "" {
+		if m.DpkgJSON.Snapshot {
+			logrus.WithField("mirror", mirror).Warn("ignoring mirror, the manifest builds from snapshot.debian.org")
+		} else {
+			m.DpkgJSON.Mirror = mirror
+		}
+	}
 
 	logrus.WithFields(logrus.Fields{
-		"dir":            dir,
-		"manifest":       mfp,
-		"lockfile":       lfp,
-		"packages":       m.PackageCount(),
+		"dir":             dir,
+		"manifest":        mfp,
+		"lockfile":        lfp,
+		"packages":        m.PackageCount(),
 		"locked_packages": m.LockedPackageCount(),
+		"mirror":          m.Mirror(),
 	}).Info("parsed manifests")
 	return m, err
 }
 
+// manifestPaths returns the directory, manifest and lockfile flags.
+func manifestPaths(cmd *cobra.Command) (string, string, string, error) {
+	dir, err := cmd.Flags().GetString(flagDir)
+	if err != nil {
+		return "", "", "", err
+	}
+	mfp, err := cmd.Flags().GetString(flagManifestPath)
+	if err != nil {
+		return "", "", "", err
+	}
+	lfp, err := cmd.Flags().GetString(flagLockfilePath)
+	if err != nil {
+		return "", "", "", err
+	}
+	return dir, mfp, lfp, nil
+}
+
+// version returns the version of debendabot: Version, else the module version if installed with `go get`.
+func version() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// newBuilder returns a Builder for the configured backend, and a function to release its resources.
+func newBuilder() (*build.Builder, func(), error) {
+	switch backend := viper.GetString(flagBackend); backend {
+	case backendDocker:
+		cli, err := client.NewClientWithOpts(client.FromEnv)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening docker client: %w", err)
+		}
+		return build.NewBuilder(build.NewDockerBackend(cli, aptProxy())), func() { _ = cli.Close() }, nil
+	case backendHost:
+		httpClient, err := aptHTTPClient()
+		if err != nil {
+			return nil, nil, err
+		}
+		host, err := build.NewHostBackend(httpClient, viper.GetString(flagWorkDir), viper.GetString(flagScripts))
+		if err != nil {
+			return nil, nil, err
+		}
+		return build.NewBuilder(host), func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown backend %q", backend)
+	}
+}
+
+// buildContext returns a context for a command that builds each architecture of a manifest builds times,
+// allowing --build-timeout for every build.
+func buildContext(mf manifest.Manifest, builds int) (context.Context, context.CancelFunc) {
+	timeout := viper.GetDuration(flagBuildTimeout) * time.Duration(builds*len(mf.DpkgJSON.TargetArchitectures()))
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// aptProxy returns the proxy used by APT during builds, with precedence flag > env > config file > http_proxy.
+func aptProxy() string {
+	switch proxy := viper.GetString(flagAptProxy); proxy {
+	case aptProxyNone:
+		return ""
+	case "":
+		return os.Getenv("http_proxy")
+	default:
+		return proxy
+	}
+}
+
+// aptHTTPClient returns a client for the host backend's downloads, proxied like APT in the docker backend.
+func aptHTTPClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	if proxy := aptProxy(); proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("parsing apt proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(u)
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	if cfgFile != "" {
@@ -107,9 +221,24 @@ func initConfig() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	rootCmd.Version = version()
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.debendabot.yaml)")
 	rootCmd.PersistentFlags().String(flagLogLevel, "info", "Log level")
 	rootCmd.PersistentFlags().StringP(flagDir, "d", ".", "Directory of manifest")
 	rootCmd.PersistentFlags().StringP(flagManifestPath, "m", manifest.Filename, "Manifest filename")
 	rootCmd.PersistentFlags().StringP(flagLockfilePath, "l", manifest.LockFilename, "Lockfile filename")
+	rootCmd.PersistentFlags().String(flagAptProxy, "", fmt.Sprintf("HTTP proxy for APT, or %q to disable (default is $http_proxy)", aptProxyNone))
+
+	rootCmd.PersistentFlags().String(flagMirror, "", "Debian mirror, overriding the manifest")
+	rootCmd.PersistentFlags().String(flagBackend, backendDocker, fmt.Sprintf("Build backend, %q or %q to assemble locked packages without docker", backendDocker, backendHost))
+	rootCmd.PersistentFlags().String(flagWorkDir, filepath.Join(os.TempDir(), "debendabot"), "Working directory of the host backend")
+	rootCmd.PersistentFlags().String(flagScripts, build.ScriptsChroot, fmt.Sprintf("Maintainer scripts of the host backend, %q or %q", build.ScriptsChroot, build.ScriptsNone))
+
+	_ = viper.BindPFlag(flagMirror, rootCmd.PersistentFlags().Lookup(flagMirror))
+	rootCmd.PersistentFlags().Duration(flagBuildTimeout, 5*time.Minute, "Deadline of each build, per architecture. Foreign architectures built with qemu may need longer")
+	for _, flag := range []string{flagBackend, flagWorkDir, flagScripts, flagBuildTimeout} {
+		_ = viper.BindPFlag(flag, rootCmd.PersistentFlags().Lookup(flag))
+	}
+	_ = viper.BindPFlag(flagAptProxy, rootCmd.PersistentFlags().Lookup(flagAptProxy))
+	_ = viper.BindEnv(flagAptProxy, "DEBENDABOT_APT_PROXY")
 }
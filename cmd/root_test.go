@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAptProxy(t *testing.T) {
+	cases := []struct {
+		name      string
+		flag      string
+		env       string
+		httpProxy string
+		expected  string
+	}{
+		{name: "unset"},
+		{name: "http_proxy", httpProxy: "http://http-proxy:3128", expected: "http://http-proxy:3128"},
+		{name: "env", env: "http://env:3128", httpProxy: "http://http-proxy:3128", expected: "http://env:3128"},
+		{name: "flag", flag: "http://flag:3128", env: "http://env:3128", httpProxy: "http://http-proxy:3128", expected: "http://flag:3128"},
+		{name: "env none", env: aptProxyNone, httpProxy: "http://http-proxy:3128"},
+		{name: "flag none", flag: aptProxyNone, env: "http://env:3128", httpProxy: "http://http-proxy:3128"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("DEBENDABOT_APT_PROXY", tc.env)
+			t.Setenv("http_proxy", tc.httpProxy)
+			flag := rootCmd.PersistentFlags().Lookup(flagAptProxy)
+			if tc.flag != "" {
+				require.NoError(t, flag.Value.Set(tc.flag))
+				flag.Changed = true
+				t.Cleanup(func() {
+					_ = flag.Value.Set("")
+					flag.Changed = false
+				})
+			}
+
+			assert.Equal(t, tc.expected, aptProxy())
+		})
+	}
+}
+
+func TestAptHTTPClient(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "http://deb.debian.org/debian/", nil)
+	require.NoError(t, err)
+	cases := map[string]string{
+		"http://env:3128": "http://env:3128",
+		aptProxyNone:      "",
+	}
+	for env, expected := range cases {
+		t.Setenv("DEBENDABOT_APT_PROXY", env)
+		t.Setenv("http_proxy", "http://http-proxy:3128")
+
+		client, err := aptHTTPClient()
+		require.NoError(t, err)
+		transport, ok := client.Transport.(*http.Transport)
+		require.True(t, ok)
+		if expected == "" {
+			assert.Nil(t, transport.Proxy, env)
+			continue
+		}
+		proxy, err := transport.Proxy(req)
+		require.NoError(t, err)
+		assert.Equal(t, expected, proxy.String(), env)
+	}
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/thepwagner/debendabot/manifest"
+	"github.com/thepwagner/debendabot/rootfs"
+	"github.com/thepwagner/debendabot/sbom"
+)
+
+var sbomCmd = &cobra.Command{
+	Use:   "sbom [rootfs]",
+	Short: "Write a software bill of materials",
+	Long: `Describe the locked packages as SPDX or CycloneDX JSON.
+Source packages and licenses are read from the exported image tarballs by default, or a rootfs directory or tarball.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mf, err := parseManifest(cmd)
+		if err != nil {
+			return err
+		}
+		return SBOMCommand(cmd, *mf, args)
+	},
+}
+
+const (
+	formatSPDX      = "spdx"
+	formatCycloneDX = "cyclonedx"
+)
+
+func SBOMCommand(cmd *cobra.Command, mf manifest.Manifest, args []string) error {
+	format, err := cmd.Flags().GetString(flagFormat)
+	if err != nil {
+		return err
+	}
+	var write func(*sbom.Document, io.Writer) error
+	switch format {
+	case formatSPDX:
+		write = (*sbom.Document).WriteSPDX
+	case formatCycloneDX:
+		write = (*sbom.Document).WriteCycloneDX
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+	output, err := cmd.Flags().GetString(flagOutput)
+	if err != nil {
+		return err
+	}
+	targets, err := indexTargets(cmd, mf, args)
+	if err != nil {
+		return err
+	}
+	archs := make([]string, 0, len(targets))
+	for arch := range targets {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+
+	created, err := sbomCreated(mf)
+	if err != nil {
+		return err
+	}
+	doc, err := sbom.New(mf, archs, created)
+	if err != nil {
+		return err
+	}
+	for _, arch := range archs {
+		path := targets[arch]
+		fs, err := rootfs.Open(path)
+		if errors.Is(err, os.ErrNotExist) && len(args) == 0 {
+			logrus.WithField("path", path).Warn("image not exported, omitting source packages and licenses")
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := doc.ReadRootfs(arch, fs); err != nil {
+			return fmt.Errorf("reading %q: %w", path, err)
+		}
+	}
+
+	if output == "-" {
+		return write(doc, os.Stdout)
+	}
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	if err := write(doc, f); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// sbomCreated returns the creation time of SBOMs: the SOURCE_DATE_EPOCH if there is one, else now.
+func sbomCreated(mf manifest.Manifest) (time.Time, error) {
+	if os.Getenv("SOURCE_DATE_EPOCH") != "" || (mf.DpkgLockJSON != nil && mf.DpkgLockJSON.SourceDateEpoch != 0) {
+		return sourceDateEpoch(mf)
+	}
+	return time.Now().UTC(), nil
+}
+
+func init() {
+	sbomCmd.Flags().String(flagFormat, formatSPDX, "output format: spdx or cyclonedx")
+	sbomCmd.Flags().StringP(flagOutput, "o", "-", "output path, or - for stdout")
+	sbomCmd.Flags().String(flagArch, "", "architecture to describe, defaults to all")
+	rootCmd.AddCommand(sbomCmd)
+}
@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+func TestSBOMCommand_UnknownFormat(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "sbom.json")
+	cmd := &cobra.Command{}
+	cmd.Flags().String(flagFormat, "", "")
+	cmd.Flags().String(flagOutput, "", "")
+	require.NoError(t, cmd.Flags().Parse([]string{"--format", "swid", "--output", output}))
+
+	err := SBOMCommand(cmd, manifest.Manifest{}, nil)
+	assert.EqualError(t, err, `unknown format "swid"`)
+	assert.NoFileExists(t, output)
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/thepwagner/debendabot/manifest"
+	"github.com/thepwagner/debendabot/signature"
+)
+
+var signCmd = &cobra.Command{
+	Use:   "sign [file...]",
+	Short: "Sign the lockfile and exported images",
+	Long: `Write detached signatures beside files using a local key, either:
+  an ed25519 private key in PKCS #8 PEM, e.g. from "openssl genpkey -algorithm ed25519", signing to <file>.sig
+  an OpenPGP secret keyring, e.g. from "gpg --export-secret-keys", signing to <file>.asc
+Encrypted OpenPGP keys are decrypted with $DEBENDABOT_SIGNING_PASSPHRASE.
+Signs the lockfile and exported image tarballs by default.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mf, err := parseManifest(cmd)
+		if err != nil {
+			return err
+		}
+		return SignCommand(cmd, *mf, args)
+	},
+}
+
+const (
+	flagKey               = "key"
+	flagKeyID             = "key-id"
+	flagLockfileKey       = "lockfile-key"
+	flagSigningPassphrase = "signing-passphrase"
+)
+
+func SignCommand(cmd *cobra.Command, mf manifest.Manifest, args []string) error {
+	keyPath, err := cmd.Flags().GetString(flagKey)
+	if err != nil {
+		return err
+	}
+	keyID, err := cmd.Flags().GetString(flagKeyID)
+	if err != nil {
+		return err
+	}
+	signer, err := signature.LoadSigner(keyPath, keyID, []byte(viper.GetString(flagSigningPassphrase)))
+	if err != nil {
+		return err
+	}
+
+	files, err := signedFiles(cmd, mf, args)
+	if err != nil {
+		return err
+	}
+	for _, path := range files {
+		sigPath, err := signature.SignFile(signer, path)
+		if err != nil {
+			return err
+		}
+		logrus.WithField("path", sigPath).Info("wrote signature")
+	}
+	return nil
+}
+
+// signedFiles returns the files to sign or verify: args, else the lockfile and any exported tarballs.
+func signedFiles(cmd *cobra.Command, mf manifest.Manifest, args []string) ([]string, error) {
+	if len(args) > 0 {
+		return args, nil
+	}
+	dir, _, lfp, err := manifestPaths(cmd)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{filepath.Join(dir, lfp)}
+	for _, arch := range mf.DpkgJSON.TargetArchitectures() {
+		tarball := filepath.Join(dir, imageFilename(mf, arch, tarImageName))
+		if _, err := os.Stat(tarball); errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		files = append(files, tarball)
+	}
+	return files, nil
+}
+
+func init() {
+	signCmd.Flags().String(flagKey, "", "private key: ed25519 PEM or OpenPGP keyring")
+	_ = signCmd.MarkFlagRequired(flagKey)
+	signCmd.Flags().String(flagKeyID, "", "ID or fingerprint of the OpenPGP key, if the keyring has several")
+	_ = viper.BindEnv(flagSigningPassphrase, "DEBENDABOT_SIGNING_PASSPHRASE")
+	rootCmd.AddCommand(signCmd)
+}
@@ -1,17 +1,21 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
-	"github.com/docker/docker/client"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/thepwagner/debendabot/build"
+	"github.com/thepwagner/debendabot/dpkg"
 	"github.com/thepwagner/debendabot/manifest"
 )
 
@@ -24,19 +28,27 @@ var updateCmd = &cobra.Command{
 		if err != nil {
 			return err
 		}
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		recordRootfs, err := cmd.Flags().GetBool(flagRootfsDigest)
+		if err != nil {
+			return err
+		}
+		// Recording the digest builds and exports again, after locking:
+		builds := 1
+		if recordRootfs {
+			builds++
+		}
+		ctx, cancel := buildContext(*mf, builds)
 		defer cancel()
 		return UpdateCommand(ctx, cmd, mf)
 	},
 }
 
 func UpdateCommand(ctx context.Context, cmd *cobra.Command, mf *manifest.Manifest) error {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	b, closeBuilder, err := newBuilder()
 	if err != nil {
-		return fmt.Errorf("opening docker client: %w", err)
+		return err
 	}
-	defer cli.Close()
-	b := build.NewBuilder(cli)
+	defer closeBuilder()
 
 	// Calculate and write lockfile:
 	lock, err := b.Lock(ctx, *mf)
@@ -44,6 +56,22 @@ func UpdateCommand(ctx context.Context, cmd *cobra.Command, mf *manifest.Manifes
 		return fmt.Errorf("generating lockfile: %w", err)
 	}
 
+	recordRootfs, err := cmd.Flags().GetBool(flagRootfsDigest)
+	if err != nil {
+		return err
+	}
+	if recordRootfs {
+		// Build what was locked, to pin the resulting filesystem:
+		locked := *mf
+		locked.DpkgLockJSON = lock
+		if err := b.Build(ctx, locked); err != nil {
+			return fmt.Errorf("building locked image: %w", err)
+		}
+		if lock.Rootfs, err = rootfsDigests(ctx, b, locked, time.Unix(lock.SourceDateEpoch, 0).UTC()); err != nil {
+			return err
+		}
+	}
+
 	dir, err := cmd.Flags().GetString(flagDir)
 	if err != nil {
 		return err
@@ -56,6 +84,112 @@ func UpdateCommand(ctx context.Context, cmd *cobra.Command, mf *manifest.Manifes
 		return err
 	}
 	logrus.Info("wrote lockfile")
+
+	changelogPath, err := cmd.Flags().GetString(flagChangelog)
+	if err != nil {
+		return err
+	}
+	if changelogPath == "" {
+		return nil
+	}
+	return writeChangelog(ctx, b, *mf, lock, changelogPath)
+}
+
+// writeChangelog writes a Markdown summary of lockfile changes, suitable for a pull request body.
+func writeChangelog(ctx context.Context, b *build.Builder, mf manifest.Manifest, lock *manifest.DpkgLockJSON, changelogPath string) error {
+	diff, err := manifest.DiffLockfiles(mf.DpkgLockJSON, lock)
+	if err != nil {
+		return err
+	}
+
+	changelogs := map[string]map[manifest.PackageName][]dpkg.ChangelogEntry{}
+	for _, c := range diff.Changes {
+		if c.Kind != manifest.Upgraded {
+			continue
+		}
+		if _, ok := changelogs[c.Architecture]; ok {
+			continue
+		}
+		archChangelogs, err := b.Changelogs(ctx, mf, c.Architecture)
+		if err != nil {
+			return fmt.Errorf("reading changelogs: %w", err)
+		}
+		changelogs[c.Architecture] = archChangelogs
+	}
+
+	var buf bytes.Buffer
+	if err := writeDiffMarkdown(&buf, diff); err != nil {
+		return err
+	}
+	if err := writeChangelogMarkdown(&buf, diff, changelogs); err != nil {
+		return err
+	}
+
+	if changelogPath == "-" {
+		_, err = io.Copy(os.Stdout, &buf)
+		return err
+	}
+	if err := ioutil.WriteFile(changelogPath, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("writing changelog: %w", err)
+	}
+	logrus.WithField("path", changelogPath).Info("wrote changelog")
+	return nil
+}
+
+func writeChangelogMarkdown(out io.Writer, diff *manifest.LockDiff, changelogs map[string]map[manifest.PackageName][]dpkg.ChangelogEntry) error {
+	// Binary packages built from the same source share a changelog, so group them by source:
+	type changelogKey struct{ source, from, to string }
+	var keys []changelogKey
+	packages := map[changelogKey][]manifest.PackageName{}
+	entries := map[changelogKey][]dpkg.ChangelogEntry{}
+	for _, c := range diff.Changes {
+		if c.Kind != manifest.Upgraded {
+			continue
+		}
+		changelog := changelogs[c.Architecture][c.Package]
+		if len(changelog) == 0 {
+			logrus.WithFields(logrus.Fields{"pkg": c.Package, "arch": c.Architecture}).Warn("changelog not found")
+			continue
+		}
+		from, err := dpkg.ParseVersion(c.OldVersion)
+		if err != nil {
+			return err
+		}
+		to, err := dpkg.ParseVersion(c.NewVersion)
+		if err != nil {
+			return err
+		}
+
+		key := changelogKey{source: changelog[0].Source, from: c.OldVersion, to: c.NewVersion}
+		if _, ok := packages[key]; !ok {
+			keys = append(keys, key)
+			entries[key] = dpkg.ChangesBetween(changelog, from, to)
+		}
+		packages[key] = appendPackageName(packages[key], c.Package)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	_, _ = fmt.Fprintln(out, "### Changelogs")
+	_, _ = fmt.Fprintln(out)
+	for _, key := range keys {
+		names := make([]string, 0, len(packages[key]))
+		for _, name := range packages[key] {
+			names = append(names, string(name))
+		}
+		_, _ = fmt.Fprintf(out, "<details>\n<summary>%s %s → %s (%s)</summary>\n\n", key.source, key.from, key.to, strings.Join(names, ", "))
+		_, _ = fmt.Fprintln(out, "```")
+		for i, e := range entries[key] {
+			if i > 0 {
+				_, _ = fmt.Fprintln(out)
+			}
+			_, _ = fmt.Fprintln(out, e.Text)
+		}
+		_, _ = fmt.Fprintln(out, "```")
+		_, _ = fmt.Fprintln(out, "</details>")
+		_, _ = fmt.Fprintln(out)
+	}
 	return nil
 }
 
@@ -77,6 +211,13 @@ func writeLockfile(lock *manifest.DpkgLockJSON, lockfilePath string) error {
 	return nil
 }
 
+const (
+	flagChangelog    = "changelog"
+	flagRootfsDigest = "rootfs-digest"
+)
+
 func init() {
+	updateCmd.Flags().String(flagChangelog, "", "write a Markdown summary of changes to this path, or - for stdout")
+	updateCmd.Flags().Bool(flagRootfsDigest, true, "build the locked rootfs and record its digest, which build and export check; --rootfs-digest=false skips the extra build")
 	rootCmd.AddCommand(updateCmd)
 }
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validate the manifest",
+	Long: `Check dpkg.json and the lockfile, reporting every problem with its JSON path and position.
+Editors can check dpkg.json as it is written using the JSON Schema in dpkg.schema.json.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Problems are not usage errors:
+		cmd.SilenceUsage = true
+		return ValidateCommand(cmd)
+	},
+}
+
+func ValidateCommand(cmd *cobra.Command) error {
+	format, err := cmd.Flags().GetString(flagFormat)
+	if err != nil {
+		return err
+	}
+	dir, mfp, lfp, err := manifestPaths(cmd)
+	if err != nil {
+		return err
+	}
+
+	var problems []manifest.Problem
+	mf, err := manifest.ParseManifest(dir, mfp, lfp)
+	var problem manifest.Problem
+	if errors.As(err, &problem) {
+		problem.File = filepath.Join(dir, mfp)
+		problems = append(problems, problem)
+	} else if err != nil {
+		return err
+	} else {
+		problems = mf.Validate()
+	}
+
+	if err := writeProblems(os.Stdout, format, problems); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems", len(problems))
+	}
+	return nil
+}
+
+func writeProblems(out io.Writer, format string, problems []manifest.Problem) error {
+	switch format {
+	case formatJSON:
+		if problems == nil {
+			problems = []manifest.Problem{}
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(problems)
+	case formatText:
+		for _, p := range problems {
+			_, _ = fmt.Fprintln(out, p.Error())
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func init() {
+	validateCmd.Flags().String(flagFormat, formatText, "output format: text or json")
+	rootCmd.AddCommand(validateCmd)
+}
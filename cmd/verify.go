@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/thepwagner/debendabot/build"
+	"github.com/thepwagner/debendabot/manifest"
+	"github.com/thepwagner/debendabot/rootfs"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [rootfs]",
+	Short: "Verify installed files against the dpkg database",
+	Long: `Compare every file in the exported image with the MD5 sums recorded by its package.
+Reports modified files, missing files and files owned by no package. Deviations caused by the build's cleanup, and
+files generated by maintainer scripts, are expected.
+Verifies the exported image tarballs by default, or a rootfs directory or tarball.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mf, err := parseManifest(cmd)
+		if err != nil {
+			return err
+		}
+		// Deviations are not usage errors:
+		cmd.SilenceUsage = true
+		return VerifyCommand(cmd, *mf, args)
+	},
+}
+
+// verifyDeviation is a deviation found in an image.
+type verifyDeviation struct {
+	Architecture string `json:"architecture"`
+	rootfs.Deviation
+}
+
+func VerifyCommand(cmd *cobra.Command, mf manifest.Manifest, args []string) error {
+	format, err := cmd.Flags().GetString(flagFormat)
+	if err != nil {
+		return err
+	}
+	all, err := cmd.Flags().GetBool(flagAll)
+	if err != nil {
+		return err
+	}
+	targets, err := indexTargets(cmd, mf, args)
+	if err != nil {
+		return err
+	}
+	archs := make([]string, 0, len(targets))
+	for arch := range targets {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+
+	exceptions := append(build.Exceptions(mf), rootfs.GeneratedFiles...)
+	var report []verifyDeviation
+	var unexpected int
+	for _, arch := range archs {
+		path := targets[arch]
+		fs, err := rootfs.Open(path)
+		if err != nil {
+			return err
+		}
+		deviations, err := rootfs.Verify(fs, exceptions)
+		if err != nil {
+			return fmt.Errorf("verifying %q: %w", path, err)
+		}
+		for _, d := range deviations {
+			if !d.Expected() {
+				unexpected++
+			} else if !all {
+				continue
+			}
+			report = append(report, verifyDeviation{Architecture: arch, Deviation: d})
+		}
+	}
+
+	if err := writeVerify(os.Stdout, format, report); err != nil {
+		return err
+	}
+	if unexpected > 0 {
+		return fmt.Errorf("found %d unexpected deviations", unexpected)
+	}
+	return nil
+}
+
+func writeVerify(out io.Writer, format string, report []verifyDeviation) error {
+	switch format {
+	case formatJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case formatText:
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "PATH\tARCH\tDEVIATION\tPACKAGES\tREASON")
+		for _, d := range report {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", d.Path, d.Architecture, d.Kind, strings.Join(d.Packages, ","), d.Reason)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+}
+
+func init() {
+	verifyCmd.Flags().String(flagFormat, formatText, "output format: text or json")
+	verifyCmd.Flags().Bool(flagAll, false, "include expected deviations")
+	verifyCmd.Flags().String(flagArch, "", "architecture to verify, defaults to all")
+	rootCmd.AddCommand(verifyCmd)
+}
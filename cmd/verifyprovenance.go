@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/thepwagner/debendabot/manifest"
+	"github.com/thepwagner/debendabot/provenance"
+)
+
+var verifyProvenanceCmd = &cobra.Command{
+	Use:   "verify-provenance [tarball]",
+	Short: "Verify image tarballs against their provenance",
+	Long: `Check an image tarball is the subject of the SLSA provenance written by export, and that any lockfile it embeds
+is the lockfile the provenance lists as a material.
+Verifies the exported image tarballs by default.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mf, err := parseManifest(cmd)
+		if err != nil {
+			return err
+		}
+		// Verification failures are not usage errors:
+		cmd.SilenceUsage = true
+		return VerifyProvenanceCommand(cmd, *mf, args)
+	},
+}
+
+func VerifyProvenanceCommand(cmd *cobra.Command, mf manifest.Manifest, args []string) error {
+	statementPath, err := cmd.Flags().GetString(flagProvenance)
+	if err != nil {
+		return err
+	}
+	targets, err := indexTargets(cmd, mf, args)
+	if err != nil {
+		return err
+	}
+	if statementPath != "" && len(targets) > 1 {
+		return fmt.Errorf("--%s requires a single tarball, use --%s", flagProvenance, flagArch)
+	}
+	archs := make([]string, 0, len(targets))
+	for arch := range targets {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+
+	for _, arch := range archs {
+		tarball := targets[arch]
+		path := statementPath
+		if path == "" {
+			path = provenance.Filename(tarball)
+		}
+		statement, err := readStatement(path)
+		if err != nil {
+			return fmt.Errorf("reading %q: %w", path, err)
+		}
+		if err := statement.Verify(tarball); err != nil {
+			return fmt.Errorf("verifying %q: %w", tarball, err)
+		}
+		logrus.WithFields(logrus.Fields{
+			"path":      tarball,
+			"builder":   statement.Predicate.Builder.ID,
+			"buildType": statement.Predicate.BuildType,
+			"materials": len(statement.Predicate.Materials),
+		}).Info("verified provenance")
+	}
+	return nil
+}
+
+func readStatement(path string) (*provenance.Statement, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return provenance.Read(f)
+}
+
+func init() {
+	verifyProvenanceCmd.Flags().String(flagProvenance, "", "provenance path, defaults to the tarball's path with .intoto.json")
+	verifyProvenanceCmd.Flags().String(flagArch, "", "architecture to verify, defaults to all")
+	rootCmd.AddCommand(verifyProvenanceCmd)
+}
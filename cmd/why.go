@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+var whyCmd = &cobra.Command{
+	Use:   "why <package>",
+	Short: "Explain why a package is locked",
+	Long:  `Print the dependency paths from packages in dpkg.json to a locked package`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mf, err := parseManifest(cmd)
+		if err != nil {
+			return err
+		}
+		return WhyCommand(cmd, *mf, manifest.PackageName(args[0]))
+	},
+}
+
+func WhyCommand(cmd *cobra.Command, mf manifest.Manifest, name manifest.PackageName) error {
+	if mf.DpkgLockJSON == nil {
+		return fmt.Errorf("lockfile not found, run update")
+	}
+	arch, err := cmd.Flags().GetString(flagArch)
+	if err != nil {
+		return err
+	}
+	archs := mf.DpkgJSON.TargetArchitectures()
+	if arch != "" {
+		archs = []string{arch}
+	}
+
+	for _, arch := range archs {
+		if !lockedGraph(mf.DpkgLockJSON.PackagesFor(arch)) {
+			return fmt.Errorf("lockfile does not record the %s dependency graph, run update", arch)
+		}
+		paths, err := mf.DpkgLockJSON.Why(arch, name)
+		if err != nil {
+			return err
+		}
+
+		prefix := ""
+		if len(archs) > 1 {
+			prefix = arch + ": "
+		}
+		if len(paths) == 0 {
+			_, _ = fmt.Fprintf(os.Stdout, "%s%s is not required by packages in dpkg.json (base image)\n", prefix, name)
+			continue
+		}
+		for _, path := range paths {
+			names := make([]string, 0, len(path))
+			for _, pkg := range path {
+				names = append(names, string(pkg))
+			}
+			line := strings.Join(names, " -> ")
+			if len(path) == 1 {
+				line += " (in dpkg.json)"
+			}
+			_, _ = fmt.Fprintf(os.Stdout, "%s%s\n", prefix, line)
+		}
+	}
+	return nil
+}
+
+// lockedGraph returns true if locked packages record the dependency graph, which older lockfiles don't.
+func lockedGraph(locked map[manifest.PackageName]manifest.LockedPackage) bool {
+	for _, pkg := range locked {
+		if pkg.Direct {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	whyCmd.Flags().String(flagArch, "", "architecture to explain, defaults to all")
+	rootCmd.AddCommand(whyCmd)
+}
@@ -0,0 +1,69 @@
+package dpkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// ChangelogEntry is a single upload in a Debian changelog (debian/changelog, or /usr/share/doc/*/changelog.Debian.gz).
+type ChangelogEntry struct {
+	Source        string
+	Version       Version
+	Distributions string
+	// Text is the complete entry, including the header and trailer lines.
+	Text string
+}
+
+// changelogHeader matches the first line of an entry, e.g. "glibc (2.28-10) buster; urgency=medium".
+var changelogHeader = regexp.MustCompile(`^(\S+) \(([^()\s]+)\) ([^;]*);`)
+
+// ParseChangelog parses a Debian changelog, returning entries newest first.
+func ParseChangelog(r io.Reader) ([]ChangelogEntry, error) {
+	scanner := bufio.NewScanner(r)
+	var ret []ChangelogEntry
+	var cur *ChangelogEntry
+	var text strings.Builder
+	finish := func() {
+		if cur != nil {
+			cur.Text = strings.TrimSpace(text.String())
+			ret = append(ret, *cur)
+		}
+		text.Reset()
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := changelogHeader.FindStringSubmatch(line); m != nil {
+			finish()
+			v, err := ParseVersion(m[2])
+			if err != nil {
+				return nil, fmt.Errorf("parsing changelog version: %w", err)
+			}
+			cur = &ChangelogEntry{Source: m[1], Version: v, Distributions: strings.TrimSpace(m[3])}
+		} else if cur == nil {
+			// Skip anything before the first entry, like the header of a binNMU changelog:
+			continue
+		}
+		text.WriteString(line)
+		text.WriteRune('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	finish()
+	return ret, nil
+}
+
+// ChangesBetween returns entries newer than from, up to and including to.
+func ChangesBetween(entries []ChangelogEntry, from, to Version) []ChangelogEntry {
+	var ret []ChangelogEntry
+	for _, e := range entries {
+		if e.Version.Compare(from) > 0 && e.Version.Compare(to) <= 0 {
+			ret = append(ret, e)
+		}
+	}
+	return ret
+}
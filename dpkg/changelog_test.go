@@ -0,0 +1,35 @@
+package dpkg_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/dpkg"
+)
+
+func TestParseChangelog(t *testing.T) {
+	f, err := os.Open("testdata/glibc.changelog")
+	require.NoError(t, err)
+	defer f.Close()
+
+	entries, err := dpkg.ParseChangelog(f)
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+	assert.Equal(t, "glibc", entries[0].Source)
+	assert.Equal(t, "2.36-9+deb12u13", entries[0].Version.String())
+	assert.Equal(t, "bookworm", entries[0].Distributions)
+	assert.Contains(t, entries[0].Text, "CVE-2025-8058")
+	assert.Contains(t, entries[0].Text, " -- Aurelien Jarno <aurel32@debian.org>  Mon, 25 Aug 2025 21:11:05 +0200")
+	assert.NotContains(t, entries[0].Text, "2.36-9+deb12u12")
+
+	from, err := dpkg.ParseVersion("2.36-9+deb12u10")
+	require.NoError(t, err)
+	to, err := dpkg.ParseVersion("2.36-9+deb12u12")
+	require.NoError(t, err)
+	changes := dpkg.ChangesBetween(entries, from, to)
+	require.Len(t, changes, 2)
+	assert.Equal(t, "2.36-9+deb12u12", changes[0].Version.String())
+	assert.Equal(t, "2.36-9+deb12u11", changes[1].Version.String())
+}
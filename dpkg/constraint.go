@@ -0,0 +1,142 @@
+package dpkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Constraint is a set of version requirements, all of which must be satisfied.
+type Constraint []Requirement
+
+// Requirement compares versions against a version, e.g. ">= 2.2.12".
+type Requirement struct {
+	Operator string
+	Version  Version
+}
+
+// Operators accepted in requirements. "<" and ">" are strict, unlike dpkg's deprecated meaning.
+var operators = map[string]string{
+	"=":  "=",
+	">=": ">=",
+	"<=": "<=",
+	">>": ">>",
+	"<<": "<<",
+	">":  ">>",
+	"<":  "<<",
+}
+
+// ParseConstraint parses a version constraint:
+//   - an exact version "2.2.12-1", or "= 2.2.12-1"
+//   - comma-separated comparisons ">= 2.2.12", ">= 2.2, << 3"
+//   - a tilde range "~2.2.12", allowing changes after the minor version: ">= 2.2.12, << 2.3~"
+//   - a wildcard "2.2.*": ">= 2.2, << 2.3~"
+//
+// Bounds compare full versions, so constraints on packages with an epoch must include the epoch.
+func ParseConstraint(s string) (Constraint, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "~"):
+		return rangeConstraint(s[1:], false)
+	case strings.HasSuffix(s, ".*"):
+		return rangeConstraint(strings.TrimSuffix(s, ".*"), true)
+	}
+
+	var ret Constraint
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		opLen := strings.IndexFunc(part, func(r rune) bool {
+			return !strings.ContainsRune("<=>", r)
+		})
+		if opLen < 0 {
+			return nil, fmt.Errorf("missing version in %q", part)
+		}
+
+		op := "="
+		if opLen > 0 {
+			var ok bool
+			if op, ok = operators[part[:opLen]]; !ok {
+				return nil, fmt.Errorf("invalid operator %q", part[:opLen])
+			}
+		}
+		v, err := ParseVersion(part[opLen:])
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, Requirement{Operator: op, Version: v})
+	}
+	return ret, nil
+}
+
+// rangeConstraint builds the range from a version to the next release.
+// For wildcards, the last component is incremented: 2.2.* is < 2.3~. Tildes keep the first two components: ~2.2.12 is < 2.3~.
+func rangeConstraint(s string, wildcard bool) (Constraint, error) {
+	lower, err := ParseVersion(s)
+	if err != nil {
+		return nil, err
+	}
+	if lower.Revision != "" {
+		return nil, fmt.Errorf("range %q can't include a revision", s)
+	}
+
+	components := strings.Split(lower.Upstream, ".")
+	bump := len(components) - 1
+	if !wildcard && bump > 1 {
+		bump = 1
+	}
+	n, err := strconv.Atoi(components[bump])
+	if err != nil {
+		return nil, fmt.Errorf("range %q must end with a numeric component", s)
+	}
+	components = append(components[:bump], strconv.Itoa(n+1))
+
+	upper := Version{Epoch: lower.Epoch, Upstream: strings.Join(components, ".") + "~"}
+	return Constraint{
+		{Operator: ">=", Version: lower},
+		{Operator: "<<", Version: upper},
+	}, nil
+}
+
+// Exact returns the version required by an exact constraint.
+func (c Constraint) Exact() (Version, bool) {
+	if len(c) != 1 || c[0].Operator != "=" {
+		return Version{}, false
+	}
+	return c[0].Version, true
+}
+
+// Matches returns true if the version satisfies every requirement.
+func (c Constraint) Matches(v Version) bool {
+	for _, r := range c {
+		if !r.Matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches returns true if the version satisfies the requirement.
+func (r Requirement) Matches(v Version) bool {
+	cmp := v.Compare(r.Version)
+	switch r.Operator {
+	case "=":
+		return cmp == 0
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">>":
+		return cmp > 0
+	case "<<":
+		return cmp < 0
+	}
+	return false
+}
+
+func (c Constraint) String() string {
+	parts := make([]string, 0, len(c))
+	for _, r := range c {
+		parts = append(parts, fmt.Sprintf("%s %s", r.Operator, r.Version))
+	}
+	return strings.Join(parts, ", ")
+}
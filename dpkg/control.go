@@ -0,0 +1,198 @@
+package dpkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Paragraph is a stanza of a Debian control file, e.g. one package in /var/lib/dpkg/status.
+// Multiline field values are joined with newlines, with the leading space of continuation lines removed.
+type Paragraph map[string]string
+
+// ParseControl parses an RFC822-style control file into paragraphs.
+func ParseControl(r io.Reader) ([]Paragraph, error) {
+	var ret []Paragraph
+	err := ReadControl(r, func(p Paragraph) error {
+		ret = append(ret, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ReadControl streams paragraphs of a control file to a callback, for files too large to hold, like Packages indexes.
+func ReadControl(r io.Reader, fn func(Paragraph) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var cur Paragraph
+	var field string
+	var lineNo int
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			// Blank lines separate paragraphs:
+			if cur != nil {
+				if err := fn(cur); err != nil {
+					return err
+				}
+				cur, field = nil, ""
+			}
+		case strings.HasPrefix(line, "#"):
+			continue
+		case line[0] == ' ' || line[0] == '\t':
+			if field == "" {
+				return fmt.Errorf("line %d: continuation line without field", lineNo)
+			}
+			cur[field] += "\n" + line[1:]
+		default:
+			i := strings.Index(line, ":")
+			if i <= 0 {
+				return fmt.Errorf("line %d: expected field, got %q", lineNo, line)
+			}
+			if cur == nil {
+				cur = Paragraph{}
+			}
+			field = line[:i]
+			cur[field] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if cur != nil {
+		return fn(cur)
+	}
+	return nil
+}
+
+// WriteParagraph writes a paragraph of a control file, followed by a blank line.
+// Fields listed in order are written first, then any others alphabetically.
+func WriteParagraph(w io.Writer, p Paragraph, order []string) error {
+	fields := make([]string, 0, len(p))
+	written := make(map[string]bool, len(order))
+	for _, field := range order {
+		if _, ok := p[field]; ok {
+			fields = append(fields, field)
+			written[field] = true
+		}
+	}
+	var rest []string
+	for field := range p {
+		if !written[field] {
+			rest = append(rest, field)
+		}
+	}
+	sort.Strings(rest)
+
+	for _, field := range append(fields, rest...) {
+		lines := strings.Split(p[field], "\n")
+		first := field + ":"
+		if lines[0] != "" {
+			first += " " + lines[0]
+		}
+		if _, err := fmt.Fprintln(w, first); err != nil {
+			return err
+		}
+		for _, line := range lines[1:] {
+			if _, err := fmt.Fprintln(w, " "+line); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// Dependency is a list of alternative relations, any of which satisfies it.
+type Dependency []Relation
+
+// Relation is a reference to another package, e.g. "libc6 (>= 2.14)".
+type Relation struct {
+	Name         string
+	Architecture string
+	Operator     string
+	Version      string
+}
+
+func (r Relation) String() string {
+	name := r.Name
+	if r.Architecture != "" {
+		name = fmt.Sprintf("%s:%s", r.Name, r.Architecture)
+	}
+	if r.Operator == "" {
+		return name
+	}
+	return fmt.Sprintf("%s (%s %s)", name, r.Operator, r.Version)
+}
+
+// ParseDependencies parses a relationship field like Depends or Pre-Depends.
+func ParseDependencies(s string) ([]Dependency, error) {
+	var ret []Dependency
+	for _, dep := range strings.Split(s, ",") {
+		dep = strings.TrimSpace(dep)
+		if dep == "" {
+			continue
+		}
+
+		var alternatives Dependency
+		for _, alt := range strings.Split(dep, "|") {
+			rel, err := parseRelation(strings.TrimSpace(alt))
+			if err != nil {
+				return nil, err
+			}
+			alternatives = append(alternatives, rel)
+		}
+		ret = append(ret, alternatives)
+	}
+	return ret, nil
+}
+
+func parseRelation(s string) (Relation, error) {
+	// Drop architecture restrictions and build profiles, which don't apply to installed packages:
+	var inVersion bool
+	for i, r := range s {
+		if r == '(' || r == ')' {
+			inVersion = r == '('
+		} else if !inVersion && (r == '[' || r == '<') {
+			s = strings.TrimSpace(s[:i])
+			break
+		}
+	}
+
+	var rel Relation
+	name := s
+	if i := strings.Index(s, "("); i >= 0 {
+		if !strings.HasSuffix(s, ")") {
+			return Relation{}, fmt.Errorf("unterminated version in %q", s)
+		}
+		name = strings.TrimSpace(s[:i])
+		constraint := strings.TrimSpace(s[i+1 : len(s)-1])
+		opLen := strings.IndexFunc(constraint, func(r rune) bool {
+			return !strings.ContainsRune("<=>", r)
+		})
+		if opLen <= 0 {
+			return Relation{}, fmt.Errorf("invalid version constraint in %q", s)
+		}
+		rel.Operator = constraint[:opLen]
+		rel.Version = strings.TrimSpace(constraint[opLen:])
+	}
+
+	if i := strings.Index(name, ":"); i >= 0 {
+		rel.Architecture = name[i+1:]
+		name = name[:i]
+	}
+	if name == "" {
+		return Relation{}, fmt.Errorf("missing package name in %q", s)
+	}
+	rel.Name = name
+	return rel, nil
+}
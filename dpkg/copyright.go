@@ -0,0 +1,89 @@
+package dpkg
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Copyright is the copyright and licensing of a package, from /usr/share/doc/<pkg>/copyright.
+type Copyright struct {
+	// Copyright holds the distinct copyright statements, e.g. "1991-2019 Free Software Foundation, Inc.".
+	Copyright []string
+	// Licenses are the distinct Debian short names of the licenses, e.g. "GPL-2+", sorted.
+	// A license expression like "GPL-2+ or Artistic" is a single entry.
+	Licenses []string
+}
+
+// copyrightFormat prefixes machine-readable copyright files, https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+const copyrightFormat = "Format:"
+
+var (
+	copyrightLine  = regexp.MustCompile(`(?i)^\s*copyright\s*(?:\(c\)|©)?\s*:?\s*(.*\d{4}.*)$`)
+	commonLicenses = regexp.MustCompile(`/usr/share/common-licenses/([A-Za-z0-9.+-]*[A-Za-z0-9+])`)
+)
+
+// ParseCopyright parses a copyright file. Machine-readable files are parsed exactly, licenses of other files are
+// guessed from references to /usr/share/common-licenses.
+func ParseCopyright(r io.Reader) (*Copyright, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte(copyrightFormat)) {
+		if c, err := parseMachineCopyright(b); err == nil {
+			return c, nil
+		}
+	}
+
+	var copyrights, licenses []string
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := copyrightLine.FindStringSubmatch(line); m != nil {
+			copyrights = appendDistinct(copyrights, strings.TrimSpace(m[1]))
+		}
+		for _, m := range commonLicenses.FindAllStringSubmatch(line, -1) {
+			licenses = appendDistinct(licenses, m[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(licenses)
+	return &Copyright{Copyright: copyrights, Licenses: licenses}, nil
+}
+
+func parseMachineCopyright(b []byte) (*Copyright, error) {
+	paragraphs, err := ParseControl(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	c := &Copyright{}
+	for _, p := range paragraphs {
+		for _, line := range strings.Split(p["Copyright"], "\n") {
+			if line = strings.TrimSpace(line); line != "" && line != "." {
+				c.Copyright = appendDistinct(c.Copyright, line)
+			}
+		}
+		// The first line of License is the license name, later lines are its text:
+		if license := strings.TrimSpace(strings.SplitN(p["License"], "\n", 2)[0]); license != "" {
+			c.Licenses = appendDistinct(c.Licenses, license)
+		}
+	}
+	sort.Strings(c.Licenses)
+	return c, nil
+}
+
+func appendDistinct(s []string, v string) []string {
+	for _, existing := range s {
+		if existing == v {
+			return s
+		}
+	}
+	return append(s, v)
+}
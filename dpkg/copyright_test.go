@@ -0,0 +1,33 @@
+package dpkg_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/dpkg"
+)
+
+func TestParseCopyright(t *testing.T) {
+	f, err := os.Open("testdata/tar.copyright")
+	require.NoError(t, err)
+	defer f.Close()
+
+	c, err := dpkg.ParseCopyright(f)
+	require.NoError(t, err)
+	assert.Contains(t, c.Licenses, "GPL-3+")
+	assert.Contains(t, c.Licenses, "GPL-3+ with Bison exception")
+	assert.Contains(t, c.Copyright, "Copyright (C) 1984, 1989-1990, 2000-2015 Free Software Foundation, Inc.")
+}
+
+func TestParseCopyright_Unstructured(t *testing.T) {
+	f, err := os.Open("testdata/base-files.copyright")
+	require.NoError(t, err)
+	defer f.Close()
+
+	c, err := dpkg.ParseCopyright(f)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GPL"}, c.Licenses)
+	assert.Equal(t, []string{"1995-2011 Software in the Public Interest."}, c.Copyright)
+}
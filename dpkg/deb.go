@@ -0,0 +1,140 @@
+package dpkg
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+// Members of a .deb archive, after removing the compression extension.
+const (
+	DebControl = "control.tar"
+	DebData    = "data.tar"
+)
+
+const (
+	arMagic      = "!<arch>\n"
+	arHeaderSize = 60
+)
+
+// ReadDeb streams the tar members of a .deb archive to a callback, decompressing them.
+// The callback receives DebControl or DebData, and must consume the tar before returning.
+func ReadDeb(r io.Reader, fn func(member string, tr *tar.Reader) error) error {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return fmt.Errorf("reading ar magic: %w", err)
+	}
+	if string(magic) != arMagic {
+		return fmt.Errorf("not a .deb archive")
+	}
+
+	header := make([]byte, arHeaderSize)
+	for {
+		if _, err := io.ReadFull(br, header); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading ar header: %w", err)
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(string(header[0:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid size for ar member %q: %w", name, err)
+		}
+
+		member := io.LimitReader(br, size)
+		if strings.HasPrefix(name, DebControl) || strings.HasPrefix(name, DebData) {
+			if err := readDebMember(name, member, fn); err != nil {
+				return err
+			}
+		}
+
+		// Discard anything the callback didn't read, then the padding to an even offset:
+		if _, err := io.Copy(ioutil.Discard, member); err != nil {
+			return fmt.Errorf("skipping ar member %q: %w", name, err)
+		}
+		if size%2 == 1 {
+			if _, err := br.Discard(1); err != nil {
+				return fmt.Errorf("skipping ar padding: %w", err)
+			}
+		}
+	}
+}
+
+func readDebMember(name string, r io.Reader, fn func(member string, tr *tar.Reader) error) error {
+	var decompressed io.Reader
+	var member string
+	switch {
+	case strings.HasSuffix(name, ".tar"):
+		decompressed, member = r, name
+	case strings.HasSuffix(name, ".tar.gz"):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("decompressing %q: %w", name, err)
+		}
+		defer gz.Close()
+		decompressed, member = gz, strings.TrimSuffix(name, ".gz")
+	case strings.HasSuffix(name, ".tar.xz"):
+		xzr, err := xz.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("decompressing %q: %w", name, err)
+		}
+		decompressed, member = xzr, strings.TrimSuffix(name, ".xz")
+	default:
+		return fmt.Errorf("unsupported compression for %q", name)
+	}
+
+	if err := fn(member, tar.NewReader(decompressed)); err != nil {
+		return fmt.Errorf("reading %q: %w", name, err)
+	}
+	return nil
+}
+
+// ReadDebControl returns the control file of a .deb archive.
+func ReadDebControl(r io.Reader) (Paragraph, error) {
+	var control Paragraph
+	err := ReadDeb(r, func(member string, tr *tar.Reader) error {
+		if member != DebControl {
+			return nil
+		}
+		for {
+			h, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if strings.TrimPrefix(h.Name, "./") != "control" {
+				continue
+			}
+			var buf bytes.Buffer
+			if _, err := io.Copy(&buf, tr); err != nil {
+				return err
+			}
+			paragraphs, err := ParseControl(&buf)
+			if err != nil {
+				return err
+			}
+			if len(paragraphs) != 1 {
+				return fmt.Errorf("expected one control paragraph, got %d", len(paragraphs))
+			}
+			control = paragraphs[0]
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if control == nil {
+		return nil, fmt.Errorf("control file not found")
+	}
+	return control, nil
+}
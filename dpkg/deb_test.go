@@ -0,0 +1,53 @@
+package dpkg_test
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/dpkg"
+)
+
+const testDeb = "testdata/hello_1.0-1_all.deb"
+
+func TestReadDeb(t *testing.T) {
+	f, err := os.Open(testDeb)
+	require.NoError(t, err)
+	defer f.Close()
+
+	files := map[string][]string{}
+	err = dpkg.ReadDeb(f, func(member string, tr *tar.Reader) error {
+		for {
+			h, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			files[member] = append(files[member], h.Name)
+		}
+	})
+	require.NoError(t, err)
+
+	assert.Contains(t, files[dpkg.DebControl], "./control")
+	assert.Contains(t, files[dpkg.DebControl], "./postinst")
+	assert.Contains(t, files[dpkg.DebData], "./usr/share/doc/hello/README")
+	assert.Contains(t, files[dpkg.DebData], "./etc/hello.conf")
+}
+
+func TestReadDebControl(t *testing.T) {
+	f, err := os.Open(testDeb)
+	require.NoError(t, err)
+	defer f.Close()
+
+	control, err := dpkg.ReadDebControl(f)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", control["Package"])
+	assert.Equal(t, "1.0-1", control["Version"])
+	assert.Equal(t, "libc6 (>= 2.28)", control["Depends"])
+	assert.Equal(t, "test package for debendabot\nFixture for .deb parsing tests.", control["Description"])
+}
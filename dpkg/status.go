@@ -0,0 +1,161 @@
+package dpkg
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// StatusPath is the dpkg database of installed packages.
+const StatusPath = "/var/lib/dpkg/status"
+
+// StatusFields is the order dpkg writes fields to the status database.
+var StatusFields = []string{
+	"Package", "Essential", "Status", "Priority", "Section", "Installed-Size", "Origin", "Maintainer", "Bugs",
+	"Architecture", "Multi-Arch", "Source", "Version", "Config-Version", "Replaces", "Provides", "Depends",
+	"Pre-Depends", "Recommends", "Suggests", "Breaks", "Conflicts", "Enhances", "Conffiles", "Description",
+	"Homepage",
+}
+
+// Package is an entry in the dpkg status database.
+type Package struct {
+	Package      string
+	Version      string
+	Architecture string
+	// Source package name and version, which default to the binary package's.
+	Source        string
+	SourceVersion string
+	Status        Status
+	MultiArch     string
+	Depends       []Dependency
+	PreDepends    []Dependency
+	// Provides are virtual packages, which dependencies can be satisfied by.
+	Provides []Relation
+}
+
+// Status is the desired action, error flag and state of a package, e.g. "install ok installed".
+type Status struct {
+	Want  string
+	Flag  string
+	State string
+}
+
+// Installed returns true if the package is fully installed.
+func (s Status) Installed() bool {
+	return s.State == "installed"
+}
+
+func (s Status) String() string {
+	return fmt.Sprintf("%s %s %s", s.Want, s.Flag, s.State)
+}
+
+// ParseStatus parses the dpkg status database.
+func ParseStatus(r io.Reader) ([]Package, error) {
+	paragraphs, err := ParseControl(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]Package, 0, len(paragraphs))
+	for _, p := range paragraphs {
+		pkg, err := newPackage(p)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, pkg)
+	}
+	return ret, nil
+}
+
+func newPackage(p Paragraph) (Package, error) {
+	pkg := Package{
+		Package:      p["Package"],
+		Version:      p["Version"],
+		Architecture: p["Architecture"],
+		MultiArch:    p["Multi-Arch"],
+	}
+	if pkg.Package == "" {
+		return Package{}, fmt.Errorf("paragraph without package: %v", p)
+	}
+
+	pkg.Source, pkg.SourceVersion = pkg.Package, pkg.Version
+	if source := p["Source"]; source != "" {
+		// "Source: util-linux (2.33.1-0.1)", version is omitted if it matches the binary:
+		if i := strings.Index(source, " ("); i >= 0 && strings.HasSuffix(source, ")") {
+			pkg.SourceVersion = source[i+2 : len(source)-1]
+			source = source[:i]
+		}
+		pkg.Source = source
+	}
+
+	if status := p["Status"]; status != "" {
+		parts := strings.Fields(status)
+		if len(parts) != 3 {
+			return Package{}, fmt.Errorf("package %q: invalid status %q", pkg.Package, status)
+		}
+		pkg.Status = Status{Want: parts[0], Flag: parts[1], State: parts[2]}
+	}
+
+	var err error
+	if pkg.Depends, err = ParseDependencies(p["Depends"]); err != nil {
+		return Package{}, fmt.Errorf("package %q: parsing Depends: %w", pkg.Package, err)
+	}
+	if pkg.PreDepends, err = ParseDependencies(p["Pre-Depends"]); err != nil {
+		return Package{}, fmt.Errorf("package %q: parsing Pre-Depends: %w", pkg.Package, err)
+	}
+	provides, err := ParseDependencies(p["Provides"])
+	if err != nil {
+		return Package{}, fmt.Errorf("package %q: parsing Provides: %w", pkg.Package, err)
+	}
+	for _, dep := range provides {
+		pkg.Provides = append(pkg.Provides, dep...)
+	}
+	return pkg, nil
+}
+
+// Dependents maps installed packages to the installed packages that Depend or Pre-Depend on them, sorted by name.
+// Dependencies on a virtual package are attributed to its providers, and on alternatives to each installed alternative.
+func Dependents(pkgs []Package) map[string][]string {
+	providers := map[string][]string{}
+	for _, pkg := range pkgs {
+		if !pkg.Status.Installed() {
+			continue
+		}
+		providers[pkg.Package] = append(providers[pkg.Package], pkg.Package)
+		for _, virtual := range pkg.Provides {
+			providers[virtual.Name] = append(providers[virtual.Name], pkg.Package)
+		}
+	}
+
+	dependents := map[string]map[string]bool{}
+	for _, pkg := range pkgs {
+		if !pkg.Status.Installed() {
+			continue
+		}
+		for _, deps := range [][]Dependency{pkg.PreDepends, pkg.Depends} {
+			for _, dep := range deps {
+				for _, alt := range dep {
+					for _, provider := range providers[alt.Name] {
+						if provider == pkg.Package {
+							continue
+						}
+						if dependents[provider] == nil {
+							dependents[provider] = map[string]bool{}
+						}
+						dependents[provider][pkg.Package] = true
+					}
+				}
+			}
+		}
+	}
+
+	ret := make(map[string][]string, len(dependents))
+	for name, set := range dependents {
+		for dependent := range set {
+			ret[name] = append(ret[name], dependent)
+		}
+		sort.Strings(ret[name])
+	}
+	return ret
+}
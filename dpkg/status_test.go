@@ -0,0 +1,162 @@
+package dpkg_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/dpkg"
+)
+
+func TestParseStatus(t *testing.T) {
+	cases := []struct {
+		file     string
+		count    int
+		expected map[string]dpkg.Package
+	}{
+		{
+			file:  "bookworm.status",
+			count: 10,
+			expected: map[string]dpkg.Package{
+				"bsdutils": {
+					Package:       "bsdutils",
+					Version:       "1:2.38.1-5+deb12u3",
+					Architecture:  "amd64",
+					Source:        "util-linux",
+					SourceVersion: "2.38.1-5+deb12u3",
+					Status:        dpkg.Status{Want: "install", Flag: "ok", State: "installed"},
+					MultiArch:     "foreign",
+					PreDepends: []dpkg.Dependency{
+						{{Name: "libc6", Operator: ">=", Version: "2.34"}},
+						{{Name: "libsystemd0"}},
+					},
+				},
+				"libc6": {
+					Package:       "libc6",
+					Version:       "2.36-9+deb12u13",
+					Architecture:  "amd64",
+					Source:        "glibc",
+					SourceVersion: "2.36-9+deb12u13",
+					Status:        dpkg.Status{Want: "install", Flag: "ok", State: "installed"},
+					MultiArch:     "same",
+					Depends:       []dpkg.Dependency{{{Name: "libgcc-s1"}}},
+				},
+				"tzdata": {
+					Package:       "tzdata",
+					Version:       "2025b-0+deb12u2",
+					Architecture:  "all",
+					Source:        "tzdata",
+					SourceVersion: "2025b-0+deb12u2",
+					Status:        dpkg.Status{Want: "install", Flag: "ok", State: "installed"},
+					MultiArch:     "foreign",
+					Depends: []dpkg.Dependency{{
+						{Name: "debconf", Operator: ">=", Version: "0.5"},
+						{Name: "debconf-2.0"},
+					}},
+					Provides: []dpkg.Relation{{Name: "tzdata-bookworm"}},
+				},
+			},
+		},
+		{
+			file:  "config-files.status",
+			count: 2,
+			expected: map[string]dpkg.Package{
+				"vim-tiny": {
+					Package:       "vim-tiny",
+					Version:       "2:8.1.0875-5",
+					Architecture:  "amd64",
+					Source:        "vim",
+					SourceVersion: "2:8.1.0875-5",
+					Status:        dpkg.Status{Want: "deinstall", Flag: "ok", State: "config-files"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.file, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", tc.file))
+			require.NoError(t, err)
+			defer f.Close()
+
+			pkgs, err := dpkg.ParseStatus(f)
+			require.NoError(t, err)
+			assert.Len(t, pkgs, tc.count)
+
+			byName := make(map[string]dpkg.Package, len(pkgs))
+			for _, pkg := range pkgs {
+				byName[pkg.Package] = pkg
+			}
+			for name, expected := range tc.expected {
+				assert.Equal(t, expected, byName[name], name)
+			}
+		})
+	}
+}
+
+func TestDependents(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "bookworm.status"))
+	require.NoError(t, err)
+	defer f.Close()
+	installed, err := dpkg.ParseStatus(f)
+	require.NoError(t, err)
+	installed = append(installed, dpkg.Package{
+		Package:  "mawk",
+		Status:   dpkg.Status{Want: "install", Flag: "ok", State: "installed"},
+		Provides: []dpkg.Relation{{Name: "awk"}},
+	})
+
+	dependents := dpkg.Dependents(installed)
+	assert.Equal(t, []string{"bash", "bsdutils", "coreutils", "libgcrypt20", "libpam-modules", "zlib1g"}, dependents["libc6"])
+	assert.Equal(t, []string{"bash"}, dependents["base-files"])
+	assert.Equal(t, []string{"base-files"}, dependents["mawk"])
+	assert.NotContains(t, dependents, "libgcrypt20")
+}
+
+func TestParseDependencies(t *testing.T) {
+	cases := map[string][]dpkg.Dependency{
+		"":              nil,
+		"perl:any":      {{{Name: "perl", Architecture: "any"}}},
+		"a (<< 2), b":   {{{Name: "a", Operator: "<<", Version: "2"}}, {{Name: "b"}}},
+		"a [amd64] | b": {{{Name: "a"}, {Name: "b"}}},
+	}
+	for input, expected := range cases {
+		t.Run(input, func(t *testing.T) {
+			deps, err := dpkg.ParseDependencies(input)
+			require.NoError(t, err)
+			assert.Equal(t, expected, deps)
+		})
+	}
+}
+
+func TestWriteParagraph(t *testing.T) {
+	p := dpkg.Paragraph{
+		"Package":     "hello",
+		"Status":      "install ok installed",
+		"Version":     "1.0-1",
+		"Description": "test package for debendabot\nFixture for .deb parsing tests.",
+		"Conffiles":   "\n/etc/hello.conf 0123456789abcdef",
+		"X-Custom":    "yes",
+	}
+
+	var buf bytes.Buffer
+	err := dpkg.WriteParagraph(&buf, p, dpkg.StatusFields)
+	require.NoError(t, err)
+	assert.Equal(t, `Package: hello
+Status: install ok installed
+Version: 1.0-1
+Conffiles:
+ /etc/hello.conf 0123456789abcdef
+Description: test package for debendabot
+ Fixture for .deb parsing tests.
+X-Custom: yes
+
+`, buf.String())
+
+	parsed, err := dpkg.ParseControl(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, []dpkg.Paragraph{p}, parsed)
+}
@@ -0,0 +1,163 @@
+package dpkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a Debian package version: [epoch:]upstream_version[-debian_revision].
+type Version struct {
+	Epoch    int
+	Upstream string
+	Revision string
+}
+
+// ParseVersion parses a Debian package version.
+func ParseVersion(s string) (Version, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Version{}, fmt.Errorf("empty version")
+	}
+
+	var v Version
+	if i := strings.Index(s, ":"); i >= 0 {
+		epoch, err := strconv.Atoi(s[:i])
+		if err != nil || epoch < 0 {
+			return Version{}, fmt.Errorf("invalid epoch in version %q", s)
+		}
+		v.Epoch = epoch
+		s = s[i+1:]
+	}
+	if i := strings.LastIndex(s, "-"); i >= 0 {
+		v.Revision = s[i+1:]
+		s = s[:i]
+		if v.Revision == "" {
+			return Version{}, fmt.Errorf("empty revision in version %q", s)
+		}
+	}
+	v.Upstream = s
+
+	if v.Upstream == "" {
+		return Version{}, fmt.Errorf("empty upstream version")
+	}
+	if v.Upstream[0] < '0' || v.Upstream[0] > '9' {
+		return Version{}, fmt.Errorf("version %q must start with a digit", v.Upstream)
+	}
+	for _, r := range v.Upstream + v.Revision {
+		if !isVersionRune(r) {
+			return Version{}, fmt.Errorf("invalid character %q in version %q", r, v)
+		}
+	}
+	return v, nil
+}
+
+func isVersionRune(r rune) bool {
+	return (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || strings.ContainsRune(".+-~:", r)
+}
+
+func (v Version) String() string {
+	var sb strings.Builder
+	if v.Epoch > 0 {
+		_, _ = fmt.Fprintf(&sb, "%d:", v.Epoch)
+	}
+	sb.WriteString(v.Upstream)
+	if v.Revision != "" {
+		sb.WriteRune('-')
+		sb.WriteString(v.Revision)
+	}
+	return sb.String()
+}
+
+// Compare returns -1, 0 or 1 if v sorts before, equal to or after o, as `dpkg --compare-versions`.
+func (v Version) Compare(o Version) int {
+	if v.Epoch != o.Epoch {
+		if v.Epoch < o.Epoch {
+			return -1
+		}
+		return 1
+	}
+	if c := compareFragment(v.Upstream, o.Upstream); c != 0 {
+		return c
+	}
+	return compareFragment(v.Revision, o.Revision)
+}
+
+// CompareVersions parses and compares two version strings.
+func CompareVersions(a, b string) (int, error) {
+	va, err := ParseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := ParseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	return va.Compare(vb), nil
+}
+
+// compareFragment implements dpkg's verrevcmp: alternating non-digit and digit runs are compared,
+// with '~' sorting before everything (even the end of the string) and letters before other characters.
+func compareFragment(a, b string) int {
+	for a != "" || b != "" {
+		// Non-digit prefix:
+		for (a != "" && !isDigit(a[0])) || (b != "" && !isDigit(b[0])) {
+			ac, bc := order(a), order(b)
+			if ac != bc {
+				if ac < bc {
+					return -1
+				}
+				return 1
+			}
+			a, b = a[1:], b[1:]
+		}
+
+		// Digit run, compared numerically:
+		var an, bn string
+		an, a = splitDigits(a)
+		bn, b = splitDigits(b)
+		an, bn = strings.TrimLeft(an, "0"), strings.TrimLeft(bn, "0")
+		if len(an) != len(bn) {
+			if len(an) < len(bn) {
+				return -1
+			}
+			return 1
+		}
+		if an != bn {
+			if an < bn {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func order(s string) int {
+	if s == "" {
+		return 0
+	}
+	c := s[0]
+	switch {
+	case isDigit(c):
+		return 0
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return int(c)
+	case c == '~':
+		return -1
+	default:
+		return int(c) + 256
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
@@ -0,0 +1,89 @@
+package dpkg_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/dpkg"
+)
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "1.0-0", 0},
+		{"1.0", "1.1", -1},
+		{"1.10", "1.9", 1},
+		{"1:1.0", "2.0", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0", "1.0+b1", -1},
+		{"1.0a", "1.0+", -1},
+		{"1.0.0", "1.0", 1},
+		{"2.2.12-1", "2.2.12-1+deb10u1", -1},
+		{"5.0-4", "5.0-10", -1},
+		{"1:2.33.1-0.1", "2.34", 1},
+		{"007", "7", 0},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("%s_%s", tc.a, tc.b), func(t *testing.T) {
+			c, err := dpkg.CompareVersions(tc.a, tc.b)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, c)
+			c, err = dpkg.CompareVersions(tc.b, tc.a)
+			require.NoError(t, err)
+			assert.Equal(t, -tc.expected, c)
+		})
+	}
+}
+
+func TestParseVersion_Invalid(t *testing.T) {
+	for _, v := range []string{"", "a1.0", "x:1.0", "1.0-", "1.0 1"} {
+		_, err := dpkg.ParseVersion(v)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestConstraint_Matches(t *testing.T) {
+	cases := []struct {
+		constraint string
+		matches    []string
+		rejects    []string
+	}{
+		{"2.2.12-1", []string{"2.2.12-1", "0:2.2.12-1"}, []string{"2.2.12-2", "2.2.12"}},
+		{">= 2.2.12", []string{"2.2.12", "2.2.12-1", "3.0"}, []string{"2.2.11", "2.2.12~rc1"}},
+		{">= 2.2, << 3", []string{"2.2", "2.9.9"}, []string{"3.0", "2.1"}},
+		{"> 2.2", []string{"2.2.1"}, []string{"2.2"}},
+		{"~2.2", []string{"2.2", "2.2.12-1", "2.2.99"}, []string{"2.3", "2.3~rc1", "2.1"}},
+		{"~2.2.12", []string{"2.2.12", "2.2.13"}, []string{"2.2.11", "2.3"}},
+		{"2.2.*", []string{"2.2", "2.2.12-1"}, []string{"2.3~rc1", "2.3"}},
+		{"1:2.*", []string{"1:2.33.1-0.1"}, []string{"2.33.1", "1:3.0"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.constraint, func(t *testing.T) {
+			c, err := dpkg.ParseConstraint(tc.constraint)
+			require.NoError(t, err)
+			for _, s := range tc.matches {
+				v, err := dpkg.ParseVersion(s)
+				require.NoError(t, err)
+				assert.True(t, c.Matches(v), "%s should match %s", c, s)
+			}
+			for _, s := range tc.rejects {
+				v, err := dpkg.ParseVersion(s)
+				require.NoError(t, err)
+				assert.False(t, c.Matches(v), "%s should not match %s", c, s)
+			}
+		})
+	}
+}
+
+func TestParseConstraint_Invalid(t *testing.T) {
+	for _, c := range []string{"", "=> 1.0", ">=", "~2.x", "~2.2-1"} {
+		_, err := dpkg.ParseConstraint(c)
+		assert.Error(t, err, c)
+	}
+}
@@ -3,6 +3,11 @@ package manifest
 import (
 	"encoding/json"
 	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/thepwagner/debendabot/dpkg"
 )
 
 const Filename = "dpkg.json"
@@ -10,17 +15,176 @@ const Filename = "dpkg.json"
 type PackageName string
 type PackageVersion string
 
+// IsSuite returns true if the version tracks a suite, rather than constraining the version.
+func (v PackageVersion) IsSuite() bool {
+	switch v {
+	case "stable", "unstable", "testing":
+		return true
+	}
+	return false
+}
+
+// Constraint parses a version constraint, like "2.2.12-1", ">= 2.2.12", "~2.2" or "2.2.*".
+func (v PackageVersion) Constraint() (dpkg.Constraint, error) {
+	return dpkg.ParseConstraint(string(v))
+}
+
 type DpkgJSON struct {
-	Image    string                         `json:"image"`
-	Distro   string                         `json:"distro"`
-	Packages map[PackageName]PackageVersion `json:"packages"`
-	// TODO: repositories, keys?
+	Image        string                         `json:"image"`
+	Distro       string                         `json:"distro"`
+	Packages     map[PackageName]PackageVersion `json:"packages"`
+	Repositories []Repository                   `json:"repositories,omitempty"`
+	// Architectures to build, defaults to DefaultArchitecture.
+	Architectures []string `json:"architectures,omitempty"`
+	// Mirror is the Debian archive to debootstrap from, defaults to DefaultMirror.
+	Mirror string `json:"mirror,omitempty"`
+	// Snapshot pins the archive state via snapshot.debian.org, recording the timestamp in the lockfile.
+	Snapshot bool `json:"snapshot,omitempty"`
+	// Config is the runtime configuration of exported OCI images.
+	Config *ImageConfig `json:"config,omitempty"`
+
+	// source is the document parsed by ParseDpkgJSON, to locate problems found by Validate.
+	source []byte
+}
+
+// ImageConfig is the subset of the OCI image configuration that dpkg.json can set.
+type ImageConfig struct {
+	Entrypoint []string          `json:"entrypoint,omitempty"`
+	Cmd        []string          `json:"cmd,omitempty"`
+	Env        []string          `json:"env,omitempty"`
+	User       string            `json:"user,omitempty"`
+	WorkingDir string            `json:"workingDir,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+// DefaultCmd is the command of images that configure neither an entrypoint nor a command, matching the docker build.
+var DefaultCmd = []string{"/usr/bin/bash"}
+
+// DefaultPath is the PATH of images that don't set one.
+const DefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// ImageConfig returns the image configuration, with defaults applied.
+func (d DpkgJSON) ImageConfig() ImageConfig {
+	var ret ImageConfig
+	if d.Config != nil {
+		ret = *d.Config
+	}
+	if len(ret.Entrypoint) == 0 && len(ret.Cmd) == 0 {
+		ret.Cmd = DefaultCmd
+	}
+	for _, env := range ret.Env {
+		if strings.HasPrefix(env, "PATH=") {
+			return ret
+		}
+	}
+	ret.Env = append([]string{"PATH=" + DefaultPath}, ret.Env...)
+	return ret
+}
+
+// DefaultMirror is used when dpkg.json does not specify a mirror.
+const DefaultMirror = "http://cdn-fastly.deb.debian.org/debian"
+
+// DefaultSecurityMirror provides security updates of stable releases.
+const DefaultSecurityMirror = "http://security.debian.org/debian-security"
+
+// DistroSuite is a suite of the distro's main component.
+type DistroSuite struct {
+	URI   string
+	Suite string
+}
+
+// DistroSuites returns the suites the distro is installed from: the release and its stable updates from mirror, then
+// its security updates from securityMirror. Unstable has no updates.
+func (d DpkgJSON) DistroSuites(mirror, securityMirror string) []DistroSuite {
+	ret := []DistroSuite{{URI: mirror, Suite: d.Distro}}
+	if updates, ok := d.UpdatesSuite(); ok {
+		ret = append(ret, DistroSuite{URI: mirror, Suite: updates})
+	}
+	if security, ok := d.SecuritySuite(); ok {
+		ret = append(ret, DistroSuite{URI: securityMirror, Suite: security})
+	}
+	return ret
 }
 
+// UpdatesSuite returns the suite of stable updates to the distro, e.g. "bullseye-updates".
+func (d DpkgJSON) UpdatesSuite() (string, bool) {
+	switch d.Distro {
+	case "sid", "unstable":
+		return "", false
+	}
+	return d.Distro + "-updates", true
+}
+
+// SecuritySuite returns the suite of security updates to the distro, e.g. "bullseye-security". Buster and older
+// releases name it "buster/updates".
+func (d DpkgJSON) SecuritySuite() (string, bool) {
+	switch d.Distro {
+	case "sid", "unstable":
+		return "", false
+	case "jessie", "stretch", "buster":
+		return d.Distro + "/updates", true
+	}
+	return d.Distro + "-security", true
+}
+
+// DefaultArchitecture is built when dpkg.json does not list architectures.
+const DefaultArchitecture = "amd64"
+
+// TargetArchitectures returns the Debian architectures to build.
+func (d DpkgJSON) TargetArchitectures() []string {
+	if len(d.Architectures) == 0 {
+		return []string{DefaultArchitecture}
+	}
+	return d.Architectures
+}
+
+// MultiArch returns true if more than one architecture is built.
+func (d DpkgJSON) MultiArch() bool {
+	return len(d.TargetArchitectures()) > 1
+}
+
+// Repository is an additional APT repository, installed into the rootfs alongside the distro's.
+type Repository struct {
+	// Name identifies the repository in sources.list.d, keyrings and the lockfile.
+	Name          string   `json:"name"`
+	URI           string   `json:"uri"`
+	Suite         string   `json:"suite"`
+	Components    []string `json:"components"`
+	Architectures []string `json:"architectures,omitempty"`
+
+	// Key is an ASCII-armored public key that signs the repository.
+	Key string `json:"key,omitempty"`
+	// Fingerprint of the signing key. If Key is empty, the key is fetched from a keyserver.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// KeyFingerprint returns the fingerprint of the signing key in upper case, without spaces.
+func (r Repository) KeyFingerprint() string {
+	return strings.ToUpper(strings.Join(strings.Fields(r.Fingerprint), ""))
+}
+
+// Hash returns the digest of dpkg.json as it was parsed, or of its encoding if it wasn't parsed.
+func (d DpkgJSON) Hash() (string, error) {
+	b := d.source
+	if b == nil {
+		var err error
+		if b, err = json.Marshal(d); err != nil {
+			return "", err
+		}
+	}
+	return digest.FromBytes(b).String(), nil
+}
+
+// ParseDpkgJSON decodes dpkg.json, locating syntax errors. Call Validate to check the values.
 func ParseDpkgJSON(r io.Reader) (*DpkgJSON, error) {
-	var d DpkgJSON
-	if err := json.NewDecoder(r).Decode(&d); err != nil {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
 		return nil, err
 	}
+	var d DpkgJSON
+	if err := json.Unmarshal(b, &d); err != nil {
+		return nil, decodeProblem(b, err)
+	}
+	d.source = b
 	return &d, nil
 }
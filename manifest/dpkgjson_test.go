@@ -4,8 +4,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/thepwagner/debendabot/manifest"
@@ -35,3 +37,57 @@ func TestParseDpkgJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestDpkgJSON_ImageConfig(t *testing.T) {
+	var d manifest.DpkgJSON
+	cfg := d.ImageConfig()
+	assert.Equal(t, manifest.DefaultCmd, cfg.Cmd)
+	assert.Equal(t, []string{"PATH=" + manifest.DefaultPath}, cfg.Env)
+
+	d.Config = &manifest.ImageConfig{
+		Entrypoint: []string{"/usr/bin/zsh"},
+		Env:        []string{"PATH=/bin", "TERM=xterm"},
+		User:       "nobody",
+	}
+	cfg = d.ImageConfig()
+	assert.Empty(t, cfg.Cmd)
+	assert.Equal(t, []string{"/usr/bin/zsh"}, cfg.Entrypoint)
+	assert.Equal(t, []string{"PATH=/bin", "TERM=xterm"}, cfg.Env)
+	assert.Equal(t, "nobody", cfg.User)
+}
+
+func TestDpkgJSON_Hash(t *testing.T) {
+	const src = `{"image": "debian", "distro": "buster", "packages": {"zsh": "stable"}}`
+	d, err := manifest.ParseDpkgJSON(strings.NewReader(src))
+	require.NoError(t, err)
+	hash, err := d.Hash()
+	require.NoError(t, err)
+	assert.Equal(t, digest.FromString(src).String(), hash)
+
+	unparsed, err := manifest.DpkgJSON{Image: d.Image, Distro: d.Distro, Packages: d.Packages}.Hash()
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(unparsed, "sha256:"))
+	assert.NotEqual(t, hash, unparsed)
+}
+
+func TestDpkgJSON_DistroSuites(t *testing.T) {
+	cases := map[string][]manifest.DistroSuite{
+		"buster": {
+			{URI: "http://mirror", Suite: "buster"},
+			{URI: "http://mirror", Suite: "buster-updates"},
+			{URI: "http://security", Suite: "buster/updates"},
+		},
+		"bookworm": {
+			{URI: "http://mirror", Suite: "bookworm"},
+			{URI: "http://mirror", Suite: "bookworm-updates"},
+			{URI: "http://security", Suite: "bookworm-security"},
+		},
+		"sid": {
+			{URI: "http://mirror", Suite: "sid"},
+		},
+	}
+	for distro, expected := range cases {
+		d := manifest.DpkgJSON{Distro: distro}
+		assert.Equal(t, expected, d.DistroSuites("http://mirror", "http://security"), distro)
+	}
+}
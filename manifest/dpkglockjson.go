@@ -1,28 +1,107 @@
 package manifest
 
 import (
-	"encoding/json"
+	"fmt"
 	"io"
+	"time"
 )
 
 const LockFilename = "dpkg-lock.json"
 
+// SnapshotTimestampFormat is the timestamp format used by snapshot.debian.org.
+const SnapshotTimestampFormat = "20060102T150405Z"
+
+// SnapshotTimestamp formats a time as a snapshot.debian.org timestamp.
+func SnapshotTimestamp(t time.Time) string {
+	return t.UTC().Format(SnapshotTimestampFormat)
+}
+
+// SnapshotMirror returns the snapshot.debian.org archive URL for a timestamp.
+func SnapshotMirror(timestamp string) string {
+	return fmt.Sprintf("http://snapshot.debian.org/archive/debian/%s", timestamp)
+}
+
+// SnapshotSecurityMirror returns the snapshot.debian.org security archive URL for a timestamp.
+func SnapshotSecurityMirror(timestamp string) string {
+	return fmt.Sprintf("http://snapshot.debian.org/archive/debian-security/%s", timestamp)
+}
+
 type LockedPackage struct {
 	Version      string `json:"version"`
 	Architecture string `json:"architecture"`
 	DebFilename  string `json:"filename"`
 	DebHash      string `json:"filehash"`
+	// Repository is the name of the dpkg.json repository that provided the package, empty for the distro.
+	Repository string `json:"repository,omitempty"`
+	// Source and SourceVersion are the source package the binary was built from, which security advisories track.
+	Source        string `json:"source,omitempty"`
+	SourceVersion string `json:"sourceVersion,omitempty"`
+	// Suite, Component and Origin identify the index within Repository that listed the package.
+	Suite     string `json:"suite,omitempty"`
+	Component string `json:"component,omitempty"`
+	Origin    string `json:"origin,omitempty"`
+	// Codename is the Release codename of Suite, which the security tracker uses rather than aliases like "stable".
+	Codename string `json:"codename,omitempty"`
+	// Path is the pool path of the .deb, relative to the repository URI.
+	Path string `json:"path,omitempty"`
+	// Size of the .deb in bytes.
+	Size int64 `json:"size,omitempty"`
+	// RequiredBy are the locked packages that Depend or Pre-Depend on this package.
+	RequiredBy []PackageName `json:"requiredBy,omitempty"`
+	// Direct packages are requested by dpkg.json, rather than installed as a dependency or by the base image.
+	Direct bool `json:"direct,omitempty"`
 }
 
 type DpkgLockJSON struct {
-	Image    string                        `json:"image"`
-	Packages map[PackageName]LockedPackage `json:"packages"`
+	// LockfileVersion is the format of the lockfile, see CurrentLockfileVersion.
+	LockfileVersion int    `json:"lockfileVersion"`
+	Image           string `json:"image"`
+	// Distro is the dpkg.json distro the packages were locked from.
+	Distro string `json:"distro,omitempty"`
+	// ManifestHash is the digest of the dpkg.json the packages were locked from, see DpkgJSON.Hash.
+	ManifestHash string `json:"manifestHash,omitempty"`
+	// Snapshot is the snapshot.debian.org timestamp packages were locked from, if dpkg.json enables snapshots.
+	Snapshot string `json:"snapshot,omitempty"`
+	// SourceDateEpoch timestamps reproducible exports, in seconds since the Unix epoch.
+	SourceDateEpoch int64 `json:"sourceDateEpoch,omitempty"`
+	// Packages are locked packages when dpkg.json does not list architectures.
+	Packages map[PackageName]LockedPackage `json:"packages,omitempty"`
+	// Architectures are locked packages by architecture, when dpkg.json lists architectures.
+	Architectures map[string]map[PackageName]LockedPackage `json:"architectures,omitempty"`
+	// Rootfs are digests of the normalized rootfs built from the lockfile, by architecture.
+	Rootfs map[string]RootfsDigest `json:"rootfs,omitempty"`
 }
 
-func ParseDpkgLockJSON(r io.Reader) (*DpkgLockJSON, error) {
-	var d DpkgLockJSON
-	if err := json.NewDecoder(r).Decode(&d); err != nil {
-		return nil, err
+// RootfsDigest pins the filesystem built from a lockfile.
+type RootfsDigest struct {
+	// Tarball is the digest of the normalized rootfs tarball.
+	Tarball string `json:"tarball"`
+	// Files is the digest of a listing of every file's path, type, mode, owner and content digest.
+	// Unlike Tarball, it doesn't depend on how the tarball is encoded.
+	Files string `json:"files"`
+}
+
+// PackagesFor returns the packages locked for an architecture.
+func (d *DpkgLockJSON) PackagesFor(arch string) map[PackageName]LockedPackage {
+	if d.Architectures != nil {
+		return d.Architectures[arch]
+	}
+	if arch == DefaultArchitecture {
+		return d.Packages
+	}
+	return nil
+}
+
+// AllPackages returns the packages locked for every architecture.
+func (d *DpkgLockJSON) AllPackages() map[string]map[PackageName]LockedPackage {
+	if d.Architectures != nil {
+		return d.Architectures
 	}
-	return &d, nil
+	return map[string]map[PackageName]LockedPackage{DefaultArchitecture: d.Packages}
+}
+
+// ParseDpkgLockJSON decodes a lockfile, upgrading older formats to CurrentLockfileVersion in memory.
+func ParseDpkgLockJSON(r io.Reader) (*DpkgLockJSON, error) {
+	d, _, err := MigrateDpkgLockJSON(r)
+	return d, err
 }
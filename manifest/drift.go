@@ -0,0 +1,119 @@
+package manifest
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/thepwagner/debendabot/dpkg"
+)
+
+// DriftKind is how a lockfile no longer satisfies dpkg.json.
+type DriftKind string
+
+const (
+	// DriftUnlocked packages are in dpkg.json, but not the lockfile, so would be installed unpinned.
+	DriftUnlocked DriftKind = "unlocked"
+	// DriftUnsatisfied packages are locked to a version that doesn't satisfy the constraint in dpkg.json.
+	DriftUnsatisfied DriftKind = "unsatisfied"
+	// DriftArchitecture architectures are built, but have no locked packages.
+	DriftArchitecture DriftKind = "architecture"
+	// DriftDistro lockfiles were locked from a different distro.
+	DriftDistro DriftKind = "distro"
+	// DriftManifest lockfiles were locked from a dpkg.json with a different hash, e.g. an edited image or repository.
+	DriftManifest DriftKind = "manifest"
+)
+
+// Drift is a difference between dpkg.json and the lockfile, fixed by updating the lockfile.
+type Drift struct {
+	Kind         DriftKind   `json:"kind"`
+	Architecture string      `json:"architecture,omitempty"`
+	Package      PackageName `json:"package,omitempty"`
+	// Expected is required by dpkg.json, e.g. a version constraint, distro or manifest hash.
+	Expected string `json:"expected,omitempty"`
+	// Locked is recorded in the lockfile.
+	Locked string `json:"locked,omitempty"`
+}
+
+func (d Drift) String() string {
+	switch d.Kind {
+	case DriftUnlocked:
+		return fmt.Sprintf("%s package %q is not locked", d.Architecture, d.Package)
+	case DriftUnsatisfied:
+		return fmt.Sprintf("%s package %q is locked to %s, which does not satisfy %q", d.Architecture, d.Package, d.Locked, d.Expected)
+	case DriftArchitecture:
+		return fmt.Sprintf("architecture %s is not locked", d.Architecture)
+	case DriftDistro:
+		return fmt.Sprintf("lockfile distro %q does not match %q", d.Locked, d.Expected)
+	case DriftManifest:
+		return "dpkg.json changed since the lockfile was written"
+	}
+	return string(d.Kind)
+}
+
+// Drift returns how the lockfile no longer satisfies dpkg.json, or nil if there is no lockfile.
+func (m *Manifest) Drift() ([]Drift, error) {
+	lock := m.DpkgLockJSON
+	if lock == nil {
+		return nil, nil
+	}
+
+	var ret []Drift
+	// Lockfiles before version 3 didn't record the distro:
+	if lock.Distro != "" && lock.Distro != m.DpkgJSON.Distro {
+		ret = append(ret, Drift{Kind: DriftDistro, Expected: m.DpkgJSON.Distro, Locked: lock.Distro})
+	}
+	// Lockfiles before version 4 didn't record the manifest hash:
+	if lock.ManifestHash != "" {
+		hash, err := m.DpkgJSON.Hash()
+		if err != nil {
+			return nil, fmt.Errorf("hashing dpkg.json: %w", err)
+		}
+		if hash != lock.ManifestHash {
+			ret = append(ret, Drift{Kind: DriftManifest, Expected: hash, Locked: lock.ManifestHash})
+		}
+	}
+
+	names := sortedPackageNames(m.DpkgJSON.Packages)
+	for _, arch := range m.DpkgJSON.TargetArchitectures() {
+		locked := lock.PackagesFor(arch)
+		if locked == nil {
+			ret = append(ret, Drift{Kind: DriftArchitecture, Architecture: arch})
+			continue
+		}
+
+		for _, name := range names {
+			version := m.DpkgJSON.Packages[name]
+			lp, ok := locked[name]
+			if !ok {
+				ret = append(ret, Drift{Kind: DriftUnlocked, Architecture: arch, Package: name, Expected: string(version)})
+				continue
+			}
+			if version.IsSuite() {
+				continue
+			}
+			satisfied, err := satisfies(version, lp.Version)
+			if err != nil {
+				return nil, fmt.Errorf("package %q: %w", name, err)
+			}
+			if !satisfied {
+				ret = append(ret, Drift{Kind: DriftUnsatisfied, Architecture: arch, Package: name, Expected: string(version), Locked: lp.Version})
+			}
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Architecture < ret[j].Architecture
+	})
+	return ret, nil
+}
+
+func satisfies(version PackageVersion, locked string) (bool, error) {
+	constraint, err := version.Constraint()
+	if err != nil {
+		return false, err
+	}
+	v, err := dpkg.ParseVersion(locked)
+	if err != nil {
+		return false, fmt.Errorf("locked version: %w", err)
+	}
+	return constraint.Matches(v), nil
+}
@@ -0,0 +1,92 @@
+package manifest_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+func TestManifest_Drift(t *testing.T) {
+	mf := &manifest.Manifest{
+		DpkgJSON: manifest.DpkgJSON{
+			Distro:        "bullseye",
+			Architectures: []string{"amd64", "arm64"},
+			Packages: map[manifest.PackageName]manifest.PackageVersion{
+				"added":  ">= 1.0",
+				"bash":   "stable",
+				"gnupg2": "~2.2.20",
+				"libc6":  "2.28-10",
+			},
+		},
+		DpkgLockJSON: &manifest.DpkgLockJSON{
+			Distro: "buster",
+			Architectures: map[string]map[manifest.PackageName]manifest.LockedPackage{
+				"amd64": {
+					"bash":   {Version: "5.0-4"},
+					"gnupg2": {Version: "2.2.12-1+deb10u1"},
+					"libc6":  {Version: "2.28-10"},
+				},
+			},
+		},
+	}
+
+	drift, err := mf.Drift()
+	require.NoError(t, err)
+	assert.Equal(t, []manifest.Drift{
+		{Kind: manifest.DriftDistro, Expected: "bullseye", Locked: "buster"},
+		{Kind: manifest.DriftUnlocked, Architecture: "amd64", Package: "added", Expected: ">= 1.0"},
+		{Kind: manifest.DriftUnsatisfied, Architecture: "amd64", Package: "gnupg2", Expected: "~2.2.20", Locked: "2.2.12-1+deb10u1"},
+		{Kind: manifest.DriftArchitecture, Architecture: "arm64"},
+	}, drift)
+	assert.Equal(t, `amd64 package "gnupg2" is locked to 2.2.12-1+deb10u1, which does not satisfy "~2.2.20"`, drift[2].String())
+}
+
+func TestManifest_Drift_Satisfied(t *testing.T) {
+	mf := &manifest.Manifest{
+		DpkgJSON: manifest.DpkgJSON{
+			Distro:   "buster",
+			Packages: map[manifest.PackageName]manifest.PackageVersion{"gnupg2": "~2.2.12"},
+		},
+		DpkgLockJSON: &manifest.DpkgLockJSON{
+			Packages: map[manifest.PackageName]manifest.LockedPackage{
+				"gnupg2": {Version: "2.2.12-1+deb10u1"},
+				"libc6":  {Version: "2.28-10"},
+			},
+		},
+	}
+	drift, err := mf.Drift()
+	require.NoError(t, err)
+	assert.Empty(t, drift)
+
+	mf.DpkgLockJSON = nil
+	drift, err = mf.Drift()
+	require.NoError(t, err)
+	assert.Empty(t, drift)
+}
+
+func TestManifest_Drift_ManifestHash(t *testing.T) {
+	dpkgJSON, err := manifest.ParseDpkgJSON(strings.NewReader(`{"image":"debian","distro":"buster"}`))
+	require.NoError(t, err)
+	hash, err := dpkgJSON.Hash()
+	require.NoError(t, err)
+	mf := &manifest.Manifest{
+		DpkgJSON:     *dpkgJSON,
+		DpkgLockJSON: &manifest.DpkgLockJSON{ManifestHash: hash, Packages: map[manifest.PackageName]manifest.LockedPackage{}},
+	}
+	drift, err := mf.Drift()
+	require.NoError(t, err)
+	assert.Empty(t, drift)
+
+	edited, err := manifest.ParseDpkgJSON(strings.NewReader(`{"image":"debian","distro":"buster","snapshot":true}`))
+	require.NoError(t, err)
+	mf.DpkgJSON = *edited
+	drift, err = mf.Drift()
+	require.NoError(t, err)
+	require.Len(t, drift, 1)
+	assert.Equal(t, manifest.DriftManifest, drift[0].Kind)
+	assert.Equal(t, hash, drift[0].Locked)
+	assert.Equal(t, "dpkg.json changed since the lockfile was written", drift[0].String())
+}
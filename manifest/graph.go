@@ -0,0 +1,47 @@
+package manifest
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Why returns the shortest dependency path from each direct package that requires a locked package, ordered by
+// direct package. Paths start at the direct package and end at the requested package, which is its own path if direct.
+// Packages installed only by the base image have no paths.
+func (d *DpkgLockJSON) Why(arch string, name PackageName) ([][]PackageName, error) {
+	locked := d.PackagesFor(arch)
+	if _, ok := locked[name]; !ok {
+		return nil, fmt.Errorf("package %q is not locked for %s", name, arch)
+	}
+
+	// Walk up the RequiredBy edges breadth first, so the first path found to a package is the shortest:
+	next := map[PackageName]PackageName{name: ""}
+	queue := []PackageName{name}
+	var direct []PackageName
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		if locked[pkg].Direct {
+			direct = append(direct, pkg)
+		}
+		for _, dependent := range locked[pkg].RequiredBy {
+			if _, seen := next[dependent]; seen {
+				continue
+			}
+			next[dependent] = pkg
+			queue = append(queue, dependent)
+		}
+	}
+
+	sort.Slice(direct, func(i, j int) bool { return direct[i] < direct[j] })
+	paths := make([][]PackageName, 0, len(direct))
+	for _, pkg := range direct {
+		path := []PackageName{pkg}
+		for pkg != name {
+			pkg = next[pkg]
+			path = append(path, pkg)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
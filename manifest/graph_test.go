@@ -0,0 +1,39 @@
+package manifest_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+func TestDpkgLockJSON_Why(t *testing.T) {
+	lock := &manifest.DpkgLockJSON{
+		Packages: map[manifest.PackageName]manifest.LockedPackage{
+			"zsh":          {Direct: true},
+			"gnupg2":       {Direct: true},
+			"gpg":          {RequiredBy: []manifest.PackageName{"gnupg2"}},
+			"libgcrypt20":  {RequiredBy: []manifest.PackageName{"gpg", "libsystemd0"}},
+			"libsystemd0":  {RequiredBy: []manifest.PackageName{"bsdutils"}},
+			"bsdutils":     {},
+			"libc6":        {Direct: true, RequiredBy: []manifest.PackageName{"zsh", "gpg", "libgcrypt20"}},
+			"libgpg-error": {RequiredBy: []manifest.PackageName{"libgcrypt20", "libgpg-error"}},
+		},
+	}
+
+	paths, err := lock.Why(manifest.DefaultArchitecture, "libgpg-error")
+	require.NoError(t, err)
+	assert.Equal(t, [][]manifest.PackageName{{"gnupg2", "gpg", "libgcrypt20", "libgpg-error"}}, paths)
+
+	paths, err = lock.Why(manifest.DefaultArchitecture, "libc6")
+	require.NoError(t, err)
+	assert.Equal(t, [][]manifest.PackageName{{"gnupg2", "gpg", "libc6"}, {"libc6"}, {"zsh", "libc6"}}, paths)
+
+	paths, err = lock.Why(manifest.DefaultArchitecture, "libsystemd0")
+	require.NoError(t, err)
+	assert.Empty(t, paths)
+
+	_, err = lock.Why(manifest.DefaultArchitecture, "vim")
+	assert.EqualError(t, err, `package "vim" is not locked for amd64`)
+}
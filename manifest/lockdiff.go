@@ -0,0 +1,125 @@
+package manifest
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/thepwagner/debendabot/dpkg"
+)
+
+type ChangeKind string
+
+const (
+	Added      ChangeKind = "added"
+	Removed    ChangeKind = "removed"
+	Upgraded   ChangeKind = "upgraded"
+	Downgraded ChangeKind = "downgraded"
+	// Rehashed packages kept their version, but the .deb changed.
+	Rehashed ChangeKind = "rehashed"
+)
+
+// PackageChange is a difference in a locked package between lockfiles.
+type PackageChange struct {
+	Package      PackageName `json:"package"`
+	Architecture string      `json:"architecture"`
+	Kind         ChangeKind  `json:"kind"`
+	OldVersion   string      `json:"oldVersion,omitempty"`
+	NewVersion   string      `json:"newVersion,omitempty"`
+	OldHash      string      `json:"oldHash,omitempty"`
+	NewHash      string      `json:"newHash,omitempty"`
+}
+
+// LockDiff is the difference between two lockfiles.
+type LockDiff struct {
+	OldImage string          `json:"oldImage"`
+	NewImage string          `json:"newImage"`
+	Changes  []PackageChange `json:"changes"`
+}
+
+// ImageChanged returns true if the base image digest changed.
+func (d *LockDiff) ImageChanged() bool {
+	return d.OldImage != d.NewImage
+}
+
+// Empty returns true if the lockfiles are equivalent.
+func (d *LockDiff) Empty() bool {
+	return !d.ImageChanged() && len(d.Changes) == 0
+}
+
+// DiffLockfiles compares two lockfiles, either of which may be nil.
+// Changes are sorted by package, then architecture.
+func DiffLockfiles(oldLock, newLock *DpkgLockJSON) (*LockDiff, error) {
+	if oldLock == nil {
+		oldLock = &DpkgLockJSON{}
+	}
+	if newLock == nil {
+		newLock = &DpkgLockJSON{}
+	}
+	diff := &LockDiff{OldImage: oldLock.Image, NewImage: newLock.Image}
+
+	oldPackages, newPackages := oldLock.AllPackages(), newLock.AllPackages()
+	arches := map[string]struct{}{}
+	for arch := range oldPackages {
+		arches[arch] = struct{}{}
+	}
+	for arch := range newPackages {
+		arches[arch] = struct{}{}
+	}
+
+	for arch := range arches {
+		oldLocked, newLocked := oldPackages[arch], newPackages[arch]
+		for name, o := range oldLocked {
+			if _, ok := newLocked[name]; !ok {
+				diff.Changes = append(diff.Changes, PackageChange{
+					Package:      name,
+					Architecture: arch,
+					Kind:         Removed,
+					OldVersion:   o.Version,
+					OldHash:      o.DebHash,
+				})
+			}
+		}
+
+		for name, n := range newLocked {
+			change := PackageChange{
+				Package:      name,
+				Architecture: arch,
+				NewVersion:   n.Version,
+				NewHash:      n.DebHash,
+			}
+			o, ok := oldLocked[name]
+			if !ok {
+				change.Kind = Added
+				diff.Changes = append(diff.Changes, change)
+				continue
+			}
+			change.OldVersion = o.Version
+			change.OldHash = o.DebHash
+
+			cmp, err := dpkg.CompareVersions(o.Version, n.Version)
+			if err != nil {
+				return nil, fmt.Errorf("package %q: %w", name, err)
+			}
+			switch {
+			case cmp < 0:
+				change.Kind = Upgraded
+			case cmp > 0:
+				change.Kind = Downgraded
+			case o.DebHash != n.DebHash:
+				change.Kind = Rehashed
+			default:
+				continue
+			}
+			diff.Changes = append(diff.Changes, change)
+		}
+	}
+
+	sort.Slice(diff.Changes, func(i, j int) bool {
+		a, b := diff.Changes[i], diff.Changes[j]
+		if a.Package != b.Package {
+			return a.Package < b.Package
+		}
+		return a.Architecture < b.Architecture
+	})
+	return diff, nil
+}
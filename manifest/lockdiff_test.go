@@ -0,0 +1,71 @@
+package manifest_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+func TestDiffLockfiles(t *testing.T) {
+	oldLock := &manifest.DpkgLockJSON{
+		Image: "debian@sha256:old",
+		Packages: map[manifest.PackageName]manifest.LockedPackage{
+			"bash":    {Version: "5.0-4", DebHash: "a"},
+			"gnupg2":  {Version: "2.2.12-1+deb10u1", DebHash: "b"},
+			"libc6":   {Version: "2.28-10", DebHash: "c"},
+			"tzdata":  {Version: "2020a-0+deb10u1", DebHash: "d"},
+			"removed": {Version: "1.0", DebHash: "e"},
+			"same":    {Version: "1.0", DebHash: "f"},
+		},
+	}
+	newLock := &manifest.DpkgLockJSON{
+		Image: "debian@sha256:new",
+		Packages: map[manifest.PackageName]manifest.LockedPackage{
+			"added":  {Version: "1.0", DebHash: "g"},
+			"bash":   {Version: "5.0-4", DebHash: "h"},
+			"gnupg2": {Version: "2.2.12-1", DebHash: "i"},
+			"libc6":  {Version: "2.28-10+deb10u1", DebHash: "j"},
+			"tzdata": {Version: "2020a-0+deb10u1", DebHash: "d"},
+			"same":   {Version: "1.0", DebHash: "f"},
+		},
+	}
+
+	diff, err := manifest.DiffLockfiles(oldLock, newLock)
+	require.NoError(t, err)
+	assert.True(t, diff.ImageChanged())
+
+	kinds := map[manifest.PackageName]manifest.ChangeKind{}
+	for _, c := range diff.Changes {
+		assert.Equal(t, manifest.DefaultArchitecture, c.Architecture)
+		kinds[c.Package] = c.Kind
+	}
+	assert.Equal(t, map[manifest.PackageName]manifest.ChangeKind{
+		"added":   manifest.Added,
+		"bash":    manifest.Rehashed,
+		"gnupg2":  manifest.Downgraded,
+		"libc6":   manifest.Upgraded,
+		"removed": manifest.Removed,
+	}, kinds)
+	assert.Equal(t, manifest.PackageName("added"), diff.Changes[0].Package)
+
+	diff, err = manifest.DiffLockfiles(newLock, newLock)
+	require.NoError(t, err)
+	assert.True(t, diff.Empty())
+}
+
+func TestLockDiff_JSON(t *testing.T) {
+	b, err := json.Marshal(manifest.LockDiff{
+		OldImage: "debian@sha256:old",
+		NewImage: "debian@sha256:new",
+		Changes:  []manifest.PackageChange{{Package: "bash", Architecture: "amd64", Kind: manifest.Upgraded, OldVersion: "5.0-4", NewVersion: "5.0-4+deb10u1"}},
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+  "oldImage": "debian@sha256:old",
+  "newImage": "debian@sha256:new",
+  "changes": [{"package": "bash", "architecture": "amd64", "kind": "upgraded", "oldVersion": "5.0-4", "newVersion": "5.0-4+deb10u1"}]
+}`, string(b))
+}
@@ -5,11 +5,16 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 type Manifest struct {
 	DpkgJSON     DpkgJSON
 	DpkgLockJSON *DpkgLockJSON
+
+	// manifestPath and lockfilePath are where ParseManifest read from, to report problems.
+	manifestPath string
+	lockfilePath string
 }
 
 func ParseManifest(dir, manifestPath, lockfilePath string) (*Manifest, error) {
@@ -29,7 +34,7 @@ func ParseManifest(dir, manifestPath, lockfilePath string) (*Manifest, error) {
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			// Return without lockfile:
-			return &Manifest{DpkgJSON: *dpkgJSON}, nil
+			return &Manifest{DpkgJSON: *dpkgJSON, manifestPath: mfp}, nil
 		}
 		return nil, fmt.Errorf("opening %q: %w", lfp, err)
 	}
@@ -42,6 +47,8 @@ func ParseManifest(dir, manifestPath, lockfilePath string) (*Manifest, error) {
 	return &Manifest{
 		DpkgJSON:     *dpkgJSON,
 		DpkgLockJSON: dpkgLockJSON,
+		manifestPath: mfp,
+		lockfilePath: lfp,
 	}, nil
 }
 
@@ -56,5 +63,76 @@ func (m *Manifest) LockedPackageCount() int {
 	if m == nil || m.DpkgLockJSON == nil {
 		return 0
 	}
-	return len(m.DpkgLockJSON.Packages)
+	var count int
+	for _, pkgs := range m.DpkgLockJSON.AllPackages() {
+		count += len(pkgs)
+	}
+	return count
+}
+
+// Mirror returns the Debian archive to build from.
+// A snapshot recorded in the lockfile takes precedence over dpkg.json.
+func (m *Manifest) Mirror() string {
+	if m.DpkgLockJSON != nil && m.DpkgLockJSON.Snapshot != "" {
+		return SnapshotMirror(m.DpkgLockJSON.Snapshot)
+	}
+	if m.DpkgJSON.Mirror != "" {
+		return m.DpkgJSON.Mirror
+	}
+	return DefaultMirror
+}
+
+// SecurityMirror returns the archive of security updates to build from.
+// A snapshot recorded in the lockfile takes precedence over DefaultSecurityMirror.
+func (m *Manifest) SecurityMirror() string {
+	if m.DpkgLockJSON != nil && m.DpkgLockJSON.Snapshot != "" {
+		return SnapshotSecurityMirror(m.DpkgLockJSON.Snapshot)
+	}
+	return DefaultSecurityMirror
+}
+
+// DebURL returns the download URL of a locked package, or false if the lockfile doesn't record its pool path or
+// its repository is no longer in dpkg.json.
+func (m *Manifest) DebURL(lock LockedPackage) (string, bool) {
+	if lock.Path == "" {
+		return "", false
+	}
+	uri := m.Mirror()
+	if security, ok := m.DpkgJSON.SecuritySuite(); ok && lock.Suite == security {
+		uri = m.SecurityMirror()
+	}
+	if lock.Repository != "" {
+		uri = ""
+		for _, repo := range m.DpkgJSON.Repositories {
+			if repo.Name == lock.Repository {
+				uri = repo.URI
+			}
+		}
+		if uri == "" {
+			return "", false
+		}
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(uri, "/"), lock.Path), true
+}
+
+// Codename returns the codename of the distro, e.g. "bookworm", resolving aliases like "stable" by the Release
+// codename recorded in the lockfile. Unstable is always "sid".
+func (m *Manifest) Codename() (string, error) {
+	switch m.DpkgJSON.Distro {
+	case "unstable":
+		return "sid", nil
+	case "oldoldstable", "oldstable", "stable", "testing":
+	default:
+		return m.DpkgJSON.Distro, nil
+	}
+	if m.DpkgLockJSON != nil {
+		for _, pkgs := range m.DpkgLockJSON.AllPackages() {
+			for _, pkg := range pkgs {
+				if pkg.Repository == "" && pkg.Suite == m.DpkgJSON.Distro && pkg.Codename != "" {
+					return pkg.Codename, nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("codename of %q is not locked, run update", m.DpkgJSON.Distro)
 }
@@ -0,0 +1,68 @@
+package manifest_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+func TestManifest_DebURL(t *testing.T) {
+	mf := &manifest.Manifest{
+		DpkgJSON: manifest.DpkgJSON{
+			Distro:       "buster",
+			Repositories: []manifest.Repository{{Name: "docker", URI: "https://download.docker.com/linux/debian/"}},
+		},
+		DpkgLockJSON: &manifest.DpkgLockJSON{Snapshot: "20210301T000000Z"},
+	}
+
+	url, ok := mf.DebURL(manifest.LockedPackage{Path: "pool/main/b/bash/bash_5.0-4_amd64.deb"})
+	assert.True(t, ok)
+	assert.Equal(t, "http://snapshot.debian.org/archive/debian/20210301T000000Z/pool/main/b/bash/bash_5.0-4_amd64.deb", url)
+
+	url, ok = mf.DebURL(manifest.LockedPackage{Repository: "docker", Path: "dists/buster/pool/stable/amd64/containerd.io_1.4.3-1_amd64.deb"})
+	assert.True(t, ok)
+	assert.Equal(t, "https://download.docker.com/linux/debian/dists/buster/pool/stable/amd64/containerd.io_1.4.3-1_amd64.deb", url)
+
+	url, ok = mf.DebURL(manifest.LockedPackage{Suite: "buster/updates", Path: "pool/updates/main/g/glibc/libc6_2.28-10+deb10u1_amd64.deb"})
+	assert.True(t, ok)
+	assert.Equal(t, "http://snapshot.debian.org/archive/debian-security/20210301T000000Z/pool/updates/main/g/glibc/libc6_2.28-10+deb10u1_amd64.deb", url)
+
+	_, ok = mf.DebURL(manifest.LockedPackage{DebFilename: "bash_5.0-4_amd64.deb"})
+	assert.False(t, ok)
+
+	_, ok = mf.DebURL(manifest.LockedPackage{Repository: "removed", Path: "pool/main/r/removed/removed_1.0_amd64.deb"})
+	assert.False(t, ok)
+}
+
+func TestManifest_Codename(t *testing.T) {
+	lock := &manifest.DpkgLockJSON{
+		Packages: map[manifest.PackageName]manifest.LockedPackage{
+			"libc6": {Suite: "stable-security", Codename: "bookworm-security"},
+			"bash":  {Suite: "stable", Codename: "bookworm"},
+		},
+	}
+	cases := []struct {
+		distro   string
+		lock     *manifest.DpkgLockJSON
+		expected string
+	}{
+		{distro: "buster", expected: "buster"},
+		{distro: "unstable", expected: "sid"},
+		{distro: "stable", lock: lock, expected: "bookworm"},
+		{distro: "stable"},
+		{distro: "testing", lock: lock},
+	}
+	for _, tc := range cases {
+		mf := manifest.Manifest{DpkgJSON: manifest.DpkgJSON{Distro: tc.distro}, DpkgLockJSON: tc.lock}
+		codename, err := mf.Codename()
+		if tc.expected == "" {
+			assert.EqualError(t, err, fmt.Sprintf("codename of %q is not locked, run update", tc.distro), tc.distro)
+			continue
+		}
+		require.NoError(t, err, tc.distro)
+		assert.Equal(t, tc.expected, codename, tc.distro)
+	}
+}
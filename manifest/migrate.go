@@ -0,0 +1,100 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// CurrentLockfileVersion is the lockfile format written by this version of debendabot.
+const CurrentLockfileVersion = 6
+
+// ErrUnsupportedLockfileVersion is returned for lockfiles written by a newer debendabot.
+var ErrUnsupportedLockfileVersion = errors.New("unsupported lockfile version")
+
+// lockfileFields maps the top-level fields of a lockfile to the version that introduced them. lockfileVersion selects
+// the version, so it is accepted in every version.
+var lockfileFields = map[string]int{
+	"image":           1,
+	"snapshot":        1,
+	"sourceDateEpoch": 1,
+	"packages":        1,
+	"architectures":   1,
+	"rootfs":          1,
+	"lockfileVersion": 1,
+	"distro":          3,
+	"manifestHash":    4,
+}
+
+// lockfileMigration upgrades the fields of a lockfile to the next version.
+type lockfileMigration func(fields map[string]json.RawMessage) error
+
+// lockfileMigrations are indexed by the version they upgrade from, less one.
+var lockfileMigrations = []lockfileMigration{
+	// Version 2 records lockfileVersion, with an otherwise unchanged format:
+	func(fields map[string]json.RawMessage) error {
+		fields["lockfileVersion"] = json.RawMessage("2")
+		return nil
+	},
+	// Version 3 records the distro, which is unknown for older lockfiles:
+	func(map[string]json.RawMessage) error { return nil },
+	// Version 4 records the dependency graph and manifest hash, which are unknown until the lockfile is updated:
+	func(map[string]json.RawMessage) error { return nil },
+	// Version 5 records where packages were downloaded from, which is also unknown until the lockfile is updated:
+	func(map[string]json.RawMessage) error { return nil },
+	// Version 6 records the codename of the suite that listed packages, also unknown until the lockfile is updated:
+	func(map[string]json.RawMessage) error { return nil },
+}
+
+// MigrateDpkgLockJSON decodes a lockfile and upgrades it to CurrentLockfileVersion, returning the version it was
+// written in. Fields unknown to that version are rejected, rather than silently dropped or migrated.
+func MigrateDpkgLockJSON(r io.Reader) (*DpkgLockJSON, int, error) {
+	var fields map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&fields); err != nil {
+		return nil, 0, err
+	}
+	if fields == nil {
+		return nil, 0, fmt.Errorf("lockfile is empty")
+	}
+
+	version := 1
+	if raw, ok := fields["lockfileVersion"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, 0, fmt.Errorf("parsing lockfileVersion: %w", err)
+		}
+	}
+	switch {
+	case version < 1:
+		return nil, 0, fmt.Errorf("invalid lockfileVersion %d", version)
+	case version > CurrentLockfileVersion:
+		return nil, 0, fmt.Errorf("%w %d, upgrade debendabot to read lockfiles newer than version %d", ErrUnsupportedLockfileVersion, version, CurrentLockfileVersion)
+	}
+
+	for name := range fields {
+		if since, ok := lockfileFields[name]; !ok || since > version {
+			return nil, 0, fmt.Errorf("unknown field %q in lockfile version %d", name, version)
+		}
+	}
+
+	for v := version; v < CurrentLockfileVersion; v++ {
+		if err := lockfileMigrations[v-1](fields); err != nil {
+			return nil, 0, fmt.Errorf("migrating lockfile from version %d: %w", v, err)
+		}
+	}
+	fields["lockfileVersion"] = json.RawMessage(strconv.Itoa(CurrentLockfileVersion))
+
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, 0, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	var d DpkgLockJSON
+	if err := dec.Decode(&d); err != nil {
+		return nil, 0, err
+	}
+	return &d, version, nil
+}
@@ -0,0 +1,59 @@
+package manifest_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+func TestMigrateDpkgLockJSON(t *testing.T) {
+	cases := map[string]int{
+		`{"image":"debian","packages":{"bash":{"version":"5.0-4"}}}`:                                       1,
+		`{"lockfileVersion":1,"image":"debian","packages":{"bash":{"version":"5.0-4"}}}`:                   1,
+		`{"lockfileVersion":2,"image":"debian","packages":{"bash":{"version":"5.0-4"}}}`:                   2,
+		`{"lockfileVersion":3,"image":"debian","distro":"buster","packages":{"bash":{"version":"5.0-4"}}}`: 3,
+		`{"lockfileVersion":4,"image":"debian","packages":{"bash":{"version":"5.0-4","direct":true}}}`:     4,
+	}
+	for lockfile, expected := range cases {
+		t.Run(lockfile, func(t *testing.T) {
+			lock, version, err := manifest.MigrateDpkgLockJSON(strings.NewReader(lockfile))
+			require.NoError(t, err)
+			assert.Equal(t, expected, version)
+			assert.Equal(t, manifest.CurrentLockfileVersion, lock.LockfileVersion)
+			assert.Equal(t, "debian", lock.Image)
+			assert.Equal(t, "5.0-4", lock.Packages["bash"].Version)
+		})
+	}
+}
+
+func TestMigrateDpkgLockJSON_Newer(t *testing.T) {
+	_, _, err := manifest.MigrateDpkgLockJSON(strings.NewReader(`{"lockfileVersion":7,"image":"debian"}`))
+	assert.True(t, errors.Is(err, manifest.ErrUnsupportedLockfileVersion))
+}
+
+func TestMigrateDpkgLockJSON_UnknownFields(t *testing.T) {
+	cases := map[string]string{
+		`{"image":"debian","unknown":true}`:                                  `unknown field "unknown" in lockfile version 1`,
+		`{"lockfileVersion":2,"image":"debian","distro":"buster"}`:           `unknown field "distro" in lockfile version 2`,
+		`{"lockfileVersion":3,"image":"debian","manifestHash":"sha256:abc"}`: `unknown field "manifestHash" in lockfile version 3`,
+	}
+	for lockfile, expected := range cases {
+		_, _, err := manifest.MigrateDpkgLockJSON(strings.NewReader(lockfile))
+		assert.EqualError(t, err, expected, lockfile)
+	}
+}
+
+func TestParseDpkgLockJSON_UnknownFields(t *testing.T) {
+	cases := []string{
+		`{"image":"debian","packages":{"bash":{"version":"5.0-4","unknown":true}}}`,
+		`{"image":"debian","rootfs":{"amd64":{"tarball":"sha256:abc","unknown":true}}}`,
+	}
+	for _, lockfile := range cases {
+		_, err := manifest.ParseDpkgLockJSON(strings.NewReader(lockfile))
+		assert.EqualError(t, err, `json: unknown field "unknown"`, lockfile)
+	}
+}
@@ -0,0 +1,132 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+)
+
+// jsonPositions locates the values and object keys of a JSON document, by path.
+type jsonPositions struct {
+	src    []byte
+	values map[string]int
+	keys   map[string]int
+	// fields are the keys of each object, in document order.
+	fields map[string][]string
+}
+
+func readPositions(src []byte) (*jsonPositions, error) {
+	p := &jsonPositions{
+		src:    src,
+		values: map[string]int{},
+		keys:   map[string]int{},
+		fields: map[string][]string{},
+	}
+	if err := p.readValue(json.NewDecoder(bytes.NewReader(src)), "$"); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *jsonPositions) readValue(dec *json.Decoder, path string) error {
+	p.values[path] = p.skipSeparators(dec.InputOffset())
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			offset := p.skipSeparators(dec.InputOffset())
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, _ := tok.(string)
+			child := jsonPath(path, key)
+			p.keys[child] = offset
+			p.fields[path] = append(p.fields[path], key)
+			if err := p.readValue(dec, child); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := p.readValue(dec, jsonIndex(path, i)); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	}
+	return err
+}
+
+// skipSeparators returns the offset of the next token, as InputOffset includes whitespace, colons and commas.
+func (p *jsonPositions) skipSeparators(offset int64) int {
+	i := int(offset)
+	for i < len(p.src) && bytes.IndexByte([]byte(" \t\r\n:,"), p.src[i]) >= 0 {
+		i++
+	}
+	return i
+}
+
+// position returns the line and column of a path's key, else its value, else its nearest parent.
+func (p *jsonPositions) position(path string, key bool) (int, int) {
+	if p == nil {
+		return 0, 0
+	}
+	offset, ok := p.keys[path]
+	if !key || !ok {
+		offset, ok = p.values[path]
+	}
+	if !ok {
+		if parent := parentPath(path); parent != path {
+			return p.position(parent, key)
+		}
+		return 0, 0
+	}
+	return offsetPosition(p.src, offset)
+}
+
+// offsetPosition converts a byte offset to a 1-based line and column.
+func offsetPosition(src []byte, offset int) (int, int) {
+	if offset < 0 {
+		offset = 0
+	} else if offset > len(src) {
+		offset = len(src)
+	}
+	before := src[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := utf8.RuneCount(before[bytes.LastIndexByte(before, '\n')+1:]) + 1
+	return line, column
+}
+
+var jsonIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+// jsonPath returns the path of an object's key, e.g. `$.packages.zsh` or `$.packages["libstdc++6"]`.
+func jsonPath(parent, key string) string {
+	if jsonIdentifier.MatchString(key) {
+		return parent + "." + key
+	}
+	return fmt.Sprintf("%s[%s]", parent, strconv.Quote(key))
+}
+
+// jsonIndex returns the path of an array's element, e.g. `$.repositories[0]`.
+func jsonIndex(parent string, i int) string {
+	return fmt.Sprintf("%s[%d]", parent, i)
+}
+
+var jsonPathLast = regexp.MustCompile(`(\.[A-Za-z_$][A-Za-z0-9_$]*|\[[0-9]+\]|\["(?:[^"\\]|\\.)*"\])$`)
+
+// parentPath returns the path of the object or array containing path, or path itself if it is the root.
+func parentPath(path string) string {
+	loc := jsonPathLast.FindStringIndex(path)
+	if loc == nil {
+		return path
+	}
+	return path[:loc[0]]
+}
@@ -0,0 +1,359 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	"github.com/thepwagner/debendabot/dpkg"
+)
+
+// Problem is an invalid value in dpkg.json or the lockfile.
+type Problem struct {
+	// File is the manifest or lockfile path, empty if unknown.
+	File string `json:"file,omitempty"`
+	// Path locates the value within the file, e.g. `$.packages.zsh`.
+	Path string `json:"path,omitempty"`
+	// Line and Column locate the value, if the file was parsed from source.
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error formats the problem like a compiler error, e.g. `dpkg.json:3:13: $.distro: unknown distro "bustr"`.
+func (p Problem) Error() string {
+	var b strings.Builder
+	if p.File != "" {
+		b.WriteString(p.File)
+		b.WriteString(":")
+	}
+	if p.Line > 0 {
+		_, _ = fmt.Fprintf(&b, "%d:%d:", p.Line, p.Column)
+	}
+	if b.Len() > 0 {
+		b.WriteString(" ")
+	}
+	if p.Path != "" {
+		b.WriteString(p.Path)
+		b.WriteString(": ")
+	}
+	b.WriteString(p.Message)
+	return b.String()
+}
+
+// KnownDistros are the Debian suites dpkg.json can build, by codename or alias.
+var KnownDistros = []string{
+	"jessie", "stretch", "buster", "bullseye", "bookworm", "trixie", "forky", "sid",
+	"oldoldstable", "oldstable", "stable", "testing", "unstable",
+}
+
+// KnownArchitectures are the Debian architectures dpkg.json can build.
+var KnownArchitectures = []string{
+	"amd64", "arm64", "armel", "armhf", "i386", "mips64el", "mipsel", "ppc64el", "s390x",
+}
+
+// packageNamePattern is from Debian policy 5.6.1.
+var packageNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+$`)
+
+// RepositoryNamePattern restricts names to those safe in file names and shell words.
+var RepositoryNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// FingerprintPattern matches key IDs and fingerprints, as normalized by Repository.KeyFingerprint.
+var FingerprintPattern = regexp.MustCompile(`^([0-9A-F]{8}|[0-9A-F]{16}|[0-9A-F]{40})$`)
+
+// ValidSourcesField returns true if a repository URI, suite, component or architecture is a single word
+// of a sources.list line.
+func ValidSourcesField(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r <= ' ' || r == 0x7f || r == '[' || r == ']' {
+			return false
+		}
+	}
+	return true
+}
+
+// problems collects the problems of a document, locating them if the source is known.
+type problems struct {
+	positions *jsonPositions
+	list      []Problem
+}
+
+// add records a problem with a value.
+func (p *problems) add(path, format string, args ...interface{}) {
+	p.addAt(path, false, format, args...)
+}
+
+// addKey records a problem with an object key, e.g. an invalid package name.
+func (p *problems) addKey(path, format string, args ...interface{}) {
+	p.addAt(path, true, format, args...)
+}
+
+func (p *problems) addAt(path string, key bool, format string, args ...interface{}) {
+	line, column := p.positions.position(path, key)
+	p.list = append(p.list, Problem{
+		Path:    path,
+		Line:    line,
+		Column:  column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Validate returns every problem with dpkg.json, ordered by position if it was parsed from source.
+func (d DpkgJSON) Validate() []Problem {
+	p := &problems{}
+	if d.source != nil {
+		// The source was already decoded, so this can't fail:
+		p.positions, _ = readPositions(d.source)
+		p.unknownFields("$", DpkgJSON{}, "$schema")
+		p.unknownFields("$.config", ImageConfig{})
+		for i := range d.Repositories {
+			p.unknownFields(jsonIndex("$.repositories", i), Repository{})
+		}
+	}
+
+	if d.Image == "" {
+		p.add("$.image", "image is required")
+	} else if _, err := reference.ParseNormalizedNamed(d.Image); err != nil {
+		p.add("$.image", "invalid image reference %q: %v", d.Image, err)
+	}
+
+	if d.Distro == "" {
+		p.add("$.distro", "distro is required")
+	} else if !contains(KnownDistros, d.Distro) {
+		p.add("$.distro", "unknown distro %q, expected one of %s", d.Distro, strings.Join(KnownDistros, ", "))
+	}
+
+	for _, name := range sortedPackageNames(d.Packages) {
+		pkgPath := jsonPath("$.packages", string(name))
+		if !packageNamePattern.MatchString(string(name)) {
+			p.addKey(pkgPath, "invalid package name %q, must match %s", name, packageNamePattern)
+		}
+		if version := d.Packages[name]; !version.IsSuite() {
+			if _, err := version.Constraint(); err != nil {
+				p.add(pkgPath, "invalid version %q: %v", version, err)
+			}
+		}
+	}
+
+	p.architectures("$.architectures", d.Architectures)
+	if d.Mirror != "" {
+		p.url("$.mirror", d.Mirror)
+		if d.Snapshot {
+			p.add("$.mirror", "mirror can't be combined with snapshot, which builds from snapshot.debian.org")
+		}
+	}
+
+	repoNames := map[string]bool{}
+	for i, repo := range d.Repositories {
+		repoPath := jsonIndex("$.repositories", i)
+		switch {
+		case repo.Name == "":
+			p.add(repoPath+".name", "repository name is required")
+		case !RepositoryNamePattern.MatchString(repo.Name):
+			p.add(repoPath+".name", "invalid repository name %q, must match %s", repo.Name, RepositoryNamePattern)
+		case repoNames[repo.Name]:
+			p.add(repoPath+".name", "duplicate repository name %q", repo.Name)
+		}
+		repoNames[repo.Name] = true
+
+		switch {
+		case repo.URI == "":
+			p.add(repoPath+".uri", "repository uri is required")
+		case !ValidSourcesField(repo.URI):
+			p.add(repoPath+".uri", "invalid URL %q, must not contain whitespace or brackets", repo.URI)
+		default:
+			p.url(repoPath+".uri", repo.URI)
+		}
+		switch {
+		case repo.Suite == "":
+			p.add(repoPath+".suite", "repository suite is required")
+		case !ValidSourcesField(repo.Suite):
+			p.add(repoPath+".suite", "invalid suite %q, must not contain whitespace or brackets", repo.Suite)
+		case len(repo.Components) == 0 && !strings.HasSuffix(repo.Suite, "/"):
+			p.add(repoPath+".components", "repository components are required, unless the suite is a flat repository path ending in /")
+		}
+		for j, component := range repo.Components {
+			if !ValidSourcesField(component) {
+				p.add(jsonIndex(repoPath+".components", j), "invalid component %q, must not contain whitespace or brackets", component)
+			}
+		}
+		p.architectures(repoPath+".architectures", repo.Architectures)
+		if repo.Key == "" && repo.Fingerprint == "" {
+			p.add(repoPath, "repository requires a key or fingerprint")
+		} else if fpr := repo.KeyFingerprint(); fpr != "" && !FingerprintPattern.MatchString(fpr) {
+			p.add(repoPath+".fingerprint", "invalid fingerprint %q, expected a hex key ID or fingerprint", repo.Fingerprint)
+		}
+	}
+
+	if d.Config != nil {
+		for i, env := range d.Config.Env {
+			if !strings.Contains(env, "=") {
+				p.add(jsonIndex("$.config.env", i), "invalid environment variable %q, expected NAME=value", env)
+			}
+		}
+		if wd := d.Config.WorkingDir; wd != "" && !path.IsAbs(wd) {
+			p.add("$.config.workingDir", "working directory %q must be absolute", wd)
+		}
+	}
+
+	p.sort()
+	return p.list
+}
+
+// unknownFields reports keys of an object that don't decode into a field of v.
+func (p *problems) unknownFields(objPath string, v interface{}, allowed ...string) {
+	known := map[string]bool{}
+	for _, name := range allowed {
+		known[name] = true
+	}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+	for _, key := range p.positions.fields[objPath] {
+		if !known[key] {
+			p.addKey(jsonPath(objPath, key), "unknown field %q", key)
+		}
+	}
+}
+
+func (p *problems) architectures(listPath string, archs []string) {
+	seen := map[string]bool{}
+	for i, arch := range archs {
+		switch {
+		case !contains(KnownArchitectures, arch):
+			p.add(jsonIndex(listPath, i), "unknown architecture %q, expected one of %s", arch, strings.Join(KnownArchitectures, ", "))
+		case seen[arch]:
+			p.add(jsonIndex(listPath, i), "duplicate architecture %q", arch)
+		}
+		seen[arch] = true
+	}
+}
+
+func (p *problems) url(valuePath, s string) {
+	u, err := url.Parse(s)
+	if err != nil {
+		p.add(valuePath, "invalid URL %q: %v", s, err)
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		p.add(valuePath, "invalid URL %q, expected http or https", s)
+	}
+}
+
+// sort orders located problems by position, after which unlocated problems keep their order.
+func (p *problems) sort() {
+	sort.SliceStable(p.list, func(i, j int) bool {
+		a, b := p.list[i], p.list[j]
+		if a.Line == 0 || b.Line == 0 {
+			return a.Line != 0 && b.Line == 0
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
+
+// Validate returns every problem with dpkg.json and the lockfile.
+func (m *Manifest) Validate() []Problem {
+	ret := m.DpkgJSON.Validate()
+	for i := range ret {
+		ret[i].File = m.manifestPath
+	}
+
+	if m.DpkgLockJSON == nil {
+		return ret
+	}
+	p := &problems{}
+	for _, arch := range sortedArchitectures(m.DpkgLockJSON) {
+		archPath := "$.packages"
+		if m.DpkgLockJSON.Architectures != nil {
+			archPath = jsonPath("$.architectures", arch)
+		}
+		pkgs := m.DpkgLockJSON.PackagesFor(arch)
+		for _, name := range sortedLockedNames(pkgs) {
+			if _, err := dpkg.ParseVersion(pkgs[name].Version); err != nil {
+				p.add(jsonPath(jsonPath(archPath, string(name)), "version"), "invalid version %q: %v", pkgs[name].Version, err)
+			}
+		}
+	}
+	for _, problem := range p.list {
+		problem.File = m.lockfilePath
+		ret = append(ret, problem)
+	}
+	return ret
+}
+
+// decodeProblem locates a JSON decoding error within the source, or returns the error unchanged.
+func decodeProblem(src []byte, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		// The offset follows the invalid character:
+		line, column := offsetPosition(src, int(syntaxErr.Offset)-1)
+		return Problem{Line: line, Column: column, Message: syntaxErr.Error()}
+	case errors.As(err, &typeErr):
+		ret := Problem{Message: fmt.Sprintf("expected %s, got %s", typeErr.Type, typeErr.Value)}
+		// The offset follows the invalid value, so prefer the start of the value:
+		ret.Line, ret.Column = offsetPosition(src, int(typeErr.Offset))
+		if typeErr.Field != "" {
+			ret.Path = "$." + typeErr.Field
+			if positions, err := readPositions(src); err == nil {
+				ret.Line, ret.Column = positions.position(ret.Path, false)
+			}
+		}
+		return ret
+	}
+	return err
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedPackageNames(pkgs map[PackageName]PackageVersion) []PackageName {
+	names := make([]PackageName, 0, len(pkgs))
+	for name := range pkgs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+func sortedLockedNames(pkgs map[PackageName]LockedPackage) []PackageName {
+	names := make([]PackageName, 0, len(pkgs))
+	for name := range pkgs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+func sortedArchitectures(lock *DpkgLockJSON) []string {
+	all := lock.AllPackages()
+	archs := make([]string, 0, len(all))
+	for arch := range all {
+		archs = append(archs, arch)
+	}
+	sort.Strings(archs)
+	return archs
+}
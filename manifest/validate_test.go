@@ -0,0 +1,126 @@
+package manifest_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+func TestDpkgJSON_Validate(t *testing.T) {
+	d, err := manifest.ParseDpkgJSON(strings.NewReader(`{
+  "$schema": "../dpkg.schema.json",
+  "image": "thepwagner/zsh",
+  "distro": "bustr",
+  "pacakges": {},
+  "packages": {
+    "zsh": "stable",
+    "Bad_Name": ">= 1.0",
+    "libstdc++6": ">> ??"
+  },
+  "architectures": ["amd64", "amd64", "x86"],
+  "repositories": [
+    {"name": "docker", "uri": "https://download.docker.com/linux/debian", "suite": "buster", "components": ["stable"], "fingerprint": "0EBFCD88"},
+    {"name": "docker", "uri": "ftp://example.com", "suite": "buster", "key": "test"}
+  ],
+  "config": {"env": ["FOO"], "workingDir": "app"}
+}`))
+	require.NoError(t, err)
+
+	var actual []string
+	for _, p := range d.Validate() {
+		actual = append(actual, p.Error())
+	}
+	assert.Equal(t, []string{
+		`4:13: $.distro: unknown distro "bustr", expected one of jessie, stretch, buster, bullseye, bookworm, trixie, forky, sid, oldoldstable, oldstable, stable, testing, unstable`,
+		`5:3: $.pacakges: unknown field "pacakges"`,
+		`8:5: $.packages.Bad_Name: invalid package name "Bad_Name", must match ^[a-z0-9][a-z0-9+.-]+$`,
+		`9:19: $.packages["libstdc++6"]: invalid version ">> ??": version "??" must start with a digit`,
+		`11:30: $.architectures[1]: duplicate architecture "amd64"`,
+		`11:39: $.architectures[2]: unknown architecture "x86", expected one of amd64, arm64, armel, armhf, i386, mips64el, mipsel, ppc64el, s390x`,
+		`14:5: $.repositories[1].components: repository components are required, unless the suite is a flat repository path ending in /`,
+		`14:14: $.repositories[1].name: duplicate repository name "docker"`,
+		`14:31: $.repositories[1].uri: invalid URL "ftp://example.com", expected http or https`,
+		`16:22: $.config.env[0]: invalid environment variable "FOO", expected NAME=value`,
+		`16:44: $.config.workingDir: working directory "app" must be absolute`,
+	}, actual)
+}
+
+func TestDpkgJSON_Validate_Required(t *testing.T) {
+	problems := manifest.DpkgJSON{}.Validate()
+	assert.Equal(t, []manifest.Problem{
+		{Path: "$.image", Message: "image is required"},
+		{Path: "$.distro", Message: "distro is required"},
+	}, problems)
+}
+
+func TestDpkgJSON_Validate_SnapshotMirror(t *testing.T) {
+	problems := manifest.DpkgJSON{Image: "test", Distro: "buster", Mirror: "http://ftp.us.debian.org/debian", Snapshot: true}.Validate()
+	assert.Equal(t, []manifest.Problem{
+		{Path: "$.mirror", Message: "mirror can't be combined with snapshot, which builds from snapshot.debian.org"},
+	}, problems)
+}
+
+func TestDpkgJSON_Validate_Repositories(t *testing.T) {
+	d := manifest.DpkgJSON{
+		Image:  "test",
+		Distro: "buster",
+		Repositories: []manifest.Repository{{
+			Name:        "docker",
+			URI:         "https://download.docker.com/linux/debian",
+			Suite:       "buster main",
+			Components:  []string{"stable", "[trusted=yes]"},
+			Fingerprint: "$(id)",
+		}},
+	}
+	var actual []string
+	for _, p := range d.Validate() {
+		actual = append(actual, p.Error())
+	}
+	assert.Equal(t, []string{
+		`$.repositories[0].suite: invalid suite "buster main", must not contain whitespace or brackets`,
+		`$.repositories[0].components[1]: invalid component "[trusted=yes]", must not contain whitespace or brackets`,
+		`$.repositories[0].fingerprint: invalid fingerprint "$(id)", expected a hex key ID or fingerprint`,
+	}, actual)
+}
+
+func TestParseDpkgJSON_Problem(t *testing.T) {
+	cases := map[string]string{
+		"{\n  \"image\": \"x\",\n}":       `3:1: invalid character '}' looking for beginning of object key string`,
+		"{\n  \"image\": 1\n}":            `2:12: $.image: expected string, got number`,
+		"{\n  \"packages\": [\"zsh\"]\n}": `2:15: $.packages: expected map[manifest.PackageName]manifest.PackageVersion, got array`,
+	}
+	for src, expected := range cases {
+		_, err := manifest.ParseDpkgJSON(strings.NewReader(src))
+		var problem manifest.Problem
+		require.True(t, errors.As(err, &problem), src)
+		assert.Equal(t, expected, problem.Error())
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	b, err := ioutil.ReadFile("../dpkg.schema.json")
+	require.NoError(t, err)
+	var schema struct {
+		Properties struct {
+			Distro struct {
+				Enum []string `json:"enum"`
+			} `json:"distro"`
+		} `json:"properties"`
+		Definitions struct {
+			Architectures struct {
+				Items struct {
+					Enum []string `json:"enum"`
+				} `json:"items"`
+			} `json:"architectures"`
+		} `json:"definitions"`
+	}
+	require.NoError(t, json.Unmarshal(b, &schema))
+	assert.Equal(t, manifest.KnownDistros, schema.Properties.Distro.Enum)
+	assert.Equal(t, manifest.KnownArchitectures, schema.Definitions.Architectures.Items.Enum)
+}
@@ -0,0 +1,290 @@
+// Package oci writes rootfs tarballs as OCI image layouts.
+package oci
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+// Image is a single-platform image within a layout.
+type Image struct {
+	// Platform is the docker platform of the image, e.g. "linux/arm/v7".
+	Platform string
+	// Layer is the path of a rootfs tarball, which becomes the image's only layer.
+	Layer  string
+	Config manifest.ImageConfig
+	// Tag is the reference `docker load` tags the image as.
+	Tag string
+	// Annotations are added to the image manifest.
+	Annotations map[string]string
+}
+
+// containerdImageName annotates the full reference of an image, as `docker load` and containerd expect.
+const containerdImageName = "io.containerd.image.name"
+
+// WriteLayout writes images to dir as an OCI image layout referenced by name, returning the digest of the top level
+// manifest. Multiple images are written as a multi-platform index.
+// A docker-archive manifest.json is included, so `docker load` can read the layout.
+func WriteLayout(dir, name string, images []Image) (digest.Digest, error) {
+	if len(images) == 0 {
+		return "", fmt.Errorf("no images to write")
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", string(digest.SHA256)), 0755); err != nil {
+		return "", err
+	}
+
+	manifests := make([]v1.Descriptor, 0, len(images))
+	dockerManifests := make([]dockerManifest, 0, len(images))
+	for _, img := range images {
+		desc, dm, err := writeImage(dir, img)
+		if err != nil {
+			return "", err
+		}
+		manifests = append(manifests, desc)
+		dockerManifests = append(dockerManifests, dm)
+	}
+
+	top := manifests[0]
+	if len(manifests) > 1 {
+		desc, err := writeJSONBlob(dir, v1.MediaTypeImageIndex, v1.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			Manifests: manifests,
+		})
+		if err != nil {
+			return "", err
+		}
+		top = desc
+	}
+	top.Platform = nil
+	top.Annotations = map[string]string{
+		v1.AnnotationRefName: refName(name),
+		containerdImageName:  dockerTag(name),
+	}
+
+	if err := writeJSON(filepath.Join(dir, "index.json"), v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: []v1.Descriptor{top},
+	}); err != nil {
+		return "", err
+	}
+	if err := writeJSON(filepath.Join(dir, v1.ImageLayoutFile), v1.ImageLayout{Version: v1.ImageLayoutVersion}); err != nil {
+		return "", err
+	}
+	if err := writeJSON(filepath.Join(dir, "manifest.json"), dockerManifests); err != nil {
+		return "", err
+	}
+	return top.Digest, nil
+}
+
+// dockerManifest is an entry in the manifest.json of a `docker save` archive.
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+func writeImage(dir string, img Image) (v1.Descriptor, dockerManifest, error) {
+	platform, err := parsePlatform(img.Platform)
+	if err != nil {
+		return v1.Descriptor{}, dockerManifest{}, err
+	}
+
+	layer, diffID, err := writeLayer(dir, img.Layer)
+	if err != nil {
+		return v1.Descriptor{}, dockerManifest{}, fmt.Errorf("writing layer %q: %w", img.Layer, err)
+	}
+
+	config, err := writeJSONBlob(dir, v1.MediaTypeImageConfig, v1.Image{
+		Architecture: platform.Architecture,
+		OS:           platform.OS,
+		Config: v1.ImageConfig{
+			User:       img.Config.User,
+			Env:        img.Config.Env,
+			Entrypoint: img.Config.Entrypoint,
+			Cmd:        img.Config.Cmd,
+			WorkingDir: img.Config.WorkingDir,
+			Labels:     img.Config.Labels,
+		},
+		RootFS: v1.RootFS{
+			Type:    "layers",
+			DiffIDs: []digest.Digest{diffID},
+		},
+	})
+	if err != nil {
+		return v1.Descriptor{}, dockerManifest{}, err
+	}
+
+	desc, err := writeJSONBlob(dir, v1.MediaTypeImageManifest, v1.Manifest{
+		Versioned:   specs.Versioned{SchemaVersion: 2},
+		Config:      config,
+		Layers:      []v1.Descriptor{layer},
+		Annotations: img.Annotations,
+	})
+	if err != nil {
+		return v1.Descriptor{}, dockerManifest{}, err
+	}
+	desc.Platform = &platform
+
+	dm := dockerManifest{
+		Config:   blobPath(config.Digest),
+		RepoTags: []string{dockerTag(img.Tag)},
+		Layers:   []string{blobPath(layer.Digest)},
+	}
+	return desc, dm, nil
+}
+
+// writeLayer compresses a tarball into a blob, returning its descriptor and the digest of the uncompressed tarball.
+func writeLayer(dir, path string) (v1.Descriptor, digest.Digest, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return v1.Descriptor{}, "", err
+	}
+	defer in.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Join(dir, "blobs"), "layer")
+	if err != nil {
+		return v1.Descriptor{}, "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	compressed := digest.SHA256.Digester()
+	uncompressed := digest.SHA256.Digester()
+	counter := &countingWriter{w: io.MultiWriter(tmp, compressed.Hash())}
+	// The gzip header omits the name and mtime, so the blob only depends on the tarball:
+	gz := gzip.NewWriter(counter)
+	if _, err := io.Copy(io.MultiWriter(gz, uncompressed.Hash()), in); err != nil {
+		return v1.Descriptor{}, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return v1.Descriptor{}, "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return v1.Descriptor{}, "", err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, blobPath(compressed.Digest()))); err != nil {
+		return v1.Descriptor{}, "", err
+	}
+	return v1.Descriptor{
+		MediaType: v1.MediaTypeImageLayerGzip,
+		Digest:    compressed.Digest(),
+		Size:      counter.n,
+	}, uncompressed.Digest(), nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func writeJSONBlob(dir, mediaType string, v interface{}) (v1.Descriptor, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	d := digest.FromBytes(b)
+	if err := ioutil.WriteFile(filepath.Join(dir, blobPath(d)), b, 0644); err != nil {
+		return v1.Descriptor{}, err
+	}
+	return v1.Descriptor{
+		MediaType: mediaType,
+		Digest:    d,
+		Size:      int64(len(b)),
+	}, nil
+}
+
+func writeJSON(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+func blobPath(d digest.Digest) string {
+	return filepath.ToSlash(filepath.Join("blobs", string(d.Algorithm()), d.Hex()))
+}
+
+// parsePlatform parses a docker platform, e.g. "linux/arm/v7".
+func parsePlatform(s string) (v1.Platform, error) {
+	parts := strings.Split(s, "/")
+	switch len(parts) {
+	case 2:
+		return v1.Platform{OS: parts[0], Architecture: parts[1]}, nil
+	case 3:
+		return v1.Platform{OS: parts[0], Architecture: parts[1], Variant: parts[2]}, nil
+	}
+	return v1.Platform{}, fmt.Errorf("invalid platform %q", s)
+}
+
+// dockerTag adds the default tag to untagged references, which `docker load` requires.
+func dockerTag(ref string) string {
+	if strings.LastIndex(ref, ":") > strings.LastIndex(ref, "/") {
+		return ref
+	}
+	return ref + ":latest"
+}
+
+// refName returns the tag of a reference, which the OCI layout uses to name it.
+func refName(ref string) string {
+	tagged := dockerTag(ref)
+	return tagged[strings.LastIndex(tagged, ":")+1:]
+}
+
+// Archive writes a layout directory as a tarball, in lexical order, e.g. for `docker load`.
+func Archive(dir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
@@ -0,0 +1,115 @@
+package oci_test
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/manifest"
+	"github.com/thepwagner/debendabot/oci"
+)
+
+func writeTarball(t *testing.T, path string) digest.Digest {
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "./etc/hostname", Mode: 0644, Size: 5}))
+	_, err = tw.Write([]byte("test\n"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, f.Close())
+
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	return digest.FromBytes(b)
+}
+
+func readJSON(t *testing.T, path string, v interface{}) {
+	b, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, v))
+}
+
+func readBlob(t *testing.T, layout string, d digest.Digest, v interface{}) {
+	readJSON(t, filepath.Join(layout, "blobs", string(d.Algorithm()), d.Hex()), v)
+}
+
+func TestWriteLayout(t *testing.T) {
+	dir := t.TempDir()
+	tarball := filepath.Join(dir, "image.tar")
+	diffID := writeTarball(t, tarball)
+
+	images := []oci.Image{{
+		Platform: "linux/amd64",
+		Layer:    tarball,
+		Config:   manifest.DpkgJSON{}.ImageConfig(),
+		Tag:      "thepwagner/zsh",
+		Annotations: map[string]string{
+			"io.github.thepwagner.debendabot.version": "test",
+		},
+	}}
+	layout := filepath.Join(dir, "oci")
+	d, err := oci.WriteLayout(layout, "thepwagner/zsh", images)
+	require.NoError(t, err)
+
+	var index v1.Index
+	readJSON(t, filepath.Join(layout, "index.json"), &index)
+	require.Len(t, index.Manifests, 1)
+	assert.Equal(t, d, index.Manifests[0].Digest)
+	assert.Equal(t, "latest", index.Manifests[0].Annotations[v1.AnnotationRefName])
+
+	var m v1.Manifest
+	readBlob(t, layout, d, &m)
+	require.Len(t, m.Layers, 1)
+	assert.Equal(t, v1.MediaTypeImageLayerGzip, m.Layers[0].MediaType)
+	assert.Equal(t, "test", m.Annotations["io.github.thepwagner.debendabot.version"])
+
+	var cfg v1.Image
+	readBlob(t, layout, m.Config.Digest, &cfg)
+	assert.Equal(t, "amd64", cfg.Architecture)
+	assert.Equal(t, "linux", cfg.OS)
+	assert.Equal(t, manifest.DefaultCmd, cfg.Config.Cmd)
+	assert.Equal(t, []digest.Digest{diffID}, cfg.RootFS.DiffIDs)
+
+	var dockerManifests []struct{ RepoTags []string }
+	readJSON(t, filepath.Join(layout, "manifest.json"), &dockerManifests)
+	require.Len(t, dockerManifests, 1)
+	assert.Equal(t, []string{"thepwagner/zsh:latest"}, dockerManifests[0].RepoTags)
+
+	// Identical inputs produce an identical image:
+	again, err := oci.WriteLayout(filepath.Join(dir, "again"), "thepwagner/zsh", images)
+	require.NoError(t, err)
+	assert.Equal(t, d, again)
+}
+
+func TestWriteLayout_MultiPlatform(t *testing.T) {
+	dir := t.TempDir()
+	tarball := filepath.Join(dir, "image.tar")
+	writeTarball(t, tarball)
+
+	layout := filepath.Join(dir, "oci")
+	d, err := oci.WriteLayout(layout, "thepwagner/zsh:1.0", []oci.Image{
+		{Platform: "linux/amd64", Layer: tarball, Tag: "thepwagner/zsh:1.0-amd64"},
+		{Platform: "linux/arm/v7", Layer: tarball, Tag: "thepwagner/zsh:1.0-armhf"},
+	})
+	require.NoError(t, err)
+
+	var index v1.Index
+	readJSON(t, filepath.Join(layout, "index.json"), &index)
+	require.Len(t, index.Manifests, 1)
+	assert.Equal(t, v1.MediaTypeImageIndex, index.Manifests[0].MediaType)
+	assert.Equal(t, "1.0", index.Manifests[0].Annotations[v1.AnnotationRefName])
+
+	var platforms v1.Index
+	readBlob(t, layout, d, &platforms)
+	require.Len(t, platforms.Manifests, 2)
+	assert.Equal(t, &v1.Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, platforms.Manifests[1].Platform)
+}
@@ -0,0 +1,277 @@
+// Package provenance attests how images were built, as in-toto statements with SLSA provenance predicates.
+package provenance
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/thepwagner/debendabot/build"
+	"github.com/thepwagner/debendabot/manifest"
+)
+
+const (
+	// StatementType is the in-toto statement version.
+	StatementType = "https://in-toto.io/Statement/v0.1"
+	// PredicateType is the SLSA provenance version.
+	PredicateType = "https://slsa.dev/provenance/v0.2"
+	// BuilderID identifies debendabot as the builder, suffixed by its version.
+	BuilderID = "https://github.com/thepwagner/debendabot"
+)
+
+// Statement is an in-toto statement, attesting to how its subjects were built.
+type Statement struct {
+	Type          string     `json:"_type"`
+	PredicateType string     `json:"predicateType"`
+	Subject       []Subject  `json:"subject"`
+	Predicate     Provenance `json:"predicate"`
+}
+
+// Subject is an artifact produced by the build.
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Provenance is a SLSA provenance predicate.
+type Provenance struct {
+	Builder     Builder           `json:"builder"`
+	BuildType   string            `json:"buildType"`
+	Invocation  Invocation        `json:"invocation"`
+	BuildConfig map[string]string `json:"buildConfig,omitempty"`
+	Metadata    Metadata          `json:"metadata"`
+	Materials   []Material        `json:"materials"`
+}
+
+type Builder struct {
+	ID string `json:"id"`
+}
+
+type Invocation struct {
+	ConfigSource ConfigSource      `json:"configSource"`
+	Parameters   map[string]string `json:"parameters,omitempty"`
+}
+
+// ConfigSource is the manifest the build was invoked with.
+type ConfigSource struct {
+	URI        string            `json:"uri"`
+	Digest     map[string]string `json:"digest"`
+	EntryPoint string            `json:"entryPoint"`
+}
+
+type Metadata struct {
+	BuildStartedOn  *time.Time   `json:"buildStartedOn,omitempty"`
+	BuildFinishedOn *time.Time   `json:"buildFinishedOn,omitempty"`
+	Completeness    Completeness `json:"completeness"`
+	Reproducible    bool         `json:"reproducible"`
+}
+
+type Completeness struct {
+	Parameters  bool `json:"parameters"`
+	Environment bool `json:"environment"`
+	Materials   bool `json:"materials"`
+}
+
+// Material is an input of the build.
+type Material struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Build is an export to attest.
+type Build struct {
+	// Tarball is the exported rootfs.
+	Tarball      string
+	Architecture string
+	Version      string
+	// Manifest and Lockfile are the contents of dpkg.json and dpkg-lock.json, named by ManifestPath and LockfilePath.
+	Manifest     []byte
+	ManifestPath string
+	Lockfile     []byte
+	LockfilePath string
+	Provenance   *build.Provenance
+	Started      time.Time
+	Finished     time.Time
+	Reproducible bool
+}
+
+// New attests to how a tarball was built from a locked manifest.
+func New(mf manifest.Manifest, b Build) (*Statement, error) {
+	subject, err := fileDigest(b.Tarball)
+	if err != nil {
+		return nil, err
+	}
+	locked := mf.DpkgLockJSON.PackagesFor(b.Architecture)
+
+	materials := make([]Material, 0, len(locked)+2)
+	materials = append(materials, Material{URI: b.LockfilePath, Digest: digestSet(digest.FromBytes(b.Lockfile))})
+	if b.Provenance.BaseImage != "" {
+		d, err := imageDigest(b.Provenance.BaseImage)
+		if err != nil {
+			return nil, err
+		}
+		materials = append(materials, Material{URI: "docker://" + b.Provenance.BaseImage, Digest: digestSet(d)})
+	}
+	names := make([]string, 0, len(locked))
+	for name := range locked {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		lock := locked[manifest.PackageName(name)]
+		url, ok := b.Provenance.Debs[manifest.PackageName(name)]
+		if !ok {
+			return nil, fmt.Errorf("package %q has no URL", name)
+		}
+		materials = append(materials, Material{URI: url, Digest: map[string]string{"sha512": lock.DebHash}})
+	}
+
+	started, finished := b.Started.UTC(), b.Finished.UTC()
+	return &Statement{
+		Type:          StatementType,
+		PredicateType: PredicateType,
+		Subject: []Subject{{
+			Name:   filepath.Base(b.Tarball),
+			Digest: digestSet(subject),
+		}},
+		Predicate: Provenance{
+			Builder:   Builder{ID: fmt.Sprintf("%s@%s", BuilderID, b.Version)},
+			BuildType: b.Provenance.BuildType,
+			Invocation: Invocation{
+				ConfigSource: ConfigSource{
+					URI:        b.ManifestPath,
+					Digest:     digestSet(digest.FromBytes(b.Manifest)),
+					EntryPoint: "export",
+				},
+				Parameters: map[string]string{"architecture": b.Architecture},
+			},
+			BuildConfig: b.Provenance.Config,
+			Metadata: Metadata{
+				BuildStartedOn:  &started,
+				BuildFinishedOn: &finished,
+				Completeness:    Completeness{Parameters: true, Materials: true},
+				Reproducible:    b.Reproducible,
+			},
+			Materials: materials,
+		},
+	}, nil
+}
+
+// imageDigest returns the digest of a pinned image reference, e.g. "debian@sha256:...".
+func imageDigest(image string) (digest.Digest, error) {
+	i := strings.LastIndex(image, "@")
+	if i < 0 {
+		return "", fmt.Errorf("base image %q is not pinned to a digest", image)
+	}
+	d, err := digest.Parse(image[i+1:])
+	if err != nil {
+		return "", fmt.Errorf("parsing base image %q: %w", image, err)
+	}
+	return d, nil
+}
+
+func digestSet(d digest.Digest) map[string]string {
+	return map[string]string{string(d.Algorithm()): d.Hex()}
+}
+
+func fileDigest(path string) (digest.Digest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return digest.SHA256.FromReader(f)
+}
+
+// Filename returns where the statement for a tarball is written, e.g. "image.tar.intoto.json".
+func Filename(tarball string) string {
+	return tarball + ".intoto.json"
+}
+
+// Write writes a statement as JSON.
+func (s *Statement) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(s)
+}
+
+// Read reads a statement, checking it is SLSA provenance.
+func Read(r io.Reader) (*Statement, error) {
+	var s Statement
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	if s.Type != StatementType {
+		return nil, fmt.Errorf("unsupported statement type %q", s.Type)
+	}
+	if s.PredicateType != PredicateType {
+		return nil, fmt.Errorf("unsupported predicate type %q", s.PredicateType)
+	}
+	return &s, nil
+}
+
+// Verify checks a tarball is a subject of the statement.
+// If the tarball embeds a lockfile, it must be the lockfile the statement lists as a material.
+func (s *Statement) Verify(tarball string) error {
+	d, err := fileDigest(tarball)
+	if err != nil {
+		return err
+	}
+	var found bool
+	for _, subject := range s.Subject {
+		if subject.Digest[string(d.Algorithm())] == d.Hex() {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s %s is not a subject of the provenance", filepath.Base(tarball), d)
+	}
+
+	embedded, err := embeddedLockfile(tarball)
+	if err != nil {
+		return err
+	}
+	if embedded == "" {
+		return nil
+	}
+	for _, m := range s.Predicate.Materials {
+		if m.Digest[string(embedded.Algorithm())] == embedded.Hex() {
+			return nil
+		}
+	}
+	return fmt.Errorf("embedded lockfile %s is not a material of the provenance", embedded)
+}
+
+// embeddedLockfile returns the digest of the lockfile embedded by export, if there is one.
+func embeddedLockfile(tarball string) (digest.Digest, error) {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	lockfile := path.Join(build.MetadataDir, manifest.LockFilename)
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return "", nil
+		} else if err != nil {
+			return "", fmt.Errorf("reading %q: %w", tarball, err)
+		}
+		if path.Clean("/"+h.Name) == lockfile && h.Typeflag == tar.TypeReg {
+			return digest.SHA256.FromReader(tr)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package provenance_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/build"
+	"github.com/thepwagner/debendabot/manifest"
+	"github.com/thepwagner/debendabot/provenance"
+)
+
+const (
+	lockfile  = `{"image":"debian@sha256:d67632d49fcae559f86bd2b685c9159d0b1e799fd1d109dfbfccfab4ea773ba5"}`
+	baseImage = "debian@sha256:d67632d49fcae559f86bd2b685c9159d0b1e799fd1d109dfbfccfab4ea773ba5"
+)
+
+func writeTarball(t *testing.T, lock string) string {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "image.tar")
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "./var/lib/debendabot/dpkg-lock.json", Mode: 0644, Size: int64(len(lock))}))
+	_, err := tw.Write([]byte(lock))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+	return path
+}
+
+func testStatement(t *testing.T, tarball string) *provenance.Statement {
+	mf := manifest.Manifest{
+		DpkgJSON: manifest.DpkgJSON{Image: "test", Distro: "buster"},
+		DpkgLockJSON: &manifest.DpkgLockJSON{
+			Image: baseImage,
+			Packages: map[manifest.PackageName]manifest.LockedPackage{
+				"hello": {Version: "1.0-1", Architecture: "all", DebFilename: "hello_1.0-1_all.deb", DebHash: "abcd"},
+			},
+		},
+	}
+	s, err := provenance.New(mf, provenance.Build{
+		Tarball:      tarball,
+		Architecture: "amd64",
+		Version:      "v1.0.0",
+		Manifest:     []byte(`{"image":"test"}`),
+		ManifestPath: manifest.Filename,
+		Lockfile:     []byte(lockfile),
+		LockfilePath: manifest.LockFilename,
+		Provenance: &build.Provenance{
+			BuildType: build.BuildTypeDocker,
+			BaseImage: baseImage,
+			Config:    map[string]string{"dockerfile": "FROM debian"},
+			Debs:      map[manifest.PackageName]string{"hello": "http://deb.debian.org/debian/pool/main/h/hello/hello_1.0-1_all.deb"},
+		},
+		Started:  time.Unix(1594944000, 0),
+		Finished: time.Unix(1594944060, 0),
+	})
+	require.NoError(t, err)
+	return s
+}
+
+func TestNew(t *testing.T) {
+	s := testStatement(t, writeTarball(t, lockfile))
+	assert.Equal(t, provenance.StatementType, s.Type)
+	require.Len(t, s.Subject, 1)
+	assert.Equal(t, "image.tar", s.Subject[0].Name)
+	assert.Equal(t, "https://github.com/thepwagner/debendabot@v1.0.0", s.Predicate.Builder.ID)
+	assert.Equal(t, []provenance.Material{
+		{URI: manifest.LockFilename, Digest: map[string]string{"sha256": "4ff08fae31203201d2ac7f963bda85a2974000e508698306c360429b75954d2c"}},
+		{URI: "docker://" + baseImage, Digest: map[string]string{"sha256": "d67632d49fcae559f86bd2b685c9159d0b1e799fd1d109dfbfccfab4ea773ba5"}},
+		{URI: "http://deb.debian.org/debian/pool/main/h/hello/hello_1.0-1_all.deb", Digest: map[string]string{"sha512": "abcd"}},
+	}, s.Predicate.Materials)
+}
+
+func TestStatement_Verify(t *testing.T) {
+	tarball := writeTarball(t, lockfile)
+	var buf bytes.Buffer
+	require.NoError(t, testStatement(t, tarball).Write(&buf))
+	s, err := provenance.Read(&buf)
+	require.NoError(t, err)
+	assert.NoError(t, s.Verify(tarball))
+
+	// A different tarball isn't a subject:
+	other := writeTarball(t, `{}`)
+	assert.Error(t, s.Verify(other))
+}
+
+func TestStatement_Verify_EmbeddedLockfile(t *testing.T) {
+	// The tarball is the subject, but embeds a lockfile that wasn't a material:
+	tarball := writeTarball(t, `{}`)
+	s := testStatement(t, tarball)
+	err := s.Verify(tarball)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "embedded lockfile")
+}
@@ -0,0 +1,70 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// File is an entry in the index of a rootfs.
+type File struct {
+	Path   string `json:"path"`
+	Type   string `json:"type"`
+	Size   int64  `json:"size"`
+	Mode   string `json:"mode"`
+	UID    int    `json:"uid"`
+	GID    int    `json:"gid"`
+	User   string `json:"user,omitempty"`
+	Group  string `json:"group,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Link is the target of symbolic and hard links.
+	Link string `json:"link,omitempty"`
+	// Packages that installed the path. Directories are often shared by many packages.
+	Packages []string `json:"packages,omitempty"`
+	// MD5Match is true if the file matches the MD5 recorded by its package, nil if none was recorded.
+	MD5Match *bool `json:"md5Match,omitempty"`
+}
+
+// Index visits every entry in a rootfs, attributing it to the packages that installed it.
+// Tarballs may list the dpkg database after the files it owns, so the rootfs is walked twice: once to read the
+// database, then to hash each entry.
+func Index(fs Rootfs, fn func(File) error) error {
+	pkgs, err := ReadPackages(fs)
+	if err != nil {
+		return fmt.Errorf("reading dpkg database: %w", err)
+	}
+
+	return fs.Walk(func(name string, h *tar.Header, r io.Reader) error {
+		f := File{
+			Path:     name,
+			Type:     fileType(h),
+			Size:     h.Size,
+			Mode:     fmt.Sprintf("%04o", h.Mode&07777),
+			UID:      h.Uid,
+			GID:      h.Gid,
+			User:     pkgs.User(h.Uid),
+			Group:    pkgs.Group(h.Gid),
+			Link:     h.Linkname,
+			Packages: pkgs.Owners[name],
+		}
+		if h.Typeflag != tar.TypeReg {
+			f.Size = 0
+			return fn(f)
+		}
+
+		sha := sha256.New()
+		md := md5.New()
+		if _, err := io.Copy(io.MultiWriter(sha, md), r); err != nil {
+			return fmt.Errorf("hashing %q: %w", name, err)
+		}
+		f.SHA256 = hex.EncodeToString(sha.Sum(nil))
+		if expected, ok := pkgs.MD5Sums[name]; ok {
+			match := hex.EncodeToString(md.Sum(nil)) == expected
+			f.MD5Match = &match
+		}
+		return fn(f)
+	})
+}
@@ -0,0 +1,63 @@
+package rootfs_test
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/rootfs"
+)
+
+func indexFiles(t *testing.T, path string) map[string]rootfs.File {
+	fs, err := rootfs.Open(path)
+	require.NoError(t, err)
+	files := map[string]rootfs.File{}
+	err = rootfs.Index(fs, func(f rootfs.File) error {
+		files[f.Path] = f
+		return nil
+	})
+	require.NoError(t, err)
+	return files
+}
+
+func TestIndex(t *testing.T) {
+	for name, path := range map[string]string{
+		"tarball": testTarball(t),
+		"dir":     testDir(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			files := indexFiles(t, path)
+
+			hello := files["/usr/bin/hello"]
+			assert.Equal(t, "file", hello.Type)
+			assert.Equal(t, "0755", hello.Mode)
+			assert.Equal(t, int64(21), hello.Size)
+			assert.Equal(t, []string{"hello"}, hello.Packages)
+			assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("#!/bin/sh\necho hello\n"))), hello.SHA256)
+			require.NotNil(t, hello.MD5Match)
+			assert.True(t, *hello.MD5Match)
+
+			conf := files["/etc/hello.conf"]
+			require.NotNil(t, conf.MD5Match)
+			assert.False(t, *conf.MD5Match)
+			assert.Equal(t, "root", conf.User)
+
+			stray := files["/tmp/stray"]
+			assert.Empty(t, stray.Packages)
+			assert.Nil(t, stray.MD5Match)
+
+			assert.Equal(t, "symlink", files["/bin"].Type)
+			assert.Equal(t, "usr/bin", files["/bin"].Link)
+			assert.Equal(t, "dir", files["/"].Type)
+			assert.Equal(t, []string{"hello"}, files["/"].Packages)
+		})
+	}
+}
+
+func TestIndex_Owner(t *testing.T) {
+	files := indexFiles(t, testTarball(t))
+	assert.Equal(t, 50, files["/usr/bin/hello"].GID)
+	assert.Equal(t, "staff", files["/usr/bin/hello"].Group)
+}
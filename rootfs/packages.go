@@ -0,0 +1,226 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/thepwagner/debendabot/dpkg"
+)
+
+const (
+	dpkgInfoDir         = "/var/lib/dpkg/info"
+	dpkgAlternativesDir = "/var/lib/dpkg/alternatives"
+	// systemdHelperDir records the links created by deb-systemd-helper, in <unit>.dsh-also files.
+	systemdHelperDir = "/var/lib/systemd/deb-systemd-helper-enabled"
+)
+
+// Packages is the dpkg metadata of a rootfs.
+type Packages struct {
+	// Owners maps paths to the packages that installed them, from /var/lib/dpkg/info/<pkg>.list.
+	Owners map[string][]string
+	// MD5Sums maps paths to their MD5 as installed, from /var/lib/dpkg/info/<pkg>.md5sums and conffiles.
+	MD5Sums map[string]string
+	// Conffiles maps configuration files to the package that installed them.
+	Conffiles map[string]string
+	// Alternatives maps the links managed by update-alternatives to their group, from /var/lib/dpkg/alternatives.
+	Alternatives map[string]string
+	// SystemdLinks maps the links managed by deb-systemd-helper to their unit, from systemdHelperDir.
+	SystemdLinks map[string]string
+
+	// installed are the packages in the dpkg status database.
+	installed map[string]bool
+	users     map[int]string
+	groups    map[int]string
+}
+
+// ReadPackages reads the dpkg database, users and groups of a rootfs.
+func ReadPackages(fs Rootfs) (*Packages, error) {
+	p := &Packages{
+		Owners:       map[string][]string{},
+		MD5Sums:      map[string]string{},
+		Conffiles:    map[string]string{},
+		Alternatives: map[string]string{},
+		SystemdLinks: map[string]string{},
+		installed:    map[string]bool{},
+		users:        map[int]string{},
+		groups:       map[int]string{},
+	}
+	err := fs.Walk(func(name string, h *tar.Header, r io.Reader) error {
+		if h.Typeflag != tar.TypeReg {
+			return nil
+		}
+		switch {
+		case name == dpkg.StatusPath:
+			return p.readStatus(r)
+		case name == "/etc/passwd":
+			return readIDs(r, p.users)
+		case name == "/etc/group":
+			return readIDs(r, p.groups)
+		case path.Dir(name) == dpkgAlternativesDir:
+			return p.readAlternative(path.Base(name), r)
+		case path.Dir(name) == systemdHelperDir && path.Ext(name) == ".dsh-also":
+			return p.readSystemdLinks(strings.TrimSuffix(path.Base(name), ".dsh-also"), r)
+		case path.Dir(name) != dpkgInfoDir:
+			return nil
+		}
+
+		pkg := infoPackage(name)
+		switch path.Ext(name) {
+		case ".list":
+			return p.readList(pkg, r)
+		case ".md5sums":
+			return p.readMD5Sums(r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, owners := range p.Owners {
+		sort.Strings(owners)
+	}
+	return p, nil
+}
+
+// ReadStatus reads the dpkg status database of a rootfs.
+func ReadStatus(fs Rootfs) ([]dpkg.Package, error) {
+	var installed []dpkg.Package
+	err := fs.Walk(func(name string, h *tar.Header, r io.Reader) error {
+		if name != dpkg.StatusPath || h.Typeflag != tar.TypeReg {
+			return nil
+		}
+		pkgs, err := dpkg.ParseStatus(r)
+		if err != nil {
+			return fmt.Errorf("parsing %q: %w", name, err)
+		}
+		installed = pkgs
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return installed, nil
+}
+
+// infoPackage returns the package of a file in dpkgInfoDir, e.g. "libc6" for "libc6:amd64.list".
+func infoPackage(name string) string {
+	pkg := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	// Multi-Arch: same packages are qualified, e.g. "libc6:amd64":
+	if i := strings.Index(pkg, ":"); i >= 0 {
+		pkg = pkg[:i]
+	}
+	return pkg
+}
+
+func (p *Packages) readList(pkg string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			name := entryName(line)
+			p.Owners[name] = append(p.Owners[name], pkg)
+		}
+	}
+	return scanner.Err()
+}
+
+// readAlternative reads the links of an alternatives group: the mode, the master link, then pairs of slave names
+// and links until a blank line. The available alternatives follow, but aren't links.
+func (p *Packages) readAlternative(group string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for line := 0; scanner.Scan(); line++ {
+		text := scanner.Text()
+		switch {
+		case text == "":
+			return nil
+		case line%2 == 1:
+			p.Alternatives[entryName(text)] = group
+		}
+	}
+	return scanner.Err()
+}
+
+// readSystemdLinks reads the links deb-systemd-helper created to enable a unit, one per line.
+func (p *Packages) readSystemdLinks(unit string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			p.SystemdLinks[entryName(line)] = unit
+		}
+	}
+	return scanner.Err()
+}
+
+func (p *Packages) readMD5Sums(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(fields) == 2 {
+			p.MD5Sums[entryName(fields[1])] = fields[0]
+		}
+	}
+	return scanner.Err()
+}
+
+func (p *Packages) readStatus(r io.Reader) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	paragraphs, err := dpkg.ParseControl(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for _, para := range paragraphs {
+		p.installed[para["Package"]] = true
+		// Conffiles lines are "<path> <md5>", optionally followed by "obsolete" or "remove-on-upgrade":
+		for _, line := range strings.Split(para["Conffiles"], "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				continue
+			}
+			name := entryName(fields[0])
+			p.Conffiles[name] = para["Package"]
+			if fields[1] != "newconffile" {
+				p.MD5Sums[name] = fields[1]
+			}
+		}
+	}
+	return nil
+}
+
+// readIDs reads names by id from /etc/passwd or /etc/group.
+func readIDs(r io.Reader, ids map[int]string) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 {
+			continue
+		}
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		if _, ok := ids[id]; !ok {
+			ids[id] = fields[0]
+		}
+	}
+	return scanner.Err()
+}
+
+// User returns the name of a user id within the rootfs.
+func (p *Packages) User(uid int) string {
+	return p.users[uid]
+}
+
+// Group returns the name of a group id within the rootfs.
+func (p *Packages) Group(gid int) string {
+	return p.groups[gid]
+}
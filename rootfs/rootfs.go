@@ -0,0 +1,119 @@
+// Package rootfs inspects built filesystems, either extracted to a directory or as a tarball.
+package rootfs
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Rootfs is a built filesystem.
+type Rootfs interface {
+	// Walk visits every entry with the contents of regular files.
+	// Names are absolute within the rootfs, e.g. "/usr/bin/bash".
+	Walk(fn func(name string, h *tar.Header, r io.Reader) error) error
+}
+
+// Open returns the rootfs at path, which is either a directory or a tarball.
+func Open(path string) (Rootfs, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return dirRootfs(path), nil
+	}
+	return tarballRootfs(path), nil
+}
+
+// entryName normalizes a path within the rootfs, e.g. "./usr/bin/" to "/usr/bin".
+func entryName(name string) string {
+	return path.Clean("/" + name)
+}
+
+// dirRootfs is a rootfs extracted to a directory, which is walked in lexical order.
+type dirRootfs string
+
+func (d dirRootfs) Walk(fn func(name string, h *tar.Header, r io.Reader) error) error {
+	root := string(d)
+	return filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		h, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		name := entryName(filepath.ToSlash(rel))
+		if !fi.Mode().IsRegular() {
+			return fn(name, h, nil)
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return fn(name, h, f)
+	})
+}
+
+// tarballRootfs is a rootfs tarball, which is walked in archive order.
+type tarballRootfs string
+
+func (t tarballRootfs) Walk(fn func(name string, h *tar.Header, r io.Reader) error) error {
+	f, err := os.Open(string(t))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading %q: %w", t, err)
+		}
+		if err := fn(entryName(h.Name), h, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// fileType describes the type of a tar entry.
+func fileType(h *tar.Header) string {
+	switch h.Typeflag {
+	case tar.TypeReg:
+		return "file"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar:
+		return "char"
+	case tar.TypeBlock:
+		return "block"
+	case tar.TypeFifo:
+		return "fifo"
+	}
+	return fmt.Sprintf("unknown-%c", h.Typeflag)
+}
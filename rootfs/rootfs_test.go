@@ -0,0 +1,82 @@
+package rootfs_test
+
+import (
+	"archive/tar"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func md5Hex(s string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(s)))
+}
+
+// testFiles is a rootfs with one package, "hello", whose conffile has been modified.
+var testFiles = []struct {
+	name    string
+	content string
+	mode    int64
+}{
+	{name: "etc/", mode: 0755},
+	{name: "etc/group", content: "root:x:0:\nstaff:x:50:\n", mode: 0644},
+	{name: "etc/hello.conf", content: "greeting=hi\n", mode: 0644},
+	{name: "etc/passwd", content: "root:x:0:0:root:/root:/bin/bash\n", mode: 0644},
+	{name: "tmp/", mode: 01777},
+	{name: "tmp/stray", content: "stray\n", mode: 0644},
+	{name: "usr/", mode: 0755},
+	{name: "usr/bin/", mode: 0755},
+	{name: "usr/bin/hello", content: "#!/bin/sh\necho hello\n", mode: 0755},
+	{name: "var/", mode: 0755},
+	{name: "var/lib/", mode: 0755},
+	{name: "var/lib/dpkg/", mode: 0755},
+	{name: "var/lib/dpkg/info/", mode: 0755},
+	{name: "var/lib/dpkg/info/hello.list", content: "/.\n/etc\n/etc/hello.conf\n/usr\n/usr/bin\n/usr/bin/hello\n", mode: 0644},
+	{name: "var/lib/dpkg/info/hello.md5sums", content: md5Hex("#!/bin/sh\necho hello\n") + "  usr/bin/hello\n", mode: 0644},
+	{name: "var/lib/dpkg/status", content: "Package: hello\nStatus: install ok installed\nVersion: 1.0-1\nConffiles:\n /etc/hello.conf " + md5Hex("greeting=hello\n") + "\n", mode: 0644},
+}
+
+func testTarball(t *testing.T) string {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "image.tar")
+
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+	tw := tar.NewWriter(f)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: "./", Mode: 0755}))
+	for _, file := range testFiles {
+		h := &tar.Header{Typeflag: tar.TypeReg, Name: "./" + file.name, Mode: file.mode, Size: int64(len(file.content))}
+		if file.name[len(file.name)-1] == '/' {
+			h.Typeflag = tar.TypeDir
+		}
+		if file.name == "usr/bin/hello" {
+			h.Gid = 50
+		}
+		require.NoError(t, tw.WriteHeader(h))
+		_, err := tw.Write([]byte(file.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "./bin", Linkname: "usr/bin"}))
+	require.NoError(t, tw.Close())
+	return path
+}
+
+func testDir(t *testing.T) string {
+	dir := t.TempDir()
+
+	for _, file := range testFiles {
+		path := filepath.Join(dir, file.name)
+		if file.name[len(file.name)-1] == '/' {
+			require.NoError(t, os.MkdirAll(path, 0755))
+			continue
+		}
+		require.NoError(t, ioutil.WriteFile(path, []byte(file.content), os.FileMode(file.mode)))
+	}
+	require.NoError(t, os.Symlink("usr/bin", filepath.Join(dir, "bin")))
+	return dir
+}
@@ -0,0 +1,78 @@
+//go:build sqlite
+
+package rootfs
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	// Registers the sqlite3 driver:
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS files (
+  image TEXT NOT NULL,
+  architecture TEXT NOT NULL,
+  path TEXT NOT NULL,
+  type TEXT NOT NULL,
+  size INTEGER NOT NULL,
+  mode TEXT NOT NULL,
+  uid INTEGER NOT NULL,
+  gid INTEGER NOT NULL,
+  user TEXT,
+  "group" TEXT,
+  sha256 TEXT,
+  link TEXT,
+  packages TEXT,
+  md5_match INTEGER,
+  PRIMARY KEY (image, architecture, path)
+);
+CREATE INDEX IF NOT EXISTS files_sha256 ON files (sha256);
+CREATE INDEX IF NOT EXISTS files_path ON files (path);
+`
+
+// WriteSQLite stores the index of an image's rootfs in a SQLite database, replacing any previous index of the image.
+// Packages are stored comma separated.
+func WriteSQLite(dbPath, image, arch string, fs Rootfs) error {
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return fmt.Errorf("opening %q: %w", dbPath, err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("creating schema: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec("DELETE FROM files WHERE image = ? AND architecture = ?", image, arch); err != nil {
+		return err
+	}
+	insert, err := tx.Prepare(`INSERT INTO files
+  (image, architecture, path, type, size, mode, uid, gid, user, "group", sha256, link, packages, md5_match)
+  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	err = Index(fs, func(f File) error {
+		_, err := insert.Exec(image, arch, f.Path, f.Type, f.Size, f.Mode, f.UID, f.GID,
+			nullString(f.User), nullString(f.Group), nullString(f.SHA256), nullString(f.Link),
+			nullString(strings.Join(f.Packages, ",")), f.MD5Match)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,13 @@
+//go:build !sqlite
+
+package rootfs
+
+import "errors"
+
+// ErrSQLiteUnsupported is returned by WriteSQLite unless debendabot is built with the sqlite tag, which requires cgo.
+var ErrSQLiteUnsupported = errors.New("SQLite output is unsupported, rebuild debendabot with -tags sqlite")
+
+// WriteSQLite stores the index of an image's rootfs in a SQLite database.
+func WriteSQLite(_, _, _ string, _ Rootfs) error {
+	return ErrSQLiteUnsupported
+}
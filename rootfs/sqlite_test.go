@@ -0,0 +1,44 @@
+//go:build sqlite
+
+package rootfs_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/rootfs"
+)
+
+func TestWriteSQLite(t *testing.T) {
+	fs, err := rootfs.Open(testTarball(t))
+	require.NoError(t, err)
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "index.db")
+
+	// Indexing again replaces the image's rows:
+	require.NoError(t, rootfs.WriteSQLite(dbPath, "test", "amd64", fs))
+	require.NoError(t, rootfs.WriteSQLite(dbPath, "test", "amd64", fs))
+	require.NoError(t, rootfs.WriteSQLite(dbPath, "other", "amd64", fs))
+
+	db, err := sql.Open("sqlite3", dbPath)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var count int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM files WHERE image = 'test'").Scan(&count))
+	assert.Equal(t, len(indexFiles(t, testTarball(t))), count)
+
+	var images []string
+	rows, err := db.Query("SELECT image FROM files WHERE path = '/usr/bin/hello' AND packages = 'hello' AND md5_match ORDER BY image")
+	require.NoError(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var image string
+		require.NoError(t, rows.Scan(&image))
+		images = append(images, image)
+	}
+	assert.Equal(t, []string{"other", "test"}, images)
+}
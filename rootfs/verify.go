@@ -0,0 +1,280 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DeviationKind is how a rootfs differs from its dpkg metadata.
+type DeviationKind string
+
+const (
+	// Modified files don't match the MD5 recorded by their package.
+	Modified DeviationKind = "modified"
+	// ModifiedConffile configuration files don't match the MD5 recorded when they were installed.
+	ModifiedConffile DeviationKind = "modified-conffile"
+	// Missing files are listed by a package, but absent.
+	Missing DeviationKind = "missing"
+	// Unowned files were not installed by any package.
+	Unowned DeviationKind = "unowned"
+)
+
+// Deviation is a difference between a rootfs and its dpkg metadata.
+type Deviation struct {
+	Kind     DeviationKind `json:"kind"`
+	Path     string        `json:"path"`
+	Packages []string      `json:"packages,omitempty"`
+	// Reason explains an expected deviation, empty if the deviation is unexpected.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Expected returns true if the deviation was declared by an Exception.
+func (d Deviation) Expected() bool {
+	return d.Reason != ""
+}
+
+// Exception declares deviations that are expected, e.g. because the build removes documentation.
+type Exception struct {
+	// Path is a file, or a directory whose contents are also covered.
+	Path   string
+	Kinds  []DeviationKind
+	Reason string
+}
+
+func (e Exception) matches(d Deviation) bool {
+	if d.Path != e.Path && !strings.HasPrefix(d.Path, strings.TrimSuffix(e.Path, "/")+"/") {
+		return false
+	}
+	for _, k := range e.Kinds {
+		if k == d.Kind {
+			return true
+		}
+	}
+	return false
+}
+
+// GeneratedFiles are created by maintainer scripts, debootstrap or the container runtime rather than shipped in a
+// package, so are expected to be unowned or modified. Files of the dpkg database and links managed by
+// update-alternatives or deb-systemd-helper are recognized by Verify, and configuration written by the build is
+// declared by its own exceptions.
+var GeneratedFiles = []Exception{
+	{Path: "/dev", Kinds: []DeviationKind{Unowned}, Reason: "device nodes are created by debootstrap"},
+	{Path: "/proc", Kinds: []DeviationKind{Unowned}, Reason: "mounted by the container runtime"},
+	{Path: "/sys", Kinds: []DeviationKind{Unowned}, Reason: "mounted by the container runtime"},
+	{Path: "/run", Kinds: []DeviationKind{Unowned}, Reason: "runtime state"},
+	{Path: "/tmp", Kinds: []DeviationKind{Unowned}, Reason: "temporary files"},
+	{Path: "/etc/passwd", Kinds: []DeviationKind{Unowned}, Reason: "user database is generated by base-passwd"},
+	{Path: "/etc/group", Kinds: []DeviationKind{Unowned}, Reason: "group database is generated by base-passwd"},
+	{Path: "/etc/shadow", Kinds: []DeviationKind{Unowned}, Reason: "user database is generated by base-passwd"},
+	{Path: "/etc/gshadow", Kinds: []DeviationKind{Unowned}, Reason: "group database is generated by base-passwd"},
+	{Path: "/etc/passwd-", Kinds: []DeviationKind{Unowned}, Reason: "backup of the user database"},
+	{Path: "/etc/group-", Kinds: []DeviationKind{Unowned}, Reason: "backup of the group database"},
+	{Path: "/etc/shadow-", Kinds: []DeviationKind{Unowned}, Reason: "backup of the user database"},
+	{Path: "/etc/gshadow-", Kinds: []DeviationKind{Unowned}, Reason: "backup of the group database"},
+	{Path: "/etc/.pwd.lock", Kinds: []DeviationKind{Unowned}, Reason: "lock file of the user database"},
+	{Path: "/etc/subuid", Kinds: []DeviationKind{Unowned}, Reason: "user database is generated by passwd"},
+	{Path: "/etc/subgid", Kinds: []DeviationKind{Unowned}, Reason: "group database is generated by passwd"},
+	{Path: "/etc/alternatives", Kinds: []DeviationKind{Unowned}, Reason: "alternatives are managed by update-alternatives"},
+	{Path: "/etc/ld.so.cache", Kinds: []DeviationKind{Unowned}, Reason: "generated by ldconfig"},
+	{Path: "/etc/machine-id", Kinds: []DeviationKind{Unowned}, Reason: "generated by systemd"},
+	{Path: "/etc/hostname", Kinds: []DeviationKind{Unowned}, Reason: "written by debootstrap and the container runtime"},
+	{Path: "/etc/hosts", Kinds: []DeviationKind{Unowned}, Reason: "written by debootstrap and the container runtime"},
+	{Path: "/etc/resolv.conf", Kinds: []DeviationKind{Unowned}, Reason: "written by the container runtime"},
+	{Path: "/etc/fstab", Kinds: []DeviationKind{Unowned}, Reason: "written by debootstrap"},
+	{Path: "/etc/mtab", Kinds: []DeviationKind{Unowned}, Reason: "written by debootstrap"},
+	{Path: "/etc/localtime", Kinds: []DeviationKind{Unowned}, Reason: "configured by tzdata"},
+	{Path: "/etc/timezone", Kinds: []DeviationKind{Unowned}, Reason: "configured by tzdata"},
+	{Path: "/etc/nsswitch.conf", Kinds: []DeviationKind{Unowned}, Reason: "generated by libc-bin"},
+	{Path: "/etc/shells", Kinds: []DeviationKind{Unowned}, Reason: "maintained by add-shell"},
+	{Path: "/etc/environment", Kinds: []DeviationKind{Unowned}, Reason: "created by libpam-modules"},
+	{Path: "/etc/security/opasswd", Kinds: []DeviationKind{Unowned}, Reason: "created by libpam-modules"},
+	{Path: "/etc/pam.d/common-account", Kinds: []DeviationKind{Unowned}, Reason: "generated by pam-auth-update"},
+	{Path: "/etc/pam.d/common-auth", Kinds: []DeviationKind{Unowned}, Reason: "generated by pam-auth-update"},
+	{Path: "/etc/pam.d/common-password", Kinds: []DeviationKind{Unowned}, Reason: "generated by pam-auth-update"},
+	{Path: "/etc/pam.d/common-session", Kinds: []DeviationKind{Unowned}, Reason: "generated by pam-auth-update"},
+	{Path: "/etc/pam.d/common-session-noninteractive", Kinds: []DeviationKind{Unowned}, Reason: "generated by pam-auth-update"},
+	{Path: "/root/.bashrc", Kinds: []DeviationKind{Unowned}, Reason: "copied from base-files"},
+	{Path: "/root/.profile", Kinds: []DeviationKind{Unowned}, Reason: "copied from base-files"},
+	{Path: "/lib32", Kinds: []DeviationKind{Unowned}, Reason: "merged-/usr link is created by debootstrap"},
+	{Path: "/libx32", Kinds: []DeviationKind{Unowned}, Reason: "merged-/usr link is created by debootstrap"},
+	{Path: "/libo32", Kinds: []DeviationKind{Unowned}, Reason: "merged-/usr link is created by debootstrap"},
+	{Path: "/usr/lib32", Kinds: []DeviationKind{Unowned}, Reason: "merged-/usr directory is created by debootstrap"},
+	{Path: "/usr/libx32", Kinds: []DeviationKind{Unowned}, Reason: "merged-/usr directory is created by debootstrap"},
+	{Path: "/usr/libo32", Kinds: []DeviationKind{Unowned}, Reason: "merged-/usr directory is created by debootstrap"},
+	{Path: "/var/cache/man", Kinds: []DeviationKind{Unowned}, Reason: "generated by man-db"},
+	{Path: "/etc/ssl/certs", Kinds: []DeviationKind{Unowned}, Reason: "generated by update-ca-certificates"},
+	{Path: "/etc/rc0.d", Kinds: []DeviationKind{Unowned}, Reason: "links are managed by update-rc.d"},
+	{Path: "/etc/rc1.d", Kinds: []DeviationKind{Unowned}, Reason: "links are managed by update-rc.d"},
+	{Path: "/etc/rc2.d", Kinds: []DeviationKind{Unowned}, Reason: "links are managed by update-rc.d"},
+	{Path: "/etc/rc3.d", Kinds: []DeviationKind{Unowned}, Reason: "links are managed by update-rc.d"},
+	{Path: "/etc/rc4.d", Kinds: []DeviationKind{Unowned}, Reason: "links are managed by update-rc.d"},
+	{Path: "/etc/rc5.d", Kinds: []DeviationKind{Unowned}, Reason: "links are managed by update-rc.d"},
+	{Path: "/etc/rc6.d", Kinds: []DeviationKind{Unowned}, Reason: "links are managed by update-rc.d"},
+	{Path: "/usr/share/info/dir", Kinds: []DeviationKind{Unowned}, Reason: "generated by install-info"},
+	{Path: "/var/cache/debconf", Kinds: []DeviationKind{Unowned}, Reason: "debconf database"},
+	{Path: "/var/cache/ldconfig", Kinds: []DeviationKind{Unowned}, Reason: "generated by ldconfig"},
+	{Path: "/var/lib/apt/extended_states", Kinds: []DeviationKind{Unowned}, Reason: "apt state of automatically installed packages"},
+	{Path: "/var/lib/dpkg/status", Kinds: []DeviationKind{Unowned}, Reason: "dpkg database"},
+	{Path: "/var/lib/dpkg/status-old", Kinds: []DeviationKind{Unowned}, Reason: "backup of the dpkg database"},
+	{Path: "/var/lib/dpkg/available", Kinds: []DeviationKind{Unowned}, Reason: "dpkg database"},
+	{Path: "/var/lib/dpkg/diversions", Kinds: []DeviationKind{Unowned}, Reason: "dpkg database"},
+	{Path: "/var/lib/dpkg/diversions-old", Kinds: []DeviationKind{Unowned}, Reason: "backup of the dpkg database"},
+	{Path: "/var/lib/dpkg/statoverride", Kinds: []DeviationKind{Unowned}, Reason: "dpkg database"},
+	{Path: "/var/lib/dpkg/statoverride-old", Kinds: []DeviationKind{Unowned}, Reason: "backup of the dpkg database"},
+	{Path: "/var/lib/dpkg/arch", Kinds: []DeviationKind{Unowned}, Reason: "dpkg database"},
+	{Path: "/var/lib/dpkg/cmethopt", Kinds: []DeviationKind{Unowned}, Reason: "dpkg database"},
+	{Path: "/var/lib/dpkg/lock", Kinds: []DeviationKind{Unowned}, Reason: "lock file of the dpkg database"},
+	{Path: "/var/lib/dpkg/lock-frontend", Kinds: []DeviationKind{Unowned}, Reason: "lock file of the dpkg database"},
+	{Path: "/var/lib/dpkg/triggers", Kinds: []DeviationKind{Unowned}, Reason: "dpkg trigger state"},
+	{Path: "/var/lib/dpkg/info/format", Kinds: []DeviationKind{Unowned}, Reason: "dpkg database"},
+	{Path: "/var/lib/dpkg/alternatives", Kinds: []DeviationKind{Unowned}, Reason: "alternatives are managed by update-alternatives"},
+	{Path: "/var/lib/pam", Kinds: []DeviationKind{Unowned}, Reason: "generated by pam-auth-update"},
+	{Path: "/var/lib/systemd", Kinds: []DeviationKind{Unowned}, Reason: "systemd state"},
+	{Path: "/var/lib/ucf", Kinds: []DeviationKind{Unowned}, Reason: "ucf configuration file state"},
+	{Path: "/var/log", Kinds: []DeviationKind{Unowned}, Reason: "written by dpkg and apt"},
+}
+
+// verifyEntry is a file present in the rootfs.
+type verifyEntry struct {
+	typeflag byte
+	link     string
+	md5      string
+}
+
+// Verify compares a rootfs to its dpkg metadata, returning deviations sorted by path.
+// Deviations matching an exception are returned with its reason.
+func Verify(fs Rootfs, exceptions []Exception) ([]Deviation, error) {
+	pkgs, err := ReadPackages(fs)
+	if err != nil {
+		return nil, fmt.Errorf("reading dpkg database: %w", err)
+	}
+
+	entries := map[string]verifyEntry{}
+	err = fs.Walk(func(name string, h *tar.Header, r io.Reader) error {
+		e := verifyEntry{typeflag: h.Typeflag, link: h.Linkname}
+		if h.Typeflag == tar.TypeReg {
+			hash := md5.New()
+			if _, err := io.Copy(hash, r); err != nil {
+				return fmt.Errorf("hashing %q: %w", name, err)
+			}
+			e.md5 = hex.EncodeToString(hash.Sum(nil))
+		}
+		entries[name] = e
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var deviations []Deviation
+	// Packages may list paths via symlinked directories, e.g. /bin/bash on merged-/usr systems, which implies the links:
+	owned := map[string]bool{}
+	for name, owners := range pkgs.Owners {
+		for dir := path.Dir(name); dir != "/"; dir = path.Dir(dir) {
+			if e, ok := entries[dir]; ok && e.typeflag == tar.TypeSymlink {
+				owned[dir] = true
+				owned[resolvePath(entries, dir+"/.")] = true
+			}
+		}
+		resolved := resolvePath(entries, name)
+		if _, ok := entries[resolved]; !ok {
+			deviations = append(deviations, Deviation{Kind: Missing, Path: name, Packages: owners})
+			continue
+		}
+		owned[resolved] = true
+	}
+
+	for name, expected := range pkgs.MD5Sums {
+		e, ok := entries[resolvePath(entries, name)]
+		if !ok || e.typeflag != tar.TypeReg || e.md5 == expected {
+			continue
+		}
+		d := Deviation{Kind: Modified, Path: name, Packages: pkgs.Owners[name]}
+		if pkg, ok := pkgs.Conffiles[name]; ok {
+			d.Kind = ModifiedConffile
+			d.Packages = []string{pkg}
+		}
+		deviations = append(deviations, d)
+	}
+
+	// deb-systemd-helper creates the directories of its links, e.g. /etc/systemd/system/multi-user.target.wants:
+	systemdDirs := map[string]string{}
+	for link, unit := range pkgs.SystemdLinks {
+		for dir := path.Dir(link); dir != "/etc" && dir != "/"; dir = path.Dir(dir) {
+			systemdDirs[dir] = unit
+		}
+	}
+
+	for name, e := range entries {
+		if owned[name] {
+			continue
+		}
+		d := Deviation{Kind: Unowned, Path: name}
+		if group, ok := pkgs.Alternatives[name]; ok {
+			d.Reason = fmt.Sprintf("link is managed by update-alternatives for %s", group)
+		} else if unit, ok := pkgs.SystemdLinks[name]; ok && e.typeflag == tar.TypeSymlink {
+			d.Reason = fmt.Sprintf("link is managed by deb-systemd-helper for %s", unit)
+		} else if unit, ok := systemdDirs[name]; ok && e.typeflag == tar.TypeDir {
+			d.Reason = fmt.Sprintf("directory is created by deb-systemd-helper for %s", unit)
+		} else if pkg := infoPackage(name); path.Dir(name) == dpkgInfoDir && pkgs.installed[pkg] {
+			d.Reason = fmt.Sprintf("dpkg database of %s", pkg)
+		}
+		deviations = append(deviations, d)
+	}
+
+	for i, d := range deviations {
+		if d.Expected() {
+			continue
+		}
+		for _, e := range exceptions {
+			if e.matches(d) {
+				deviations[i].Reason = e.Reason
+				break
+			}
+		}
+	}
+	sort.Slice(deviations, func(i, j int) bool {
+		if deviations[i].Path != deviations[j].Path {
+			return deviations[i].Path < deviations[j].Path
+		}
+		return deviations[i].Kind < deviations[j].Kind
+	})
+	return deviations, nil
+}
+
+// resolvePath follows symlinks in the parent directories of name, as dpkg does when installing.
+func resolvePath(entries map[string]verifyEntry, name string) string {
+	dir, file := path.Split(name)
+	resolved := "/"
+	parts := strings.Split(dir, "/")
+	for links := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, part)
+		e, ok := entries[next]
+		if !ok || e.typeflag != tar.TypeSymlink || links > 40 {
+			resolved = next
+			continue
+		}
+		links++
+		if path.IsAbs(e.link) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(e.link, "/"), parts...)
+	}
+	return path.Join(resolved, file)
+}
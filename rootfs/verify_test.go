@@ -0,0 +1,113 @@
+package rootfs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/rootfs"
+)
+
+func TestVerify(t *testing.T) {
+	for name, path := range map[string]string{"tarball": testTarball(t), "dir": testDir(t)} {
+		t.Run(name, func(t *testing.T) {
+			fs, err := rootfs.Open(path)
+			require.NoError(t, err)
+
+			deviations, err := rootfs.Verify(fs, nil)
+			require.NoError(t, err)
+			assert.Contains(t, deviations, rootfs.Deviation{Kind: rootfs.ModifiedConffile, Path: "/etc/hello.conf", Packages: []string{"hello"}})
+			assert.Contains(t, deviations, rootfs.Deviation{Kind: rootfs.Unowned, Path: "/tmp/stray"})
+			assert.Contains(t, deviations, rootfs.Deviation{Kind: rootfs.Unowned, Path: "/var/lib/dpkg/info/hello.list", Reason: "dpkg database of hello"})
+			for _, d := range deviations {
+				assert.NotEqual(t, "/usr/bin/hello", d.Path)
+				assert.NotEqual(t, rootfs.Missing, d.Kind)
+				// Without exceptions, only the dpkg database of installed packages is expected:
+				assert.Equal(t, strings.HasPrefix(d.Path, "/var/lib/dpkg/info/"), d.Expected(), d.Path)
+			}
+		})
+	}
+}
+
+func TestVerify_Missing(t *testing.T) {
+	dir := testDir(t)
+	require.NoError(t, os.Remove(filepath.Join(dir, "usr", "bin", "hello")))
+	fs, err := rootfs.Open(dir)
+	require.NoError(t, err)
+
+	deviations, err := rootfs.Verify(fs, nil)
+	require.NoError(t, err)
+	assert.Contains(t, deviations, rootfs.Deviation{Kind: rootfs.Missing, Path: "/usr/bin/hello", Packages: []string{"hello"}})
+}
+
+func TestVerify_Exceptions(t *testing.T) {
+	fs, err := rootfs.Open(testTarball(t))
+	require.NoError(t, err)
+
+	exceptions := append([]rootfs.Exception{
+		{Path: "/etc/hello.conf", Kinds: []rootfs.DeviationKind{rootfs.ModifiedConffile}, Reason: "customized"},
+	}, rootfs.GeneratedFiles...)
+	deviations, err := rootfs.Verify(fs, exceptions)
+	require.NoError(t, err)
+	require.NotEmpty(t, deviations)
+	// The fixture has no base-files or dpkg to own the hierarchy:
+	var unexpected []string
+	for _, d := range deviations {
+		if !d.Expected() {
+			unexpected = append(unexpected, d.Path)
+		}
+	}
+	assert.Equal(t, []string{"/bin", "/var", "/var/lib", "/var/lib/dpkg", "/var/lib/dpkg/info"}, unexpected)
+	assert.Contains(t, deviations, rootfs.Deviation{Kind: rootfs.ModifiedConffile, Path: "/etc/hello.conf", Packages: []string{"hello"}, Reason: "customized"})
+	assert.Contains(t, deviations, rootfs.Deviation{Kind: rootfs.Unowned, Path: "/tmp/stray", Reason: "temporary files"})
+}
+
+func TestVerify_Generated(t *testing.T) {
+	dir := testDir(t)
+	files := map[string]string{
+		"var/lib/dpkg/info/hello.postinst":                                  "#!/bin/sh\n",
+		"var/lib/dpkg/info/removed.list":                                    "/usr/bin/removed\n",
+		"var/lib/systemd/deb-systemd-helper-enabled/hello.service.dsh-also": "/etc/systemd/system/multi-user.target.wants/hello.service\n",
+		"etc/systemd/system/injected.service":                               "[Service]\n",
+		"etc/apt/sources.list.d/injected.list":                              "deb http://example.com/ stable main\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "etc/systemd/system/multi-user.target.wants"), 0755))
+	require.NoError(t, os.Symlink("/usr/lib/systemd/system/hello.service", filepath.Join(dir, "etc/systemd/system/multi-user.target.wants/hello.service")))
+	fs, err := rootfs.Open(dir)
+	require.NoError(t, err)
+
+	deviations, err := rootfs.Verify(fs, rootfs.GeneratedFiles)
+	require.NoError(t, err)
+	reasons := map[string]string{}
+	for _, d := range deviations {
+		reasons[d.Path] = d.Reason
+	}
+	assert.Equal(t, "dpkg database of hello", reasons["/var/lib/dpkg/info/hello.postinst"])
+	assert.Equal(t, "link is managed by deb-systemd-helper for hello.service", reasons["/etc/systemd/system/multi-user.target.wants/hello.service"])
+	assert.Equal(t, "directory is created by deb-systemd-helper for hello.service", reasons["/etc/systemd/system/multi-user.target.wants"])
+	// Files the build and maintainer scripts don't write are unexpected:
+	for _, name := range []string{"/var/lib/dpkg/info/removed.list", "/etc/systemd/system/injected.service", "/etc/apt/sources.list.d/injected.list"} {
+		assert.Contains(t, reasons, name)
+		assert.Empty(t, reasons[name], name)
+	}
+}
+
+func TestReadStatus(t *testing.T) {
+	fs, err := rootfs.Open(testTarball(t))
+	require.NoError(t, err)
+	installed, err := rootfs.ReadStatus(fs)
+	require.NoError(t, err)
+	require.Len(t, installed, 1)
+	assert.Equal(t, "hello", installed[0].Package)
+	assert.Equal(t, "hello", installed[0].Source)
+	assert.Equal(t, "1.0-1", installed[0].SourceVersion)
+}
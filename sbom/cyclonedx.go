@@ -0,0 +1,136 @@
+package sbom
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+type cdxBOM struct {
+	BOMFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     []cdxTool    `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTool struct {
+	Name string `json:"name"`
+}
+
+type cdxComponent struct {
+	Type        string        `json:"type"`
+	BOMRef      string        `json:"bom-ref,omitempty"`
+	Name        string        `json:"name"`
+	Version     string        `json:"version,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Scope       string        `json:"scope,omitempty"`
+	Hashes      []cdxHash     `json:"hashes,omitempty"`
+	Licenses    []cdxLicense  `json:"licenses,omitempty"`
+	Copyright   string        `json:"copyright,omitempty"`
+	PURL        string        `json:"purl,omitempty"`
+	Pedigree    *cdxPedigree  `json:"pedigree,omitempty"`
+	Properties  []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxHash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+type cdxLicense struct {
+	License cdxLicenseChoice `json:"license"`
+}
+
+type cdxLicenseChoice struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+type cdxPedigree struct {
+	Ancestors []cdxComponent `json:"ancestors"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// WriteCycloneDX writes the document as CycloneDX 1.4 JSON.
+func (d *Document) WriteCycloneDX(w io.Writer) error {
+	bom := cdxBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.4",
+		SerialNumber: "urn:uuid:" + d.uuid(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: d.Created.Format(time.RFC3339),
+			Tools:     []cdxTool{{Name: "debendabot"}},
+			Component: cdxComponent{Type: "container", BOMRef: d.Image, Name: d.Image},
+		},
+		Components: make([]cdxComponent, 0, len(d.Packages)+1),
+	}
+
+	if d.BaseImage != nil {
+		bom.Components = append(bom.Components, cdxComponent{
+			Type:        "container",
+			BOMRef:      d.BaseImage.PURL(),
+			Name:        d.BaseImage.Name,
+			Version:     d.BaseImage.Digest,
+			Description: "image the rootfs was bootstrapped from",
+			// The rootfs is bootstrapped within the base image, but doesn't contain it:
+			Scope: "excluded",
+			PURL:  d.BaseImage.PURL(),
+		})
+	}
+
+	for _, p := range d.Packages {
+		purl := d.PURL(p)
+		c := cdxComponent{
+			Type:      "library",
+			BOMRef:    purl,
+			Name:      p.Name,
+			Version:   p.Version,
+			Copyright: strings.Join(p.Copyright, "\n"),
+			PURL:      purl,
+		}
+		if p.SHA512 != "" {
+			c.Hashes = []cdxHash{{Algorithm: "SHA-512", Content: p.SHA512}}
+		}
+		for _, license := range p.Licenses {
+			if id, ok := spdxID(license); ok {
+				c.Licenses = append(c.Licenses, cdxLicense{License: cdxLicenseChoice{ID: id}})
+			} else {
+				c.Licenses = append(c.Licenses, cdxLicense{License: cdxLicenseChoice{Name: license}})
+			}
+		}
+		if p.Source != p.Name || p.SourceVersion != p.Version {
+			c.Pedigree = &cdxPedigree{Ancestors: []cdxComponent{{
+				Type:    "library",
+				Name:    p.Source,
+				Version: p.SourceVersion,
+				PURL:    d.SourcePURL(p),
+			}}}
+		}
+		if p.Filename != "" {
+			c.Properties = append(c.Properties, cdxProperty{Name: "debendabot:filename", Value: p.Filename})
+		}
+		if p.Repository != "" {
+			c.Properties = append(c.Properties, cdxProperty{Name: "debendabot:repository", Value: p.Repository})
+		}
+		bom.Components = append(bom.Components, c)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(bom)
+}
@@ -0,0 +1,99 @@
+package sbom
+
+import (
+	"regexp"
+	"strings"
+)
+
+// spdxLicenses maps Debian short license names to SPDX license identifiers.
+// https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/#license-specification
+var spdxLicenses = map[string]string{
+	"agpl-3":       "AGPL-3.0-only",
+	"agpl-3+":      "AGPL-3.0-or-later",
+	"apache-2":     "Apache-2.0",
+	"apache-2.0":   "Apache-2.0",
+	"artistic":     "Artistic-1.0-Perl",
+	"artistic-2.0": "Artistic-2.0",
+	"bsd-2-clause": "BSD-2-Clause",
+	"bsd-3-clause": "BSD-3-Clause",
+	"bsd-4-clause": "BSD-4-Clause",
+	"cc0-1.0":      "CC0-1.0",
+	"expat":        "MIT",
+	"gfdl-1.2":     "GFDL-1.2-only",
+	"gfdl-1.2+":    "GFDL-1.2-or-later",
+	"gfdl-1.3":     "GFDL-1.3-only",
+	"gfdl-1.3+":    "GFDL-1.3-or-later",
+	"gpl-1":        "GPL-1.0-only",
+	"gpl-1+":       "GPL-1.0-or-later",
+	"gpl-2":        "GPL-2.0-only",
+	"gpl-2+":       "GPL-2.0-or-later",
+	"gpl-3":        "GPL-3.0-only",
+	"gpl-3+":       "GPL-3.0-or-later",
+	"isc":          "ISC",
+	"lgpl-2":       "LGPL-2.0-only",
+	"lgpl-2+":      "LGPL-2.0-or-later",
+	"lgpl-2.1":     "LGPL-2.1-only",
+	"lgpl-2.1+":    "LGPL-2.1-or-later",
+	"lgpl-3":       "LGPL-3.0-only",
+	"lgpl-3+":      "LGPL-3.0-or-later",
+	"mit":          "MIT",
+	"mpl-1.1":      "MPL-1.1",
+	"mpl-2.0":      "MPL-2.0",
+	"openssl":      "OpenSSL",
+	"psf-2":        "PSF-2.0",
+	"zlib":         "Zlib",
+	"zpl-2.1":      "ZPL-2.1",
+}
+
+var licenseRefInvalid = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// licenseRef returns the SPDX reference for a license without an identifier.
+func licenseRef(name string) string {
+	return "LicenseRef-" + strings.Trim(licenseRefInvalid.ReplaceAllString(name, "-"), "-")
+}
+
+// spdxExpression converts a Debian license, e.g. "GPL-2+ or Artistic", to an SPDX license expression.
+// Licenses without an SPDX identifier, including those with exceptions, are returned as references.
+func spdxExpression(license string) (string, []string) {
+	var terms, refs []string
+	var name []string
+	flush := func() {
+		if len(name) == 0 {
+			return
+		}
+		n := strings.Join(name, " ")
+		if id, ok := spdxLicenses[strings.ToLower(n)]; ok {
+			terms = append(terms, id)
+		} else {
+			ref := licenseRef(n)
+			terms = append(terms, ref)
+			refs = append(refs, n)
+		}
+		name = name[:0]
+	}
+	for _, token := range strings.Fields(strings.ReplaceAll(license, ",", " ")) {
+		switch strings.ToLower(token) {
+		case "or", "and":
+			flush()
+			terms = append(terms, strings.ToUpper(token))
+		default:
+			name = append(name, token)
+		}
+	}
+	flush()
+
+	// Operators must separate licenses, otherwise the whole license is referenced:
+	for i, term := range terms {
+		operator := term == "OR" || term == "AND"
+		if operator != (i%2 == 1) || len(terms)%2 == 0 {
+			return licenseRef(license), []string{license}
+		}
+	}
+	return strings.Join(terms, " "), refs
+}
+
+// spdxID returns the SPDX identifier of a Debian license, or false if there isn't one.
+func spdxID(license string) (string, bool) {
+	id, ok := spdxLicenses[strings.ToLower(license)]
+	return id, ok
+}
@@ -0,0 +1,263 @@
+// Package sbom describes the packages in an image as a software bill of materials.
+package sbom
+
+import (
+	"archive/tar"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/thepwagner/debendabot/dpkg"
+	"github.com/thepwagner/debendabot/manifest"
+	"github.com/thepwagner/debendabot/rootfs"
+)
+
+// Document is a bill of materials for an image, independent of its format.
+type Document struct {
+	Image   string
+	Distro  string
+	Created time.Time
+	// BaseImage is the pinned image the rootfs was bootstrapped from, if known.
+	BaseImage *BaseImage
+	// Packages are sorted by name, then architecture.
+	Packages []*Package
+}
+
+// BaseImage is a docker image, identified by digest.
+type BaseImage struct {
+	// Name is the normalized repository, e.g. "docker.io/library/debian".
+	Name   string
+	Digest string
+}
+
+// Package is a binary package installed in the image.
+type Package struct {
+	Name         string
+	Version      string
+	Architecture string
+	// Source package name and version, recorded by the lockfile or ReadRootfs.
+	Source        string
+	SourceVersion string
+	Filename      string
+	SHA512        string
+	// Repository is the name of the dpkg.json repository that provided the package, empty for the distro.
+	Repository string
+	// Origin of the repository's Release file, e.g. "Docker", if it has one.
+	Origin string
+	// Copyright and Licenses are read from /usr/share/doc/<pkg>/copyright by ReadRootfs.
+	Copyright []string
+	Licenses  []string
+}
+
+// New describes the packages locked for architectures of a manifest.
+func New(mf manifest.Manifest, archs []string, created time.Time) (*Document, error) {
+	if mf.DpkgLockJSON == nil {
+		return nil, fmt.Errorf("lockfile not found, run update")
+	}
+	doc := &Document{
+		Image:   mf.DpkgJSON.Image,
+		Distro:  mf.DpkgJSON.Distro,
+		Created: created.UTC(),
+	}
+	if mf.DpkgLockJSON.Image != "" {
+		base, err := parseBaseImage(mf.DpkgLockJSON.Image)
+		if err != nil {
+			return nil, err
+		}
+		doc.BaseImage = base
+	}
+
+	// Architecture independent packages are shared by every architecture:
+	seen := map[string]bool{}
+	for _, arch := range archs {
+		locked := mf.DpkgLockJSON.PackagesFor(arch)
+		if locked == nil {
+			return nil, fmt.Errorf("architecture %q is not locked", arch)
+		}
+		for name, lock := range locked {
+			pkg := &Package{
+				Name:          string(name),
+				Version:       lock.Version,
+				Architecture:  lock.Architecture,
+				Source:        string(name),
+				SourceVersion: lock.Version,
+				Filename:      lock.DebFilename,
+				SHA512:        lock.DebHash,
+				Repository:    lock.Repository,
+				Origin:        lock.Origin,
+			}
+			if lock.Source != "" {
+				pkg.Source = lock.Source
+			}
+			if lock.SourceVersion != "" {
+				pkg.SourceVersion = lock.SourceVersion
+			}
+			if purl := doc.PURL(pkg); !seen[purl] {
+				seen[purl] = true
+				doc.Packages = append(doc.Packages, pkg)
+			}
+		}
+	}
+	sort.Slice(doc.Packages, func(i, j int) bool {
+		if doc.Packages[i].Name != doc.Packages[j].Name {
+			return doc.Packages[i].Name < doc.Packages[j].Name
+		}
+		return doc.Packages[i].Architecture < doc.Packages[j].Architecture
+	})
+	return doc, nil
+}
+
+func parseBaseImage(image string) (*BaseImage, error) {
+	ref, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return nil, fmt.Errorf("parsing base image %q: %w", image, err)
+	}
+	digested, ok := ref.(reference.Digested)
+	if !ok {
+		return nil, fmt.Errorf("base image %q is not pinned to a digest", image)
+	}
+	return &BaseImage{Name: ref.Name(), Digest: digested.Digest().String()}, nil
+}
+
+// docDir holds copyright files, e.g. /usr/share/doc/bash/copyright.
+const docDir = "/usr/share/doc"
+
+// ReadRootfs records source packages and licensing of the packages of an architecture from its rootfs.
+func (d *Document) ReadRootfs(arch string, fs rootfs.Rootfs) error {
+	var installed []dpkg.Package
+	copyrights := map[string]*dpkg.Copyright{}
+	links := map[string]string{}
+	err := fs.Walk(func(name string, h *tar.Header, r io.Reader) error {
+		switch {
+		case name == dpkg.StatusPath && h.Typeflag == tar.TypeReg:
+			pkgs, err := dpkg.ParseStatus(r)
+			if err != nil {
+				return fmt.Errorf("parsing %q: %w", name, err)
+			}
+			installed = pkgs
+		case !strings.HasPrefix(name, docDir+"/"):
+		case h.Typeflag == tar.TypeSymlink:
+			// Packages built from the same source often link their documentation, e.g. libssl1.1 -> openssl:
+			links[name] = path.Join(path.Dir(name), h.Linkname)
+			if path.IsAbs(h.Linkname) {
+				links[name] = path.Clean(h.Linkname)
+			}
+		case h.Typeflag == tar.TypeReg && path.Base(name) == "copyright":
+			c, err := dpkg.ParseCopyright(r)
+			if err != nil {
+				return fmt.Errorf("parsing %q: %w", name, err)
+			}
+			copyrights[name] = c
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	byName := make(map[string]dpkg.Package, len(installed))
+	for _, p := range installed {
+		if p.Architecture == arch || p.Architecture == "all" {
+			byName[p.Package] = p
+		}
+	}
+	for _, pkg := range d.Packages {
+		if pkg.Architecture != arch && pkg.Architecture != "all" {
+			continue
+		}
+		if p, ok := byName[pkg.Name]; ok && p.Version == pkg.Version {
+			pkg.Source, pkg.SourceVersion = p.Source, p.SourceVersion
+		}
+		if c, ok := copyrights[resolveLink(links, path.Join(docDir, pkg.Name, "copyright"))]; ok {
+			pkg.Copyright, pkg.Licenses = c.Copyright, c.Licenses
+		}
+	}
+	return nil
+}
+
+// resolveLink follows links of a path and its parent directory.
+func resolveLink(links map[string]string, name string) string {
+	for i := 0; i < 10; i++ {
+		if target, ok := links[name]; ok {
+			name = target
+		} else if dir, ok := links[path.Dir(name)]; ok {
+			name = path.Join(dir, path.Base(name))
+		} else {
+			break
+		}
+	}
+	return name
+}
+
+// PURL returns the package URL of a package, e.g. "pkg:deb/debian/bash@5.0-4?arch=amd64&distro=buster".
+// Packages of dpkg.json repositories are namespaced by the repository, e.g. "pkg:deb/docker/docker-ce@...".
+func (d *Document) PURL(p *Package) string {
+	qualifiers := fmt.Sprintf("arch=%s&distro=%s", url.QueryEscape(p.Architecture), url.QueryEscape(d.Distro))
+	if p.Source != p.Name || p.SourceVersion != p.Version {
+		upstream := p.Source
+		if p.SourceVersion != p.Version {
+			upstream += "@" + p.SourceVersion
+		}
+		qualifiers += "&upstream=" + url.QueryEscape(upstream)
+	}
+	return fmt.Sprintf("pkg:deb/%s/%s@%s?%s", purlNamespace(p), purlEscape(p.Name), purlEscape(p.Version), qualifiers)
+}
+
+// SourcePURL returns the package URL of the source package a package was built from.
+func (d *Document) SourcePURL(p *Package) string {
+	return fmt.Sprintf("pkg:deb/%s/%s@%s?arch=source&distro=%s", purlNamespace(p), purlEscape(p.Source), purlEscape(p.SourceVersion), url.QueryEscape(d.Distro))
+}
+
+// purlNamespace returns the vendor of a package: the origin of its repository, else the repository's name.
+// Packages of the distro are from "debian".
+func purlNamespace(p *Package) string {
+	switch {
+	case p.Repository == "":
+		return "debian"
+	case p.Origin != "":
+		return purlEscape(strings.ToLower(p.Origin))
+	default:
+		return purlEscape(strings.ToLower(p.Repository))
+	}
+}
+
+// purlEscape percent-encodes a package URL component, e.g. the epoch and "+" of "1:2.33.1-0.1+deb10u1".
+// Unlike url.PathEscape, only unreserved characters are left as is.
+func purlEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			_, _ = fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// PURL returns the package URL of the base image, e.g. "pkg:oci/debian@sha256%3A...?repository_url=docker.io/library/debian".
+func (b *BaseImage) PURL() string {
+	return fmt.Sprintf("pkg:oci/%s@%s?repository_url=%s", path.Base(b.Name), url.QueryEscape(b.Digest), b.Name)
+}
+
+// uuid returns a name-based (version 5) UUID, so documents describing the same image are identical.
+func (d *Document) uuid() string {
+	h := sha1.New()
+	// RFC 4122 URL namespace:
+	_, _ = h.Write([]byte{0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8})
+	_, _ = fmt.Fprintf(h, "https://github.com/thepwagner/debendabot/%s/%d", d.Image, d.Created.Unix())
+	for _, p := range d.Packages {
+		_, _ = fmt.Fprintf(h, "\n%s %s", d.PURL(p), p.SHA512)
+	}
+	b := h.Sum(nil)[:16]
+	b[6] = b[6]&0x0f | 0x50
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,222 @@
+package sbom_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/manifest"
+	"github.com/thepwagner/debendabot/rootfs"
+	"github.com/thepwagner/debendabot/sbom"
+)
+
+const (
+	baseImage = "debian@sha256:46ca2ec8d9a6f56d4c7a3e0e4d1ad6ae4c5e9d1a0c4b5c1e8e4f2f8a9d1c1b2a"
+	status    = `Package: bash
+Status: install ok installed
+Architecture: amd64
+Version: 5.0-4
+
+Package: libssl1.1
+Status: install ok installed
+Architecture: amd64
+Source: openssl
+Version: 1.1.1d-0+deb10u3
+`
+	bashCopyright = `Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+
+Files: *
+Copyright: 1987-2018 Free Software Foundation, Inc.
+License: GPL-3+
+
+Files: examples/*
+Copyright: 1996 Someone
+License: GPL-2+ or Artistic
+`
+	opensslCopyright = `Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/
+
+Files: *
+Copyright: 1998-2018 The OpenSSL Project
+License: OpenSSL
+`
+)
+
+func testManifest() manifest.Manifest {
+	return manifest.Manifest{
+		DpkgJSON: manifest.DpkgJSON{Image: "test", Distro: "buster"},
+		DpkgLockJSON: &manifest.DpkgLockJSON{
+			Image: baseImage,
+			Packages: map[manifest.PackageName]manifest.LockedPackage{
+				"bash":      {Version: "5.0-4", Architecture: "amd64", DebFilename: "bash_5.0-4_amd64.deb", DebHash: "abcd"},
+				"libssl1.1": {Version: "1.1.1d-0+deb10u3", Architecture: "amd64", DebFilename: "libssl1.1_1.1.1d-0+deb10u3_amd64.deb", DebHash: "ef01"},
+			},
+		},
+	}
+}
+
+func testRootfs(t *testing.T) rootfs.Rootfs {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "image.tar")
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"./var/lib/dpkg/status":             status,
+		"./usr/share/doc/bash/copyright":    bashCopyright,
+		"./usr/share/doc/openssl/copyright": opensslCopyright,
+	} {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(content))}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "./usr/share/doc/libssl1.1", Linkname: "openssl"}))
+	require.NoError(t, tw.Close())
+	require.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+
+	fs, err := rootfs.Open(path)
+	require.NoError(t, err)
+	return fs
+}
+
+func testDocument(t *testing.T) *sbom.Document {
+	doc, err := sbom.New(testManifest(), []string{"amd64"}, time.Unix(1594944000, 0))
+	require.NoError(t, err)
+	require.NoError(t, doc.ReadRootfs("amd64", testRootfs(t)))
+	return doc
+}
+
+func TestNew(t *testing.T) {
+	doc, err := sbom.New(testManifest(), []string{"amd64"}, time.Unix(1594944000, 0))
+	require.NoError(t, err)
+	require.Len(t, doc.Packages, 2)
+	assert.Equal(t, "bash", doc.Packages[0].Name)
+	assert.Equal(t, "pkg:deb/debian/bash@5.0-4?arch=amd64&distro=buster", doc.PURL(doc.Packages[0]))
+	assert.Equal(t, &sbom.BaseImage{Name: "docker.io/library/debian", Digest: "sha256:46ca2ec8d9a6f56d4c7a3e0e4d1ad6ae4c5e9d1a0c4b5c1e8e4f2f8a9d1c1b2a"}, doc.BaseImage)
+}
+
+func TestNew_LockedSource(t *testing.T) {
+	mf := testManifest()
+	libssl := mf.DpkgLockJSON.Packages["libssl1.1"]
+	libssl.Source, libssl.SourceVersion = "openssl", "1.1.1d-0+deb10u3"
+	mf.DpkgLockJSON.Packages["libssl1.1"] = libssl
+
+	doc, err := sbom.New(mf, []string{"amd64"}, time.Unix(1594944000, 0))
+	require.NoError(t, err)
+	require.Len(t, doc.Packages, 2)
+	assert.Equal(t, "openssl", doc.Packages[1].Source)
+	assert.Equal(t, "pkg:deb/debian/libssl1.1@1.1.1d-0%2Bdeb10u3?arch=amd64&distro=buster&upstream=openssl", doc.PURL(doc.Packages[1]))
+}
+
+func TestDocument_PURL(t *testing.T) {
+	doc := &sbom.Document{Distro: "buster"}
+	bsdutils := &sbom.Package{
+		Name:          "bsdutils",
+		Version:       "1:2.33.1-0.1+deb10u1",
+		Architecture:  "amd64",
+		Source:        "util-linux",
+		SourceVersion: "2.33.1-0.1+deb10u1",
+	}
+	assert.Equal(t, "pkg:deb/debian/bsdutils@1%3A2.33.1-0.1%2Bdeb10u1?arch=amd64&distro=buster&upstream=util-linux%402.33.1-0.1%2Bdeb10u1", doc.PURL(bsdutils))
+	assert.Equal(t, "pkg:deb/debian/util-linux@2.33.1-0.1%2Bdeb10u1?arch=source&distro=buster", doc.SourcePURL(bsdutils))
+}
+
+func TestDocument_PURL_Repository(t *testing.T) {
+	doc := &sbom.Document{Distro: "buster"}
+	docker := &sbom.Package{
+		Name:          "docker-ce",
+		Version:       "5:20.10.5~3-0~debian-buster",
+		Architecture:  "amd64",
+		Source:        "docker-ce",
+		SourceVersion: "5:20.10.5~3-0~debian-buster",
+		Repository:    "docker",
+		Origin:        "Docker",
+	}
+	assert.Equal(t, "pkg:deb/docker/docker-ce@5%3A20.10.5~3-0~debian-buster?arch=amd64&distro=buster", doc.PURL(docker))
+	assert.Equal(t, "pkg:deb/docker/docker-ce@5%3A20.10.5~3-0~debian-buster?arch=source&distro=buster", doc.SourcePURL(docker))
+
+	docker.Repository, docker.Origin = "Internal", ""
+	assert.Equal(t, "pkg:deb/internal/docker-ce@5%3A20.10.5~3-0~debian-buster?arch=amd64&distro=buster", doc.PURL(docker))
+}
+
+func TestDocument_ReadRootfs(t *testing.T) {
+	doc := testDocument(t)
+	bash, libssl := doc.Packages[0], doc.Packages[1]
+	assert.Equal(t, []string{"GPL-2+ or Artistic", "GPL-3+"}, bash.Licenses)
+	assert.Equal(t, []string{"1987-2018 Free Software Foundation, Inc.", "1996 Someone"}, bash.Copyright)
+	assert.Equal(t, "openssl", libssl.Source)
+	assert.Equal(t, []string{"OpenSSL"}, libssl.Licenses)
+	assert.Equal(t, "pkg:deb/debian/libssl1.1@1.1.1d-0%2Bdeb10u3?arch=amd64&distro=buster&upstream=openssl", doc.PURL(libssl))
+}
+
+func TestDocument_WriteSPDX(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, testDocument(t).WriteSPDX(&buf))
+
+	var spdx struct {
+		SPDXVersion string `json:"spdxVersion"`
+		Packages    []struct {
+			SPDXID          string `json:"SPDXID"`
+			Name            string `json:"name"`
+			LicenseDeclared string `json:"licenseDeclared"`
+			Checksums       []struct {
+				Algorithm string `json:"algorithm"`
+			} `json:"checksums"`
+		} `json:"packages"`
+		Relationships []struct {
+			Element string `json:"spdxElementId"`
+			Type    string `json:"relationshipType"`
+			Related string `json:"relatedSpdxElement"`
+		} `json:"relationships"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &spdx))
+	assert.Equal(t, "SPDX-2.3", spdx.SPDXVersion)
+
+	licenses := map[string]string{}
+	for _, p := range spdx.Packages {
+		licenses[p.SPDXID] = p.LicenseDeclared
+	}
+	assert.Equal(t, "(GPL-2.0-or-later OR Artistic-1.0-Perl) AND GPL-3.0-or-later", licenses["SPDXRef-Package-bash-amd64"])
+	assert.Equal(t, "OpenSSL", licenses["SPDXRef-Package-libssl1.1-amd64"])
+	assert.Contains(t, licenses, "SPDXRef-BaseImage")
+	assert.Contains(t, licenses, "SPDXRef-Source-openssl-1.1.1d-0-deb10u3")
+	assert.Contains(t, spdx.Relationships, struct {
+		Element string `json:"spdxElementId"`
+		Type    string `json:"relationshipType"`
+		Related string `json:"relatedSpdxElement"`
+	}{Element: "SPDXRef-Package-libssl1.1-amd64", Type: "GENERATED_FROM", Related: "SPDXRef-Source-openssl-1.1.1d-0-deb10u3"})
+}
+
+func TestDocument_WriteCycloneDX(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, testDocument(t).WriteCycloneDX(&buf))
+	var first bytes.Buffer
+	require.NoError(t, testDocument(t).WriteCycloneDX(&first))
+	assert.Equal(t, first.String(), buf.String(), "output is reproducible")
+
+	var bom struct {
+		BOMFormat  string `json:"bomFormat"`
+		Components []struct {
+			Name     string `json:"name"`
+			PURL     string `json:"purl"`
+			Scope    string `json:"scope"`
+			Pedigree *struct {
+				Ancestors []struct {
+					Name string `json:"name"`
+				} `json:"ancestors"`
+			} `json:"pedigree"`
+		} `json:"components"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &bom))
+	assert.Equal(t, "CycloneDX", bom.BOMFormat)
+	require.Len(t, bom.Components, 3)
+	assert.Equal(t, "excluded", bom.Components[0].Scope)
+	assert.Equal(t, "pkg:deb/debian/bash@5.0-4?arch=amd64&distro=buster", bom.Components[1].PURL)
+	require.NotNil(t, bom.Components[2].Pedigree)
+	assert.Equal(t, "openssl", bom.Components[2].Pedigree.Ancestors[0].Name)
+}
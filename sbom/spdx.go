@@ -0,0 +1,196 @@
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// spdxNoAssertion marks information that wasn't determined.
+const spdxNoAssertion = "NOASSERTION"
+
+type spdxDocument struct {
+	SPDXVersion       string                 `json:"spdxVersion"`
+	DataLicense       string                 `json:"dataLicense"`
+	SPDXID            string                 `json:"SPDXID"`
+	Name              string                 `json:"name"`
+	DocumentNamespace string                 `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo       `json:"creationInfo"`
+	Packages          []spdxPackage          `json:"packages"`
+	Relationships     []spdxRelationship     `json:"relationships"`
+	ExtractedLicenses []spdxExtractedLicense `json:"hasExtractedLicensingInfos,omitempty"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID           string            `json:"SPDXID"`
+	Name             string            `json:"name"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	PackageFileName  string            `json:"packageFileName,omitempty"`
+	Supplier         string            `json:"supplier,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	Checksums        []spdxChecksum    `json:"checksums,omitempty"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	PrimaryPurpose   string            `json:"primaryPackagePurpose,omitempty"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	Category string `json:"referenceCategory"`
+	Type     string `json:"referenceType"`
+	Locator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	Element string `json:"spdxElementId"`
+	Type    string `json:"relationshipType"`
+	Related string `json:"relatedSpdxElement"`
+}
+
+type spdxExtractedLicense struct {
+	LicenseID     string `json:"licenseId"`
+	Name          string `json:"name"`
+	ExtractedText string `json:"extractedText"`
+}
+
+var spdxIDInvalid = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+func spdxRef(parts ...string) string {
+	return "SPDXRef-" + spdxIDInvalid.ReplaceAllString(strings.Join(parts, "-"), "-")
+}
+
+// WriteSPDX writes the document as SPDX 2.3 JSON.
+func (d *Document) WriteSPDX(w io.Writer) error {
+	imageID := spdxRef("Image", d.Image)
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              d.Image,
+		DocumentNamespace: fmt.Sprintf("https://github.com/thepwagner/debendabot/spdx/%s-%s", d.Image, d.uuid()),
+		CreationInfo: spdxCreationInfo{
+			Created:  d.Created.Format(time.RFC3339),
+			Creators: []string{"Tool: debendabot"},
+		},
+		Packages: []spdxPackage{{
+			SPDXID:           imageID,
+			Name:             d.Image,
+			DownloadLocation: spdxNoAssertion,
+			LicenseConcluded: spdxNoAssertion,
+			LicenseDeclared:  spdxNoAssertion,
+			CopyrightText:    spdxNoAssertion,
+			PrimaryPurpose:   "CONTAINER",
+		}},
+		Relationships: []spdxRelationship{{Element: "SPDXRef-DOCUMENT", Type: "DESCRIBES", Related: imageID}},
+	}
+
+	if d.BaseImage != nil {
+		baseID := spdxRef("BaseImage")
+		doc.Packages = append(doc.Packages, spdxPackage{
+			SPDXID:           baseID,
+			Name:             d.BaseImage.Name,
+			VersionInfo:      d.BaseImage.Digest,
+			DownloadLocation: spdxNoAssertion,
+			LicenseConcluded: spdxNoAssertion,
+			LicenseDeclared:  spdxNoAssertion,
+			CopyrightText:    spdxNoAssertion,
+			PrimaryPurpose:   "CONTAINER",
+			ExternalRefs:     []spdxExternalRef{purlRef(d.BaseImage.PURL())},
+		})
+		// The rootfs is bootstrapped within the base image, but doesn't contain it:
+		doc.Relationships = append(doc.Relationships, spdxRelationship{Element: baseID, Type: "BUILD_TOOL_OF", Related: imageID})
+	}
+
+	sources := map[string]bool{}
+	extracted := map[string]string{}
+	for _, p := range d.Packages {
+		id := spdxRef("Package", p.Name, p.Architecture)
+		pkg := spdxPackage{
+			SPDXID:           id,
+			Name:             p.Name,
+			VersionInfo:      p.Version,
+			PackageFileName:  p.Filename,
+			DownloadLocation: spdxNoAssertion,
+			LicenseConcluded: spdxNoAssertion,
+			LicenseDeclared:  spdxNoAssertion,
+			CopyrightText:    spdxNoAssertion,
+			PrimaryPurpose:   "LIBRARY",
+			ExternalRefs:     []spdxExternalRef{purlRef(d.PURL(p))},
+		}
+		if p.SHA512 != "" {
+			pkg.Checksums = []spdxChecksum{{Algorithm: "SHA512", Value: p.SHA512}}
+		}
+		if len(p.Licenses) > 0 {
+			expressions := make([]string, 0, len(p.Licenses))
+			for _, license := range p.Licenses {
+				expression, refs := spdxExpression(license)
+				if strings.Contains(expression, " ") {
+					expression = "(" + expression + ")"
+				}
+				expressions = append(expressions, expression)
+				for _, ref := range refs {
+					extracted[licenseRef(ref)] = ref
+				}
+			}
+			pkg.LicenseDeclared = strings.Join(expressions, " AND ")
+		}
+		if len(p.Copyright) > 0 {
+			pkg.CopyrightText = strings.Join(p.Copyright, "\n")
+		}
+		doc.Packages = append(doc.Packages, pkg)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{Element: imageID, Type: "CONTAINS", Related: id})
+
+		sourceID := spdxRef("Source", p.Source, p.SourceVersion)
+		if !sources[sourceID] {
+			sources[sourceID] = true
+			doc.Packages = append(doc.Packages, spdxPackage{
+				SPDXID:           sourceID,
+				Name:             p.Source,
+				VersionInfo:      p.SourceVersion,
+				DownloadLocation: spdxNoAssertion,
+				LicenseConcluded: spdxNoAssertion,
+				LicenseDeclared:  spdxNoAssertion,
+				CopyrightText:    spdxNoAssertion,
+				PrimaryPurpose:   "SOURCE",
+				ExternalRefs:     []spdxExternalRef{purlRef(d.SourcePURL(p))},
+			})
+		}
+		doc.Relationships = append(doc.Relationships, spdxRelationship{Element: id, Type: "GENERATED_FROM", Related: sourceID})
+	}
+
+	for id, name := range extracted {
+		doc.ExtractedLicenses = append(doc.ExtractedLicenses, spdxExtractedLicense{
+			LicenseID:     id,
+			Name:          name,
+			ExtractedText: fmt.Sprintf("Debian license %q, see the package's copyright file.", name),
+		})
+	}
+	sort.Slice(doc.ExtractedLicenses, func(i, j int) bool {
+		return doc.ExtractedLicenses[i].LicenseID < doc.ExtractedLicenses[j].LicenseID
+	})
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(doc)
+}
+
+func purlRef(purl string) spdxExternalRef {
+	return spdxExternalRef{Category: "PACKAGE-MANAGER", Type: "purl", Locator: purl}
+}
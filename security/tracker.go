@@ -0,0 +1,120 @@
+package security
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/thepwagner/debendabot/dpkg"
+)
+
+// Tracker is a Debian Security Tracker dump, as published at https://security-tracker.debian.org/tracker/data/json.
+// Issues are keyed by source package, then issue ID (e.g. a CVE).
+type Tracker map[string]map[string]Issue
+
+// Issue is a vulnerability affecting a source package.
+type Issue struct {
+	Description string                  `json:"description"`
+	Scope       string                  `json:"scope"`
+	Releases    map[string]IssueRelease `json:"releases"`
+}
+
+// IssueRelease is the state of an issue within a Debian release, e.g. "buster".
+type IssueRelease struct {
+	// Status is "resolved", "open" or "undetermined".
+	Status       string `json:"status"`
+	FixedVersion string `json:"fixed_version"`
+	Urgency      string `json:"urgency"`
+}
+
+const (
+	StatusResolved = "resolved"
+
+	// notAffected is used as a fixed version when no version of the package was vulnerable.
+	notAffected = "0"
+)
+
+// ParseTracker decodes a security tracker dump.
+func ParseTracker(r io.Reader) (Tracker, error) {
+	var t Tracker
+	if err := json.NewDecoder(r).Decode(&t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Finding is an issue affecting a version of a source package.
+type Finding struct {
+	Source       string `json:"source"`
+	Version      string `json:"version"`
+	ID           string `json:"id"`
+	Urgency      string `json:"urgency"`
+	Status       string `json:"status"`
+	FixedVersion string `json:"fixedVersion,omitempty"`
+}
+
+// Audit returns the issues affecting a version of a source package in a release.
+func (t Tracker) Audit(release, source, version string) ([]Finding, error) {
+	issues, ok := t[source]
+	if !ok {
+		return nil, nil
+	}
+	v, err := dpkg.ParseVersion(version)
+	if err != nil {
+		return nil, fmt.Errorf("source %q: %w", source, err)
+	}
+
+	var ret []Finding
+	for id, issue := range issues {
+		rel, ok := issue.Releases[release]
+		if !ok {
+			continue
+		}
+
+		if rel.Status == StatusResolved {
+			if rel.FixedVersion == notAffected || rel.FixedVersion == "" {
+				continue
+			}
+			fixed, err := dpkg.ParseVersion(rel.FixedVersion)
+			if err != nil {
+				return nil, fmt.Errorf("issue %q: %w", id, err)
+			}
+			if v.Compare(fixed) >= 0 {
+				continue
+			}
+		}
+
+		ret = append(ret, Finding{
+			Source:       source,
+			Version:      version,
+			ID:           id,
+			Urgency:      rel.Urgency,
+			Status:       rel.Status,
+			FixedVersion: rel.FixedVersion,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret, nil
+}
+
+// urgencies ranks urgencies from the tracker. Unassigned urgencies are treated as medium.
+var urgencies = map[string]int{
+	"unimportant":      0,
+	"end-of-life":      0,
+	"low":              1,
+	"medium":           2,
+	"not yet assigned": 2,
+	"high":             3,
+}
+
+// UrgencyRank orders an urgency for comparison, returning an error for unknown urgencies.
+func UrgencyRank(urgency string) (int, error) {
+	// Urgencies suffixed with "*" are estimated by the tracker rather than set by the security team:
+	rank, ok := urgencies[strings.TrimRight(urgency, "*")]
+	if !ok {
+		return 0, fmt.Errorf("unknown urgency %q", urgency)
+	}
+	return rank, nil
+}
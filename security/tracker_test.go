@@ -0,0 +1,59 @@
+package security_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/security"
+)
+
+func TestTracker_Audit(t *testing.T) {
+	f, err := os.Open("testdata/tracker.json")
+	require.NoError(t, err)
+	defer f.Close()
+	tracker, err := security.ParseTracker(f)
+	require.NoError(t, err)
+
+	cases := []struct {
+		release, source, version string
+		expected                 []string
+	}{
+		{"buster", "bash", "5.0-4", []string{"CVE-2019-18276"}},
+		{"bullseye", "bash", "5.1-2", nil},
+		{"buster", "gnupg2", "2.2.12-1", []string{"CVE-2019-14855", "CVE-2022-34903"}},
+		{"buster", "gnupg2", "2.2.12-1+deb10u1", []string{"CVE-2022-34903"}},
+		{"buster", "gnupg2", "2.2.12-1+deb10u2", nil},
+		{"buster", "zsh", "5.7.1-1", nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.source+"_"+tc.version, func(t *testing.T) {
+			findings, err := tracker.Audit(tc.release, tc.source, tc.version)
+			require.NoError(t, err)
+			var ids []string
+			for _, f := range findings {
+				ids = append(ids, f.ID)
+			}
+			assert.Equal(t, tc.expected, ids)
+		})
+	}
+}
+
+func TestFinding_JSON(t *testing.T) {
+	b, err := json.Marshal(security.Finding{Source: "gnupg2", Version: "2.2.12-1", ID: "CVE-2019-14855", Urgency: "low", Status: security.StatusResolved, FixedVersion: "2.2.12-1+deb10u1"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"source": "gnupg2", "version": "2.2.12-1", "id": "CVE-2019-14855", "urgency": "low", "status": "resolved", "fixedVersion": "2.2.12-1+deb10u1"}`, string(b))
+}
+
+func TestUrgencyRank(t *testing.T) {
+	low, err := security.UrgencyRank("low")
+	require.NoError(t, err)
+	medium, err := security.UrgencyRank("medium**")
+	require.NoError(t, err)
+	assert.Less(t, low, medium)
+
+	_, err = security.UrgencyRank("critical")
+	assert.Error(t, err)
+}
@@ -0,0 +1,77 @@
+package signature
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Ed25519Extension names raw ed25519 signatures, which `openssl pkeyutl -verify -rawin` can also check.
+const Ed25519Extension = ".sig"
+
+var errInvalidEd25519 = errors.New("ed25519 signature does not match")
+
+type ed25519Signer ed25519.PrivateKey
+
+// parseEd25519Signer parses a key as generated by `openssl genpkey -algorithm ed25519`.
+func parseEd25519Signer(b []byte) (Signer, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("expected PEM encoded PRIVATE KEY")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing private key: %w", err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %T, expected ed25519", key)
+	}
+	return ed25519Signer(priv), nil
+}
+
+func (s ed25519Signer) Sign(message io.Reader) ([]byte, error) {
+	b, err := ioutil.ReadAll(message)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.Sign(ed25519.PrivateKey(s), b), nil
+}
+
+func (ed25519Signer) Extension() string { return Ed25519Extension }
+
+type ed25519Verifier ed25519.PublicKey
+
+// parseEd25519Verifier parses a key as extracted by `openssl pkey -pubout`.
+func parseEd25519Verifier(b []byte) (Verifier, error) {
+	block, _ := pem.Decode(b)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("expected PEM encoded PUBLIC KEY")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key %T, expected ed25519", key)
+	}
+	return ed25519Verifier(pub), nil
+}
+
+func (v ed25519Verifier) Verify(message io.Reader, sig []byte) error {
+	b, err := ioutil.ReadAll(message)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(v), b, sig) {
+		return errInvalidEd25519
+	}
+	return nil
+}
+
+func (ed25519Verifier) Extension() string { return Ed25519Extension }
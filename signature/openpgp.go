@@ -0,0 +1,95 @@
+package signature
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// OpenPGPExtension names ASCII armored OpenPGP signatures, which `gpg --verify` can also check.
+const OpenPGPExtension = ".asc"
+
+// readKeyRing reads an OpenPGP keyring, either ASCII armored or binary as exported by `gpg --export`.
+func readKeyRing(b []byte) (openpgp.EntityList, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("-----BEGIN PGP ")) {
+		return openpgp.ReadArmoredKeyRing(bytes.NewReader(b))
+	}
+	return openpgp.ReadKeyRing(bytes.NewReader(b))
+}
+
+type openPGPSigner struct {
+	entity *openpgp.Entity
+}
+
+func parseOpenPGPSigner(b []byte, keyID string, passphrase []byte) (Signer, error) {
+	keyring, err := readKeyRing(b)
+	if err != nil {
+		return nil, fmt.Errorf("reading OpenPGP keyring: %w", err)
+	}
+
+	var candidates []*openpgp.Entity
+	for _, e := range keyring {
+		if e.PrivateKey == nil {
+			continue
+		}
+		if keyID == "" || matchesKeyID(e, keyID) {
+			candidates = append(candidates, e)
+		}
+	}
+	switch {
+	case len(candidates) == 0 && keyID != "":
+		return nil, fmt.Errorf("keyring has no secret key %q", keyID)
+	case len(candidates) == 0:
+		return nil, fmt.Errorf("keyring has no secret keys")
+	case len(candidates) > 1:
+		return nil, fmt.Errorf("keyring has %d secret keys, select one by ID", len(candidates))
+	}
+
+	entity := candidates[0]
+	if entity.PrivateKey.Encrypted {
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("secret key %s is encrypted, a passphrase is required", entity.PrimaryKey.KeyIdString())
+		}
+		if err := entity.PrivateKey.Decrypt(passphrase); err != nil {
+			return nil, fmt.Errorf("decrypting secret key %s: %w", entity.PrimaryKey.KeyIdString(), err)
+		}
+	}
+	return &openPGPSigner{entity: entity}, nil
+}
+
+// matchesKeyID returns true if id is a suffix of the key's fingerprint, e.g. its long or short key ID.
+func matchesKeyID(e *openpgp.Entity, id string) bool {
+	fingerprint := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+	id = strings.ToUpper(strings.TrimPrefix(strings.ReplaceAll(id, " ", ""), "0x"))
+	return strings.HasSuffix(fingerprint, id)
+}
+
+func (s *openPGPSigner) Sign(message io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&buf, s.entity, message, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (*openPGPSigner) Extension() string { return OpenPGPExtension }
+
+type openPGPVerifier openpgp.EntityList
+
+func parseOpenPGPVerifier(b []byte) (Verifier, error) {
+	keyring, err := readKeyRing(b)
+	if err != nil {
+		return nil, fmt.Errorf("reading OpenPGP keyring: %w", err)
+	}
+	return openPGPVerifier(keyring), nil
+}
+
+func (v openPGPVerifier) Verify(message io.Reader, sig []byte) error {
+	_, err := openpgp.CheckArmoredDetachedSignature(openpgp.EntityList(v), message, bytes.NewReader(sig), nil)
+	return err
+}
+
+func (openPGPVerifier) Extension() string { return OpenPGPExtension }
@@ -0,0 +1,105 @@
+// Package signature signs lockfiles and exported images with local keys, as detached signatures beside them.
+package signature
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// ErrUnsigned is returned when a file has no signature.
+var ErrUnsigned = errors.New("file is not signed")
+
+// Signer creates detached signatures.
+type Signer interface {
+	// Sign returns a detached signature of message.
+	Sign(message io.Reader) ([]byte, error)
+	// Extension is appended to a file's path to name its signature, e.g. ".sig".
+	Extension() string
+}
+
+// Verifier checks detached signatures.
+type Verifier interface {
+	// Verify returns an error unless sig is a valid signature of message.
+	Verify(message io.Reader, sig []byte) error
+	Extension() string
+}
+
+// LoadSigner reads a private key: either an ed25519 key in PKCS #8 PEM, or an OpenPGP keyring.
+// keyID selects a key from a keyring containing several, passphrase decrypts it.
+func LoadSigner(path, keyID string, passphrase []byte) (Signer, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isPEM(b) {
+		return parseEd25519Signer(b)
+	}
+	return parseOpenPGPSigner(b, keyID, passphrase)
+}
+
+// LoadVerifier reads a public key: either an ed25519 key in PKIX PEM, or an OpenPGP keyring.
+func LoadVerifier(path string) (Verifier, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if isPEM(b) {
+		return parseEd25519Verifier(b)
+	}
+	return parseOpenPGPVerifier(b)
+}
+
+// isPEM returns true for PEM encoded keys. OpenPGP armor has a similar header, so is excluded.
+func isPEM(b []byte) bool {
+	b = bytes.TrimSpace(b)
+	return bytes.HasPrefix(b, []byte("-----BEGIN ")) && !bytes.HasPrefix(b, []byte("-----BEGIN PGP "))
+}
+
+// Filename returns the path of a file's signature.
+func Filename(path, ext string) string {
+	return path + ext
+}
+
+// SignFile writes the signature of a file beside it, returning the signature's path.
+func SignFile(s Signer, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sig, err := s.Sign(f)
+	if err != nil {
+		return "", fmt.Errorf("signing %q: %w", path, err)
+	}
+
+	sigPath := Filename(path, s.Extension())
+	if err := ioutil.WriteFile(sigPath, sig, 0644); err != nil {
+		return "", err
+	}
+	return sigPath, nil
+}
+
+// VerifyFile checks the signature beside a file, returning ErrUnsigned if there isn't one.
+func VerifyFile(v Verifier, path string) error {
+	sigPath := Filename(path, v.Extension())
+	sig, err := ioutil.ReadFile(sigPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%q: %w", path, ErrUnsigned)
+	} else if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := v.Verify(f, sig); err != nil {
+		return fmt.Errorf("verifying %q: %w", sigPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package signature_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thepwagner/debendabot/signature"
+)
+
+func writeFile(t *testing.T, dir, name string, b []byte) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, b, 0600))
+	return path
+}
+
+func writeEd25519Keys(t *testing.T, dir, name string) (string, string) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	pubDER, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	return writeFile(t, dir, name+".pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})),
+		writeFile(t, dir, name+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))
+}
+
+func writeOpenPGPKeys(t *testing.T, dir, name string) (string, string) {
+	e, err := openpgp.NewEntity(name, "", name+"@example.com", &packet.Config{RSABits: 1024})
+	require.NoError(t, err)
+
+	var priv bytes.Buffer
+	w, err := armor.Encode(&priv, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, e.SerializePrivate(w, nil))
+	require.NoError(t, w.Close())
+
+	var pub bytes.Buffer
+	require.NoError(t, e.Serialize(&pub))
+	return writeFile(t, dir, name+".asc", priv.Bytes()), writeFile(t, dir, name+".gpg", pub.Bytes())
+}
+
+func TestSignFile(t *testing.T) {
+	cases := map[string]struct {
+		keys func(t *testing.T, dir, name string) (string, string)
+		ext  string
+	}{
+		"ed25519": {keys: writeEd25519Keys, ext: signature.Ed25519Extension},
+		"openpgp": {keys: writeOpenPGPKeys, ext: signature.OpenPGPExtension},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			privPath, pubPath := tc.keys(t, dir, "test")
+			_, otherPubPath := tc.keys(t, dir, "other")
+			lockfile := writeFile(t, dir, "dpkg-lock.json", []byte(`{"image":"debian:buster"}`))
+
+			verifier, err := signature.LoadVerifier(pubPath)
+			require.NoError(t, err)
+			err = signature.VerifyFile(verifier, lockfile)
+			assert.True(t, errors.Is(err, signature.ErrUnsigned))
+
+			signer, err := signature.LoadSigner(privPath, "", nil)
+			require.NoError(t, err)
+			sigPath, err := signature.SignFile(signer, lockfile)
+			require.NoError(t, err)
+			assert.Equal(t, lockfile+tc.ext, sigPath)
+			assert.NoError(t, signature.VerifyFile(verifier, lockfile))
+
+			other, err := signature.LoadVerifier(otherPubPath)
+			require.NoError(t, err)
+			assert.Error(t, signature.VerifyFile(other, lockfile))
+
+			writeFile(t, dir, "dpkg-lock.json", []byte(`{"image":"debian:bullseye"}`))
+			err = signature.VerifyFile(verifier, lockfile)
+			assert.Error(t, err)
+			assert.False(t, errors.Is(err, signature.ErrUnsigned))
+		})
+	}
+}
+
+func TestLoadSigner_KeyID(t *testing.T) {
+	dir := t.TempDir()
+	first, err := openpgp.NewEntity("first", "", "first@example.com", &packet.Config{RSABits: 1024})
+	require.NoError(t, err)
+	second, err := openpgp.NewEntity("second", "", "second@example.com", &packet.Config{RSABits: 1024})
+	require.NoError(t, err)
+	var keyring bytes.Buffer
+	require.NoError(t, first.SerializePrivate(&keyring, nil))
+	require.NoError(t, second.SerializePrivate(&keyring, nil))
+	path := writeFile(t, dir, "keyring.gpg", keyring.Bytes())
+
+	_, err = signature.LoadSigner(path, "", nil)
+	assert.EqualError(t, err, "keyring has 2 secret keys, select one by ID")
+	_, err = signature.LoadSigner(path, "DEADBEEF", nil)
+	assert.EqualError(t, err, `keyring has no secret key "DEADBEEF"`)
+
+	signer, err := signature.LoadSigner(path, "0x"+second.PrimaryKey.KeyIdString(), nil)
+	require.NoError(t, err)
+	sig, err := signer.Sign(bytes.NewReader([]byte("test")))
+	require.NoError(t, err)
+	signed, err := openpgp.CheckArmoredDetachedSignature(openpgp.EntityList{second}, bytes.NewReader([]byte("test")), bytes.NewReader(sig), nil)
+	require.NoError(t, err)
+	assert.Equal(t, second.PrimaryKey.KeyId, signed.PrimaryKey.KeyId)
+}